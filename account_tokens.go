@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Purposes an account token can be issued for; a token only works for the purpose it was signed with.
+const (
+	tokenPurposeVerifyEmail   = "verify-email"
+	tokenPurposeResetPassword = "reset-password"
+)
+
+const (
+	// verificationTokenTTL is how long a verification link works.
+	verificationTokenTTL = 24 * time.Hour
+	// resetTokenTTL is how long a password reset link works.
+	resetTokenTTL = time.Hour
+)
+
+// verifyToConfirmMessage tells an unverified user why their booking is still pending.
+const verifyToConfirmMessage = "Booking received! Verify your email address soon to confirm it; the tickets are only held for a short while."
+
+// errVerifyToPay turns unverified users away from paid bookings, as checkout does.
+var errVerifyToPay = validationError("please verify your email address before buying tickets")
+
+// errInvalidToken covers malformed, tampered, expired and already used links alike.
+var errInvalidToken = errors.New("this link is invalid or has expired")
+
+// accountTokenSecret signs account tokens. Set ACCOUNT_TOKEN_SECRET so emailed links survive restarts.
+var accountTokenSecret = getAccountTokenSecret()
+
+func getAccountTokenSecret() []byte {
+	if secret := os.Getenv("ACCOUNT_TOKEN_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("account tokens: cannot generate secret: " + err.Error())
+	}
+	return secret
+}
+
+// getAppBaseURL is the public address used in emailed links, from APP_BASE_URL.
+func getAppBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// tokenBinding ties a token to account state so it stops working when that state changes: a
+// verification link to the address it was sent to, a reset link to the password it replaces.
+func tokenBinding(purpose string, user User) string {
+	if purpose == tokenPurposeResetPassword {
+		return user.Password
+	}
+	return user.Email
+}
+
+// signAccountToken issues a "userID.expiry.signature" token for purpose.
+func signAccountToken(purpose string, user User, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", user.ID, expires.Unix())
+	return payload + "." + accountTokenMAC(purpose, payload, tokenBinding(purpose, user))
+}
+
+func accountTokenMAC(purpose, payload, binding string) string {
+	mac := hmac.New(sha256.New, accountTokenSecret)
+	mac.Write([]byte(purpose + "\x00" + payload + "\x00" + binding))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseAccountToken checks a token's signature and expiry and returns the account it was issued to.
+func parseAccountToken(purpose, token string) (User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return User{}, errInvalidToken
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return User{}, errInvalidToken
+	}
+
+	user, err := store.GetUser(userID)
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+	expected := accountTokenMAC(purpose, parts[0]+"."+parts[1], tokenBinding(purpose, user))
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return User{}, errInvalidToken
+	}
+	return user, nil
+}
+
+// verificationEmailTemplate is the email template for confirming a new account's address.
+const verificationEmailTemplate = `Hello %s,
+
+Please confirm your email address by opening this link:
+
+%s
+
+The link expires in 24 hours. Free bookings you make before confirming are only held for a short while.
+
+Best regards,
+Booking Team
+`
+
+// passwordResetEmailTemplate is the email template for resetting a forgotten password.
+const passwordResetEmailTemplate = `Hello %s,
+
+Someone asked to reset the password for your account. To choose a new password, open this link:
+
+%s
+
+The link expires in 1 hour. If you did not ask for this, you can ignore this email.
+
+Best regards,
+Booking Team
+`
+
+// sendVerificationEmail mails the user a link to /verify.
+func sendVerificationEmail(user User) {
+	token := signAccountToken(tokenPurposeVerifyEmail, user, time.Now().Add(verificationTokenTTL))
+	link := getAppBaseURL() + "/verify?token=" + url.QueryEscape(token)
+	sendAccountEmail(user.Email, "Confirm your email address", fmt.Sprintf(verificationEmailTemplate, user.Username, link), link)
+}
+
+// sendPasswordResetEmail mails the user a link to /reset-password.
+func sendPasswordResetEmail(user User) {
+	token := signAccountToken(tokenPurposeResetPassword, user, time.Now().Add(resetTokenTTL))
+	link := getAppBaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	sendAccountEmail(user.Email, "Reset your password", fmt.Sprintf(passwordResetEmailTemplate, user.Username, link), link)
+}
+
+// sendAccountEmail sends an account link, or simulates sending if it fails. The link lets whoever holds
+// it into the account, so it is only printed when PRINT_EMAIL_LINKS=1 is set for local development.
+func sendAccountEmail(recipient, subject, body, link string) {
+	summary := fmt.Sprintf("Sending %q to %s", subject, recipient)
+	if os.Getenv("PRINT_EMAIL_LINKS") == "1" {
+		summary += ": " + link
+	}
+	sendOrSimulate(recipient, subject, body, summary)
+}
+
+// awaitingVerification reports whether bookings by userID must wait for the user to verify their email.
+func awaitingVerification(userID int) bool {
+	if userID == 0 {
+		return false
+	}
+	user, err := store.GetUser(userID)
+	return err == nil && !user.EmailVerified
+}
+
+// verifyEmail marks the token's account as verified and confirms the bookings that were waiting on it.
+func verifyEmail(token string) (User, error) {
+	user, err := parseAccountToken(tokenPurposeVerifyEmail, token)
+	if err != nil {
+		return User{}, err
+	}
+	if !user.EmailVerified {
+		if err := store.SetEmailVerified(user.ID); err != nil {
+			return User{}, err
+		}
+		user.EmailVerified = true
+	}
+	if err := confirmVerifiedBookings(user); err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// confirmVerifiedBookings confirms the free bookings a newly verified user made while unverified, if
+// they are still held, and sends their ticket confirmations.
+func confirmVerifiedBookings(user User) error {
+	all, err := store.ListBookings()
+	if err != nil {
+		return err
+	}
+
+	reload := false
+	for _, booking := range all {
+		if booking.UserID != user.ID || booking.Status != "pending" || !booking.AwaitingVerification || booking.AwaitsPayment() {
+			continue
+		}
+		if err := store.ConfirmBooking(booking.ID); err != nil {
+			fmt.Printf("Could not confirm booking %d after verification: %v\n", booking.ID, err)
+			continue
+		}
+		reload = reload || booking.EventID == defaultEventID
+		sendInBackground(func() { sendTicketConfirmation(bookingConfirmation(booking)) })
+	}
+	if reload {
+		return loadBookings()
+	}
+	return nil
+}
+
+// resetPassword sets a new password for the token's account and signs it out everywhere.
+func resetPassword(token, newPassword string) error {
+	user, err := parseAccountToken(tokenPurposeResetPassword, token)
+	if err != nil {
+		return err
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := store.UpdateUserPassword(user.ID, hash); err != nil {
+		return err
+	}
+	_, err = store.DeleteUserSessions(user.ID)
+	return err
+}
+
+// verifyHandler handles the link from the verification email.
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := verifyEmail(r.URL.Query().Get("token")); err != nil {
+		http.Redirect(w, r, "/login?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/login?message="+url.QueryEscape("Your email address is verified."), http.StatusSeeOther)
+}
+
+// resendVerificationHandler sends the signed-in user a fresh verification link.
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := currentUser(r)
+	if !user.EmailVerified {
+		sendInBackground(func() { sendVerificationEmail(user) })
+	}
+	http.Redirect(w, r, "/my-bookings?message="+url.QueryEscape("Verification email sent to "+user.Email), http.StatusSeeOther)
+}
+
+// forgotPasswordHandler emails a reset link. It answers the same whether or not the account exists.
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if user, err := store.GetUserByUsername(r.FormValue("username")); err == nil {
+			sendInBackground(func() { sendPasswordResetEmail(user) })
+		}
+		message := "If that account exists, a password reset link has been sent to its email address."
+		http.Redirect(w, r, "/login?message="+url.QueryEscape(message), http.StatusSeeOther)
+		return
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head><title>Forgot Password</title></head>
+<body>
+	<h2>Forgot Password</h2>
+	<form method="POST">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<div>
+			<label>Username:</label>
+			<input type="text" name="username" required>
+		</div>
+		<button type="submit">Send reset link</button>
+	</form>
+	<p><a href="/login">Login</a></p>
+</body>
+</html>`
+
+	renderAuthForm(w, r, tmpl)
+}
+
+// resetPasswordHandler shows the new password form for a reset link and applies it.
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := resetPassword(r.FormValue("token"), r.FormValue("password")); err != nil {
+			http.Redirect(w, r, "/login?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/login?message="+url.QueryEscape("Your password has been changed. Please log in."), http.StatusSeeOther)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if _, err := parseAccountToken(tokenPurposeResetPassword, token); err != nil {
+		http.Redirect(w, r, "/login?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head><title>Reset Password</title></head>
+<body>
+	<h2>Choose a New Password</h2>
+	<form method="POST">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+		<input type="hidden" name="token" value="{{.Token}}">
+		<div>
+			<label>New password:</label>
+			<input type="password" name="password" required>
+		</div>
+		<button type="submit">Change password</button>
+	</form>
+</body>
+</html>`
+
+	t, _ := template.New("reset-password").Parse(tmpl)
+	data := struct {
+		Token     string
+		CSRFToken string
+	}{
+		Token:     token,
+		CSRFToken: csrfToken(w, r),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	t.Execute(w, data)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestResetPassword resets a password with an emailed token: the new password works, the account is
+// signed out everywhere, and the token cannot be used again.
+func TestResetPassword(t *testing.T) {
+	useStore(t, newMemoryStore())
+	hash, err := hashPassword("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createPasswordUser(t, "ada", hash)
+	if err := store.CreateSession(Session{Token: "session", UserID: user.ID, Expires: time.Now().Add(sessionTTL)}); err != nil {
+		t.Fatal(err)
+	}
+	token := signAccountToken(tokenPurposeResetPassword, user, time.Now().Add(resetTokenTTL))
+
+	if err := resetPassword(token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := checkPassword(storedPassword(t, user.ID), "new password"); !ok {
+		t.Fatal("the new password does not work")
+	}
+	if _, err := store.GetSession("session"); !errors.Is(err, errNotFound) {
+		t.Fatalf("session after the reset: %v, want errNotFound", err)
+	}
+
+	if err := resetPassword(token, "another password"); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("reusing the token: %v, want errInvalidToken", err)
+	}
+	if ok, _ := checkPassword(storedPassword(t, user.ID), "new password"); !ok {
+		t.Fatal("reusing the token changed the password")
+	}
+}
+
+// TestInvalidResetTokens refuses expired, tampered and misdirected reset tokens without changing the password.
+func TestInvalidResetTokens(t *testing.T) {
+	useStore(t, newMemoryStore())
+	user := createPasswordUser(t, "ada", legacyHashPassword("old password"))
+	other := createPasswordUser(t, "grace", legacyHashPassword("other password"))
+	valid := signAccountToken(tokenPurposeResetPassword, user, time.Now().Add(resetTokenTTL))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"malformed", "not-a-token"},
+		{"expired", signAccountToken(tokenPurposeResetPassword, user, time.Now().Add(-time.Second))},
+		{"another user's ID", strings.Join([]string{strconv.Itoa(other.ID), parts[1], parts[2]}, ".")},
+		{"extended expiry", strings.Join([]string{parts[0], "9999999999", parts[2]}, ".")},
+		{"altered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))},
+		{"verification token", signAccountToken(tokenPurposeVerifyEmail, user, time.Now().Add(resetTokenTTL))},
+		{"missing user", signAccountToken(tokenPurposeResetPassword, User{ID: other.ID + 1}, time.Now().Add(resetTokenTTL))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := resetPassword(tt.token, "new password"); !errors.Is(err, errInvalidToken) {
+				t.Fatalf("%v, want errInvalidToken", err)
+			}
+		})
+	}
+	if stored := storedPassword(t, user.ID); stored != legacyHashPassword("old password") {
+		t.Fatal("an invalid token changed the password")
+	}
+	if stored := storedPassword(t, other.ID); stored != legacyHashPassword("other password") {
+		t.Fatal("an invalid token changed another user's password")
+	}
+}
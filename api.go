@@ -0,0 +1,1093 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiRoutes is the /api/v1 surface.
+var apiRoutes = []httpRoute{
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/login", Summary: "Sign in and start a session"}, apiLoginHandler),
+	{Method: "POST", Path: "/api/v1/logout", Summary: "End the current session", Auth: true,
+		Status: http.StatusNoContent, Handler: apiLogoutHandler},
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/me", Summary: "The signed-in user", Auth: true, Scope: scopeAccountRead}, apiMeHandler),
+
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/events", Summary: "List events", Scope: scopeEventsRead}, apiListEventsHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/events", Summary: "Create an event", Auth: true, Permission: permManageEvents, Scope: scopeEventsWrite,
+		Status: http.StatusCreated}, apiCreateEventHandler),
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/events/{id}", Summary: "Get an event", Scope: scopeEventsRead}, apiGetEventHandler),
+	jsonRoute(httpRoute{Method: "PATCH", Path: "/api/v1/events/{id}", Summary: "Update an event", Auth: true, Permission: permManageEvents,
+		Scope: scopeEventsWrite}, apiUpdateEventHandler),
+	{Method: "DELETE", Path: "/api/v1/events/{id}", Summary: "Delete an event that has no bookings", Auth: true, Permission: permManageEvents, Scope: scopeEventsWrite,
+		Status: http.StatusNoContent, Handler: apiDeleteEventHandler},
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/events/{id}/ticket-types", Summary: "List an event's ticket tiers",
+		Scope: scopeEventsRead}, apiListTicketTypesHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/events/{id}/ticket-types", Summary: "Add a ticket tier to an event", Auth: true, Permission: permManageEvents,
+		Scope: scopeEventsWrite, Status: http.StatusCreated}, apiCreateTicketTypeHandler),
+	jsonRoute(httpRoute{Method: "PATCH", Path: "/api/v1/events/{id}/ticket-types/{typeID}", Summary: "Update a ticket tier", Auth: true,
+		Permission: permManageEvents, Scope: scopeEventsWrite}, apiUpdateTicketTypeHandler),
+	{Method: "DELETE", Path: "/api/v1/events/{id}/ticket-types/{typeID}", Summary: "Delete a ticket tier that has no bookings", Auth: true, Permission: permManageEvents, Scope: scopeEventsWrite,
+		Status: http.StatusNoContent, Handler: apiDeleteTicketTypeHandler},
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/events/{id}/seats", Summary: "List an event's seats and whether each is taken",
+		Scope: scopeEventsRead}, apiListSeatsHandler),
+	jsonRoute(httpRoute{Method: "PUT", Path: "/api/v1/events/{id}/seats", Summary: "Replace the seat map of an event that has no bookings", Auth: true,
+		Permission: permManageEvents, Scope: scopeEventsWrite}, apiSetSeatMapHandler),
+
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/checkins", Summary: "Check in a scanned ticket at the door", Auth: true, Permission: permCheckIn,
+		Scope: scopeCheckIn}, apiCheckInHandler),
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/events/{id}/checkins", Summary: "Count the people admitted to an event", Auth: true, Permission: permCheckIn,
+		Scope: scopeCheckIn}, apiCheckInStatsHandler),
+
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/discounts", Summary: "List discount codes with how often each was used", Auth: true,
+		Permission: permManageEvents, Scope: scopeEventsRead}, apiListDiscountCodesHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/discounts", Summary: "Create a discount code", Auth: true, Permission: permManageEvents,
+		Scope: scopeEventsWrite, Status: http.StatusCreated}, apiCreateDiscountCodeHandler),
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/discounts/{id}", Summary: "Get a discount code", Auth: true, Permission: permManageEvents,
+		Scope: scopeEventsRead}, apiGetDiscountCodeHandler),
+	jsonRoute(httpRoute{Method: "PATCH", Path: "/api/v1/discounts/{id}", Summary: "Update a discount code; set active to false to withdraw it", Auth: true,
+		Permission: permManageEvents, Scope: scopeEventsWrite}, apiUpdateDiscountCodeHandler),
+
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/bookings", Summary: "List bookings", Auth: true, Scope: scopeBookingsRead,
+		Query: []routeParam{
+			{Name: "event_id", Type: "integer", Description: "Only bookings for this event"},
+			{Name: "status", Type: "string", Description: "Only bookings with this status"},
+		},
+	}, apiListBookingsHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/bookings", Summary: "Book tickets", Auth: true, Scope: scopeBookingsWrite,
+		Status: http.StatusCreated}, apiCreateBookingHandler),
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/bookings/{id}", Summary: "Get a booking", Auth: true, Scope: scopeBookingsRead}, apiGetBookingHandler),
+	{Method: "GET", Path: "/api/v1/bookings/{id}/pdf", Summary: "Download a confirmed booking's receipt and tickets as a PDF", Auth: true,
+		Scope: scopeBookingsRead, Produces: "application/pdf", Handler: apiBookingPDFHandler},
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/bookings/{id}/cancel", Summary: "Cancel a booking and refund it per the refund policy", Auth: true,
+		Scope: scopeBookingsWrite}, apiCancelBookingHandler),
+
+	jsonRoute(httpRoute{Method: "GET", Path: "/api/v1/waitlist", Summary: "The caller's waitlist entries and offers", Auth: true,
+		Scope: scopeBookingsRead}, apiListWaitlistHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/events/{id}/waitlist", Summary: "Join an event's waitlist", Auth: true, Scope: scopeBookingsWrite,
+		Status: http.StatusCreated}, apiJoinWaitlistHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/api/v1/waitlist/{id}/claim", Summary: "Book the tickets held by a waitlist offer", Auth: true,
+		Scope: scopeBookingsWrite}, apiClaimWaitlistHandler),
+	{Method: "DELETE", Path: "/api/v1/waitlist/{id}", Summary: "Leave a waitlist, declining any offer", Auth: true, Scope: scopeBookingsWrite,
+		Status: http.StatusNoContent, Handler: apiLeaveWaitlistHandler},
+}
+
+// registerAPIRoutes mounts the JSON API on the default mux.
+func registerAPIRoutes() {
+	// paths matches a request to its route path regardless of method, to tell 404 from 405.
+	paths := http.NewServeMux()
+	allowed := map[string][]string{}
+	for _, route := range apiRoutes {
+		http.HandleFunc(route.Method+" "+route.Path, apiMiddleware(route))
+		if allowed[route.Path] == nil {
+			paths.HandleFunc(route.Path, http.NotFound)
+		}
+		allowed[route.Path] = append(allowed[route.Path], route.Method)
+	}
+
+	http.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		if _, path := paths.Handler(r); path != "" {
+			w.Header().Set("Allow", strings.Join(allowed[path], ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not supported here")
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+	http.HandleFunc("GET "+openAPIPath, openAPIHandler)
+}
+
+// APIError is the body of every failed API response.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, APIError{Error: APIErrorDetail{Code: code, Message: message}})
+}
+
+// apiMiddleware authenticates API requests by API key or session cookie and checks their scope and
+// permission. Requests with a body must be JSON, and cookie-authenticated changes must carry the
+// X-CSRF-Token header.
+func apiMiddleware(route httpRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if r.ContentLength != 0 && mediaType != "application/json" {
+				writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "request body must be application/json")
+				return
+			}
+		}
+
+		if token, sent := bearerToken(r); sent {
+			key, user, err := authenticateAPIKey(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeAPIError(w, http.StatusUnauthorized, "invalid_api_key", err.Error())
+				return
+			}
+			switch {
+			case route.Scope == "" && route.Auth:
+				writeAPIError(w, http.StatusForbidden, "insufficient_scope", "API keys cannot be used here")
+				return
+			case route.Scope != "" && !key.HasScope(route.Scope):
+				writeAPIError(w, http.StatusForbidden, "insufficient_scope", "this API key needs the "+route.Scope+" scope")
+				return
+			case route.Permission != "" && !user.Can(route.Permission):
+				writeAPIError(w, http.StatusForbidden, "forbidden", "your role does not allow this")
+				return
+			}
+			route.Handler(w, withUser(r, user))
+			return
+		}
+
+		if route.Auth {
+			cookie, err := r.Cookie("session_token")
+			if err != nil {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized", "sign in required")
+				return
+			}
+			session, user, valid := lookupSession(cookie.Value)
+			if !valid {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized", "sign in required")
+				return
+			}
+			if r.Method != "GET" && !validCSRFToken(r) {
+				writeAPIError(w, http.StatusForbidden, "csrf_failed", "missing or invalid X-CSRF-Token header")
+				return
+			}
+			if route.Permission != "" && !user.Can(route.Permission) {
+				writeAPIError(w, http.StatusForbidden, "forbidden", "your role does not allow this")
+				return
+			}
+			if renewed, ok := slideSession(session); ok {
+				setSessionCookie(w, renewed)
+			}
+		}
+
+		route.Handler(w, r)
+	}
+}
+
+// decodeJSON reads a request body into v, rejecting unknown fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_request", "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// pathID parses the {id} path value.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusNotFound, "not_found", "not found")
+		return 0, false
+	}
+	return id, true
+}
+
+// APIUser is the public view of a User.
+type APIUser struct {
+	ID            int       `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+	Created       time.Time `json:"created"`
+	CSRFToken     string    `json:"csrf_token,omitempty"` // send as X-CSRF-Token when changing data
+}
+
+// newAPIUser builds the public view of user, with a CSRF token when they signed in with a session.
+func newAPIUser(user User, sessionToken string) APIUser {
+	apiUser := APIUser{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		Created:       user.Created,
+	}
+	if sessionToken != "" {
+		apiUser.CSRFToken = signCSRF("session:" + sessionToken)
+	}
+	return apiUser
+}
+
+// LoginRequest is the body of POST /api/v1/login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func apiLoginHandler(w http.ResponseWriter, r *http.Request, body jsonBody[LoginRequest, APIUser]) {
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := loginUser(req.Username, req.Password, clientIP(r))
+	switch {
+	case errors.Is(err, errLoginThrottled):
+		writeAPIError(w, http.StatusTooManyRequests, "throttled", err.Error())
+		return
+	case errors.Is(err, errInvalidCredentials):
+		writeAPIError(w, http.StatusUnauthorized, "invalid_credentials", err.Error())
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not sign in")
+		return
+	}
+
+	session, user, _ := lookupSession(token)
+	setSessionCookie(w, session)
+	body.write(w, http.StatusOK, newAPIUser(user, token))
+}
+
+func apiLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, _ := r.Cookie("session_token")
+	if err := store.DeleteSession(cookie.Value); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not sign out")
+		return
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiMeHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, APIUser]) {
+	user, _ := currentUser(r)
+	sessionToken := ""
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		sessionToken = cookie.Value
+	}
+	body.write(w, http.StatusOK, newAPIUser(user, sessionToken))
+}
+
+// EventRequest creates or updates an event. On update, omitted fields keep their current value.
+type EventRequest struct {
+	Name         *string    `json:"name"`
+	Description  *string    `json:"description"`
+	Date         *time.Time `json:"date"`
+	Location     *string    `json:"location"`
+	TotalTickets *int       `json:"total_tickets"`
+	TicketPrice  *float64   `json:"ticket_price"`
+	Currency     *string    `json:"currency"`
+	Active       *bool      `json:"active"`
+}
+
+// apply copies the fields present in the request onto event.
+func (req EventRequest) apply(event *Event) {
+	if req.Name != nil {
+		event.Name = *req.Name
+	}
+	if req.Description != nil {
+		event.Description = *req.Description
+	}
+	if req.Date != nil {
+		event.Date = *req.Date
+	}
+	if req.Location != nil {
+		event.Location = *req.Location
+	}
+	if req.TotalTickets != nil {
+		event.TotalTickets = *req.TotalTickets
+	}
+	if req.TicketPrice != nil {
+		event.TicketPrice = *req.TicketPrice
+	}
+	if req.Currency != nil {
+		event.Currency = *req.Currency
+	}
+	if req.Active != nil {
+		event.Active = *req.Active
+	}
+}
+
+// EventList is the body of GET /api/v1/events.
+type EventList struct {
+	Events []Event `json:"events"`
+}
+
+func apiListEventsHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, EventList]) {
+	list, err := store.ListEvents()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load events")
+		return
+	}
+
+	user, _ := currentUser(r)
+	events := make([]Event, 0, len(list))
+	for _, event := range list {
+		if event.Active || user.Can(permManageEvents) {
+			events = append(events, event)
+		}
+	}
+	body.write(w, http.StatusOK, EventList{Events: events})
+}
+
+func apiGetEventHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, Event]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	event, err := store.GetEvent(id)
+	if err != nil || (!event.Active && !user.Can(permManageEvents)) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	}
+	body.write(w, http.StatusOK, event)
+}
+
+func apiCreateEventHandler(w http.ResponseWriter, r *http.Request, body jsonBody[EventRequest, Event]) {
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	event := Event{Currency: defaultCurrency, Active: true}
+	req.apply(&event)
+	created, err := addEvent(event)
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/events/"+strconv.Itoa(created.ID))
+	body.write(w, http.StatusCreated, created)
+}
+
+func apiUpdateEventHandler(w http.ResponseWriter, r *http.Request, body jsonBody[EventRequest, Event]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	event, err := store.GetEvent(id)
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	req.apply(&event)
+	updated, err := editEvent(event)
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	body.write(w, http.StatusOK, updated)
+}
+
+func apiDeleteEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := deleteEvent(id); err != nil {
+		writeEventError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeEventError maps the errors of addEvent, editEvent and deleteEvent to API errors.
+func writeEventError(w http.ResponseWriter, err error) {
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+	case errors.Is(err, errCapacityBelowSold):
+		writeAPIError(w, http.StatusConflict, "capacity_below_sold", err.Error())
+	case errors.Is(err, errEventHasBookings), errors.Is(err, errDefaultEventRequired):
+		writeAPIError(w, http.StatusConflict, "event_in_use", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not save event")
+	}
+}
+
+// TicketTypeRequest creates or updates a ticket tier. On update, omitted fields keep their current value.
+type TicketTypeRequest struct {
+	Name        *string    `json:"name"`
+	Price       *float64   `json:"price"`
+	Quota       *int       `json:"quota"`
+	SalesStart  *time.Time `json:"sales_start"`
+	SalesEnd    *time.Time `json:"sales_end"`
+	MinPerOrder *int       `json:"min_per_order"`
+	MaxPerOrder *int       `json:"max_per_order"`
+}
+
+// apply copies the fields present in the request onto t.
+func (req TicketTypeRequest) apply(t *TicketType) {
+	if req.Name != nil {
+		t.Name = *req.Name
+	}
+	if req.Price != nil {
+		t.Price = *req.Price
+	}
+	if req.Quota != nil {
+		t.Quota = *req.Quota
+	}
+	if req.SalesStart != nil {
+		t.SalesStart = req.SalesStart
+	}
+	if req.SalesEnd != nil {
+		t.SalesEnd = req.SalesEnd
+	}
+	if req.MinPerOrder != nil {
+		t.MinPerOrder = *req.MinPerOrder
+	}
+	if req.MaxPerOrder != nil {
+		t.MaxPerOrder = *req.MaxPerOrder
+	}
+}
+
+// TicketTypeList is the body of GET /api/v1/events/{id}/ticket-types.
+type TicketTypeList struct {
+	TicketTypes []TicketType `json:"ticket_types"`
+}
+
+func apiListTicketTypesHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, TicketTypeList]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	event, err := store.GetEvent(id)
+	if err != nil || (!event.Active && !user.Can(permManageEvents)) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	}
+	tiers, err := store.ListTicketTypes(id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load ticket types")
+		return
+	}
+	body.write(w, http.StatusOK, TicketTypeList{TicketTypes: tiers})
+}
+
+func apiCreateTicketTypeHandler(w http.ResponseWriter, r *http.Request, body jsonBody[TicketTypeRequest, TicketType]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	t := TicketType{EventID: id, MinPerOrder: 1}
+	req.apply(&t)
+	created, err := addTicketType(t)
+	if err != nil {
+		writeTicketTypeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/events/"+strconv.Itoa(id)+"/ticket-types/"+strconv.Itoa(created.ID))
+	body.write(w, http.StatusCreated, created)
+}
+
+func apiUpdateTicketTypeHandler(w http.ResponseWriter, r *http.Request, body jsonBody[TicketTypeRequest, TicketType]) {
+	t, ok := apiTicketType(w, r)
+	if !ok {
+		return
+	}
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	req.apply(&t)
+	updated, err := editTicketType(t)
+	if err != nil {
+		writeTicketTypeError(w, err)
+		return
+	}
+	body.write(w, http.StatusOK, updated)
+}
+
+func apiDeleteTicketTypeHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := apiTicketType(w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteTicketType(t.ID); err != nil {
+		writeTicketTypeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTicketType loads the {typeID} tier of the {id} event, answering 404 if there is none.
+func apiTicketType(w http.ResponseWriter, r *http.Request) (TicketType, bool) {
+	eventID, errEvent := strconv.Atoi(r.PathValue("id"))
+	id, errType := strconv.Atoi(r.PathValue("typeID"))
+	if errEvent != nil || errType != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "ticket type not found")
+		return TicketType{}, false
+	}
+	t, err := eventTicketType(eventID, id)
+	if err != nil {
+		writeTicketTypeError(w, err)
+		return TicketType{}, false
+	}
+	return t, true
+}
+
+// SeatMap is the body of GET and PUT /api/v1/events/{id}/seats.
+type SeatMap struct {
+	Seats []Seat `json:"seats"`
+}
+
+// SeatMapRequest replaces an event's seat map; no rows turns assigned seating off.
+type SeatMapRequest struct {
+	Rows []SeatRow `json:"rows"`
+}
+
+func apiListSeatsHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, SeatMap]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	event, err := store.GetEvent(id)
+	if err != nil || (!event.Active && !user.Can(permManageEvents)) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	}
+	seats, err := store.ListSeats(id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load seats")
+		return
+	}
+	body.write(w, http.StatusOK, SeatMap{Seats: seats})
+}
+
+func apiSetSeatMapHandler(w http.ResponseWriter, r *http.Request, body jsonBody[SeatMapRequest, SeatMap]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := setSeatMap(id, req.Rows)
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+		return
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	case errors.Is(err, errSeatMapLocked):
+		writeAPIError(w, http.StatusConflict, "seat_map_locked", err.Error())
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not save seat map")
+		return
+	}
+	seats, err := store.ListSeats(id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load seats")
+		return
+	}
+	body.write(w, http.StatusOK, SeatMap{Seats: seats})
+}
+
+// CheckInRequest is a ticket scanned at the door of an event.
+type CheckInRequest struct {
+	EventID int    `json:"event_id"`
+	Code    string `json:"code"` // the content of the ticket's QR code
+	Gate    string `json:"gate,omitempty"`
+}
+
+func apiCheckInHandler(w http.ResponseWriter, r *http.Request, body jsonBody[CheckInRequest, CheckIn]) {
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	checkIn, err := checkInTicket(req.Code, req.EventID, req.Gate, user)
+	var invalid validationError
+	switch {
+	case err == nil:
+		body.write(w, http.StatusOK, checkIn)
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+	case errors.Is(err, errInvalidTicketCode):
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_ticket", err.Error())
+	case errors.Is(err, errWrongEvent):
+		writeAPIError(w, http.StatusConflict, "wrong_event", err.Error())
+	case errors.Is(err, errTicketNotValid):
+		writeAPIError(w, http.StatusConflict, "ticket_not_valid", err.Error())
+	case errors.Is(err, errAlreadyCheckedIn):
+		writeAPIError(w, http.StatusConflict, "already_checked_in", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not check in ticket")
+	}
+}
+
+func apiCheckInStatsHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, CheckInStats]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := store.GetEvent(id); err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	}
+	stats, err := store.CheckInStats(id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not count check-ins")
+		return
+	}
+	body.write(w, http.StatusOK, stats)
+}
+
+// writeTicketTypeError maps the errors of addTicketType, editTicketType and DeleteTicketType to API errors.
+func writeTicketTypeError(w http.ResponseWriter, err error) {
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "ticket type not found")
+	case errors.Is(err, errQuotaBelowSold):
+		writeAPIError(w, http.StatusConflict, "quota_below_sold", err.Error())
+	case errors.Is(err, errTicketTypeHasBookings):
+		writeAPIError(w, http.StatusConflict, "ticket_type_in_use", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not save ticket type")
+	}
+}
+
+// DiscountCodeRequest creates or updates a discount code. On update, omitted fields keep their current
+// value and code cannot be changed.
+type DiscountCodeRequest struct {
+	Code           *string    `json:"code"`
+	Kind           *string    `json:"kind"`
+	Value          *float64   `json:"value"`
+	EventIDs       *[]int     `json:"event_ids"`
+	TicketTypeIDs  *[]int     `json:"ticket_type_ids"`
+	MaxUses        *int       `json:"max_uses"`
+	MaxUsesPerUser *int       `json:"max_uses_per_user"`
+	ValidFrom      *time.Time `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	Active         *bool      `json:"active"`
+}
+
+// apply copies the fields present in the request, other than the code, onto d.
+func (req DiscountCodeRequest) apply(d *DiscountCode) {
+	if req.Kind != nil {
+		d.Kind = *req.Kind
+	}
+	if req.Value != nil {
+		d.Value = *req.Value
+	}
+	if req.EventIDs != nil {
+		d.EventIDs = *req.EventIDs
+	}
+	if req.TicketTypeIDs != nil {
+		d.TicketTypeIDs = *req.TicketTypeIDs
+	}
+	if req.MaxUses != nil {
+		d.MaxUses = *req.MaxUses
+	}
+	if req.MaxUsesPerUser != nil {
+		d.MaxUsesPerUser = *req.MaxUsesPerUser
+	}
+	if req.ValidFrom != nil {
+		d.ValidFrom = req.ValidFrom
+	}
+	if req.ValidUntil != nil {
+		d.ValidUntil = req.ValidUntil
+	}
+	if req.Active != nil {
+		d.Active = *req.Active
+	}
+}
+
+// DiscountCodeList is the body of GET /api/v1/discounts.
+type DiscountCodeList struct {
+	DiscountCodes []DiscountCode `json:"discount_codes"`
+}
+
+func apiListDiscountCodesHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, DiscountCodeList]) {
+	list, err := store.ListDiscountCodes()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load discount codes")
+		return
+	}
+	body.write(w, http.StatusOK, DiscountCodeList{DiscountCodes: list})
+}
+
+func apiCreateDiscountCodeHandler(w http.ResponseWriter, r *http.Request, body jsonBody[DiscountCodeRequest, DiscountCode]) {
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	d := DiscountCode{Active: true, EventIDs: []int{}, TicketTypeIDs: []int{}}
+	if req.Code != nil {
+		d.Code = *req.Code
+	}
+	req.apply(&d)
+	created, err := addDiscountCode(d)
+	if err != nil {
+		writeDiscountCodeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/discounts/"+strconv.Itoa(created.ID))
+	body.write(w, http.StatusCreated, created)
+}
+
+func apiGetDiscountCodeHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, DiscountCode]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	d, err := store.GetDiscountCode(id)
+	if err != nil {
+		writeDiscountCodeError(w, err)
+		return
+	}
+	body.write(w, http.StatusOK, d)
+}
+
+func apiUpdateDiscountCodeHandler(w http.ResponseWriter, r *http.Request, body jsonBody[DiscountCodeRequest, DiscountCode]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	d, err := store.GetDiscountCode(id)
+	if err != nil {
+		writeDiscountCodeError(w, err)
+		return
+	}
+	if req.Code != nil && normalizeDiscountCode(*req.Code) != d.Code {
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "the code of a discount cannot be changed")
+		return
+	}
+	req.apply(&d)
+	updated, err := editDiscountCode(d)
+	if err != nil {
+		writeDiscountCodeError(w, err)
+		return
+	}
+	body.write(w, http.StatusOK, updated)
+}
+
+// writeDiscountCodeError maps the errors of addDiscountCode and editDiscountCode to API errors.
+func writeDiscountCodeError(w http.ResponseWriter, err error) {
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "discount code not found")
+	case errors.Is(err, errDiscountCodeTaken):
+		writeAPIError(w, http.StatusConflict, "code_taken", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not save discount code")
+	}
+}
+
+// BookingRequest is the body of POST /api/v1/bookings. Events with ticket tiers take Items;
+// Tickets books standard admission, or the only tier on sale.
+type BookingRequest struct {
+	EventID      int           `json:"event_id"`
+	FirstName    string        `json:"first_name"`
+	LastName     string        `json:"last_name"`
+	Email        string        `json:"email"`
+	Tickets      int           `json:"tickets,omitempty"`
+	Items        []TicketOrder `json:"items,omitempty"`
+	SeatIDs      []int         `json:"seat_ids,omitempty"`
+	DiscountCode string        `json:"discount_code,omitempty"`
+}
+
+// BookingList is the body of GET /api/v1/bookings.
+type BookingList struct {
+	Bookings []EventBooking `json:"bookings"`
+}
+
+// canSeeBooking reports whether user may read booking.
+func canSeeBooking(user User, booking EventBooking) bool {
+	return booking.UserID == user.ID || user.Can(permViewAllBookings)
+}
+
+func apiListBookingsHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, BookingList]) {
+	all, err := store.ListBookings()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load bookings")
+		return
+	}
+
+	eventID, _ := strconv.Atoi(r.URL.Query().Get("event_id"))
+	status := r.URL.Query().Get("status")
+
+	user, _ := currentUser(r)
+	bookings := make([]EventBooking, 0)
+	for _, booking := range all {
+		if !canSeeBooking(user, booking) {
+			continue
+		}
+		if (eventID != 0 && booking.EventID != eventID) || (status != "" && booking.Status != status) {
+			continue
+		}
+		bookings = append(bookings, booking)
+	}
+	body.write(w, http.StatusOK, BookingList{Bookings: bookings})
+}
+
+func apiGetBookingHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, EventBooking]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	booking, err := store.GetBooking(id)
+	if err != nil || !canSeeBooking(user, booking) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "booking not found")
+		return
+	}
+	body.write(w, http.StatusOK, booking)
+}
+
+func apiCreateBookingHandler(w http.ResponseWriter, r *http.Request, body jsonBody[BookingRequest, BookingCheckout]) {
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+
+	orders := req.Items
+	if len(orders) == 0 {
+		orders = ticketOrders(req.Tickets)
+	}
+	isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(req.FirstName, req.LastName, req.Email, uint(max(orderSize(orders), 0)), maxTicketsPerOrder)
+	switch {
+	case !isValidName:
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "first_name and last_name must be at least 2 characters")
+		return
+	case !isValidEmail:
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "email is invalid")
+		return
+	case !isValidTicketNumber:
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "tickets must be between 1 and "+strconv.Itoa(maxTicketsPerOrder))
+		return
+	}
+	if event, err := store.GetEvent(req.EventID); err != nil || !event.Active {
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	}
+
+	user, _ := currentUser(r)
+	booking, err := bookEventTicket(req.EventID, user.ID, req.FirstName, req.LastName, req.Email, orders, req.SeatIDs, req.DiscountCode)
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+		return
+	case errors.Is(err, errSoldOut):
+		writeAPIError(w, http.StatusConflict, "sold_out", err.Error())
+		return
+	case errors.Is(err, errEventInactive):
+		writeAPIError(w, http.StatusConflict, "event_inactive", err.Error())
+		return
+	case errors.Is(err, errDiscountUsedUp), errors.Is(err, errDiscountUserLimit):
+		writeAPIError(w, http.StatusConflict, "discount_unavailable", err.Error())
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not create booking")
+		return
+	}
+	checkout, err := bookingCheckout(*booking, user.ID)
+	if err != nil {
+		store.ReleaseHold(booking.ID, "cancelled")
+		writeAPIError(w, http.StatusBadGateway, "payment_failed", "could not start the payment: "+err.Error())
+		return
+	}
+	reloadDefaultEvent(booking.EventID)
+
+	w.Header().Set("Location", "/api/v1/bookings/"+strconv.Itoa(booking.ID))
+	body.write(w, http.StatusCreated, checkout)
+}
+
+// BookingCheckout is a new or claimed booking and, while it is held for payment, how to pay for it: confirm the
+// payment intent in Payment with the provider's SDK and the booking is confirmed once it succeeds.
+type BookingCheckout struct {
+	Booking EventBooking     `json:"booking"`
+	Payment *PaymentResponse `json:"payment,omitempty"`
+}
+
+// bookingCheckout starts the payment of a booking that awaits one; free bookings need none.
+func bookingCheckout(booking EventBooking, userID int) (BookingCheckout, error) {
+	if !booking.AwaitsPayment() {
+		return BookingCheckout{Booking: booking}, nil
+	}
+	hold, pi, err := holdPayment(booking.ID, userID)
+	if err != nil {
+		return BookingCheckout{}, err
+	}
+	payment := paymentResponse(hold, pi)
+	return BookingCheckout{Booking: hold, Payment: &payment}, nil
+}
+
+// CancelBookingResponse is the body of a successful POST /api/v1/bookings/{id}/cancel.
+type CancelBookingResponse struct {
+	Booking      EventBooking `json:"booking"`
+	RefundAmount float64      `json:"refund_amount"`
+}
+
+func apiCancelBookingHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, CancelBookingResponse]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+
+	refundAmount, err := cancelBookingForUser(id, user.ID)
+	switch {
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "booking not found")
+		return
+	case errors.Is(err, errAlreadyCancelled):
+		writeAPIError(w, http.StatusConflict, "already_cancelled", err.Error())
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusBadGateway, "refund_failed", err.Error())
+		return
+	}
+
+	booking, _ := store.GetBooking(id)
+	body.write(w, http.StatusOK, CancelBookingResponse{Booking: booking, RefundAmount: refundAmount})
+}
+
+// WaitlistRequest is the body of POST /api/v1/events/{id}/waitlist.
+type WaitlistRequest struct {
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	Tickets      int    `json:"tickets"`
+	TicketTypeID int    `json:"ticket_type_id,omitempty"`
+}
+
+// WaitlistList is the body of GET /api/v1/waitlist.
+type WaitlistList struct {
+	Entries []WaitlistEntry `json:"entries"`
+}
+
+func apiListWaitlistHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, WaitlistList]) {
+	user, _ := currentUser(r)
+	entries, err := store.ListUserWaitlist(user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not load waitlist")
+		return
+	}
+	body.write(w, http.StatusOK, WaitlistList{Entries: entries})
+}
+
+func apiJoinWaitlistHandler(w http.ResponseWriter, r *http.Request, body jsonBody[WaitlistRequest, WaitlistEntry]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req, ok := body.decode(w, r)
+	if !ok {
+		return
+	}
+	if event, err := store.GetEvent(id); err != nil || !event.Active {
+		writeAPIError(w, http.StatusNotFound, "not_found", "event not found")
+		return
+	}
+
+	user, _ := currentUser(r)
+	entry, err := joinWaitlist(id, user.ID, req.FirstName, req.LastName, req.Email,
+		TicketOrder{TicketTypeID: req.TicketTypeID, Quantity: req.Tickets})
+	if err != nil {
+		writeWaitlistError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/waitlist")
+	body.write(w, http.StatusCreated, entry)
+}
+
+func apiClaimWaitlistHandler(w http.ResponseWriter, r *http.Request, body jsonBody[noBody, BookingCheckout]) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	booking, err := claimWaitlistOffer(id, user.ID)
+	if err != nil {
+		writeWaitlistError(w, err)
+		return
+	}
+	checkout, err := bookingCheckout(booking, user.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "payment_failed", "could not start the payment: "+err.Error())
+		return
+	}
+	body.write(w, http.StatusOK, checkout)
+}
+
+func apiLeaveWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(r)
+	if err := leaveWaitlist(id, user.ID); err != nil {
+		writeWaitlistError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeWaitlistError maps the errors of joinWaitlist, claimWaitlistOffer and leaveWaitlist to API errors.
+func writeWaitlistError(w http.ResponseWriter, err error) {
+	var invalid validationError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusUnprocessableEntity, "validation_failed", err.Error())
+	case errors.Is(err, errNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "waitlist entry not found")
+	case errors.Is(err, errEventInactive):
+		writeAPIError(w, http.StatusConflict, "event_inactive", err.Error())
+	case errors.Is(err, errAlreadyWaitlisted):
+		writeAPIError(w, http.StatusConflict, "already_waitlisted", err.Error())
+	case errors.Is(err, errOfferExpired):
+		writeAPIError(w, http.StatusConflict, "offer_expired", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "waitlist request failed")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIKey lets a script or partner system call the JSON API as the user who created it.
+type APIKey struct {
+	ID       int
+	UserID   int
+	Name     string
+	Prefix   string // start of the key, shown so users can tell their keys apart
+	Hash     string // SHA-256 of the key; the key itself is only shown once, when it is created
+	Scopes   []string
+	Created  time.Time
+	LastUsed *time.Time
+	Revoked  *time.Time
+}
+
+// Scopes limit what an API key may do. Each JSON API route names the scope it needs.
+const (
+	scopeAccountRead   = "account:read"
+	scopeEventsRead    = "events:read"
+	scopeEventsWrite   = "events:write"
+	scopeBookingsRead  = "bookings:read"
+	scopeBookingsWrite = "bookings:write"
+	scopeCheckIn       = "tickets:check-in"
+)
+
+// apiKeyScopes lists every scope a key can be given.
+var apiKeyScopes = []string{scopeAccountRead, scopeEventsRead, scopeEventsWrite, scopeBookingsRead, scopeBookingsWrite, scopeCheckIn}
+
+// apiKeyPrefix starts every key, so leaked keys are easy to spot.
+const apiKeyPrefix = "bk_"
+
+// errInvalidAPIKey covers malformed, unknown and revoked keys alike.
+var errInvalidAPIKey = errors.New("invalid or revoked API key")
+
+// HasScope reports whether the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKey is how keys are stored and looked up. Keys are long and random, so a fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIKey issues a new key for userID and returns it in full; only its hash is stored.
+func createAPIKey(userID int, name string, scopes []string) (string, APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return "", APIKey{}, errors.New("name must be between 1 and 100 characters")
+	}
+	if len(scopes) == 0 {
+		return "", APIKey{}, errors.New("choose at least one scope")
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", APIKey{}, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	plaintext := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key, err := store.CreateAPIKey(APIKey{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  plaintext[:len(apiKeyPrefix)+8],
+		Hash:    hashAPIKey(plaintext),
+		Scopes:  scopes,
+		Created: time.Now(),
+	})
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	return plaintext, key, nil
+}
+
+func validScope(scope string) bool {
+	for _, known := range apiKeyScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, and whether one was sent.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", true
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticateAPIKey returns a live key and the user it belongs to, and records that it was used.
+func authenticateAPIKey(token string) (APIKey, User, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return APIKey{}, User{}, errInvalidAPIKey
+	}
+	key, err := store.GetAPIKeyByHash(hashAPIKey(token))
+	if err != nil || key.Revoked != nil {
+		return APIKey{}, User{}, errInvalidAPIKey
+	}
+	user, err := store.GetUser(key.UserID)
+	if err != nil {
+		return APIKey{}, User{}, errInvalidAPIKey
+	}
+	if err := store.TouchAPIKey(key.ID, time.Now()); err != nil {
+		fmt.Printf("Error recording API key use: %v\n", err)
+	}
+	return key, user, nil
+}
+
+// userContextKey carries a user authenticated without a session cookie through the request context.
+type userContextKey struct{}
+
+// withUser attaches user to the request, for currentUser.
+func withUser(r *http.Request, user User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+}
+
+// apiKeysHandler lists the signed-in user's API keys and creates new ones.
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	var newKey, errMessage string
+	if r.Method == "POST" {
+		r.ParseForm()
+		plaintext, _, err := createAPIKey(user.ID, r.FormValue("name"), r.Form["scopes"])
+		if err != nil {
+			errMessage = err.Error()
+		}
+		newKey = plaintext
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>API Keys</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 900px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        code { background-color: #f2f2f2; padding: 4px; }
+        .error { color: red; }
+        .success { color: green; }
+        .revoked { color: #999; }
+    </style>
+</head>
+<body>
+    <h1>API Keys</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+    {{if .NewKey}}
+    <p class="success">Your new key is shown below. Copy it now; it will not be shown again.</p>
+    <p><code>{{.NewKey}}</code></p>
+    <p>Send it as <code>Authorization: Bearer {{.NewKey}}</code> to the JSON API under /api/v1.</p>
+    {{end}}
+
+    <table>
+        <tr>
+            <th>Name</th>
+            <th>Key</th>
+            <th>Scopes</th>
+            <th>Created</th>
+            <th>Last used</th>
+            <th></th>
+        </tr>
+        {{range .Keys}}
+        <tr {{if .Revoked}}class="revoked"{{end}}>
+            <td>{{.Name}}</td>
+            <td>{{.Prefix}}...</td>
+            <td>{{join .Scopes ", "}}</td>
+            <td>{{.Created.Format "Jan 2, 2006"}}</td>
+            <td>{{if .LastUsed}}{{.LastUsed.Format "Jan 2, 2006 15:04"}}{{else}}never{{end}}</td>
+            <td>
+                {{if .Revoked}}revoked {{.Revoked.Format "Jan 2, 2006"}}{{else}}
+                <form method="POST" action="/account/api-keys/{{.ID}}/revoke">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Revoke</button>
+                </form>
+                {{end}}
+            </td>
+        </tr>
+        {{end}}
+    </table>
+
+    <h2>New Key</h2>
+    <form method="POST" action="/account/api-keys">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p><label>Name: <input type="text" name="name" required></label></p>
+        <p>
+            {{range .Scopes}}<label><input type="checkbox" name="scopes" value="{{.}}"> {{.}}</label><br>{{end}}
+        </p>
+        <button type="submit">Create key</button>
+    </form>
+    <p><a href="/my-bookings">My Bookings</a></p>
+</body>
+</html>`
+
+	keys, err := store.ListAPIKeys(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load API keys", http.StatusInternalServerError)
+		return
+	}
+	if errMessage == "" {
+		errMessage = r.URL.Query().Get("error")
+	}
+
+	t, _ := template.New("api-keys").Funcs(template.FuncMap{"join": strings.Join}).Parse(tmpl)
+	data := struct {
+		Keys      []APIKey
+		Scopes    []string
+		NewKey    string
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Keys:      keys,
+		Scopes:    apiKeyScopes,
+		NewKey:    newKey,
+		Message:   r.URL.Query().Get("message"),
+		Error:     errMessage,
+		CSRFToken: csrfToken(w, r),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if newKey != "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	t.Execute(w, data)
+}
+
+// revokeAPIKeyHandler handles POST /account/api-keys/{id}/revoke.
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		err = store.RevokeAPIKey(user.ID, id, time.Now())
+	}
+	if err != nil {
+		http.Redirect(w, r, "/account/api-keys?error="+url.QueryEscape("Could not revoke that key"), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/account/api-keys?message="+url.QueryEscape("Key revoked"), http.StatusSeeOther)
+}
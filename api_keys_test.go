@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// callWithAPIKey sends a GET for route through apiMiddleware with the given Authorization header, answering
+// with the name of the user the handler saw.
+func callWithAPIKey(route httpRoute, authorization string) *httptest.ResponseRecorder {
+	route.Method, route.Path = "GET", "/api/v1/test"
+	route.Handler = func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		writeJSON(w, http.StatusOK, user.Username)
+	}
+	req := httptest.NewRequest(route.Method, route.Path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	apiMiddleware(route)(rec, req)
+	return rec
+}
+
+// apiErrorCode reads the code of an APIError response.
+func apiErrorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body APIError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s is not an APIError: %v", rec.Body, err)
+	}
+	return body.Error.Code
+}
+
+// TestAPIKeyScopes lets a key into routes needing a scope it was granted, and turns it away with 403
+// from routes needing another scope, session-only routes and routes its user's role does not allow.
+func TestAPIKeyScopes(t *testing.T) {
+	useStore(t, newMemoryStore())
+	user, _ := createTestSession(t, "ada", roleCustomer)
+	key, _, err := createAPIKey(user.ID, "script", []string{scopeBookingsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		route    httpRoute
+		want     int
+		wantCode string
+	}{
+		{"granted scope", httpRoute{Auth: true, Scope: scopeBookingsRead}, http.StatusOK, ""},
+		{"missing scope", httpRoute{Auth: true, Scope: scopeBookingsWrite}, http.StatusForbidden, "insufficient_scope"},
+		{"session-only route", httpRoute{Auth: true}, http.StatusForbidden, "insufficient_scope"},
+		{"role lacks permission", httpRoute{Auth: true, Scope: scopeBookingsRead, Permission: permViewAllBookings}, http.StatusForbidden, "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := callWithAPIKey(tt.route, "Bearer "+key)
+			if rec.Code != tt.want {
+				t.Fatalf("%d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if tt.want == http.StatusOK {
+				if got := strings.TrimSpace(rec.Body.String()); got != `"ada"` {
+					t.Fatalf("handler saw user %s, want ada", got)
+				}
+				return
+			}
+			if code := apiErrorCode(t, rec); code != tt.wantCode {
+				t.Fatalf("error code %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestInvalidAPIKeys answers 401 with a WWW-Authenticate challenge for revoked, unknown and malformed keys.
+func TestInvalidAPIKeys(t *testing.T) {
+	useStore(t, newMemoryStore())
+	user, _ := createTestSession(t, "ada", roleCustomer)
+	revoked, revokedKey, err := createAPIKey(user.ID, "old script", []string{scopeBookingsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := callWithAPIKey(httpRoute{Auth: true, Scope: scopeBookingsRead}, "Bearer "+revoked); rec.Code != http.StatusOK {
+		t.Fatalf("before revoking: %d %s", rec.Code, rec.Body)
+	}
+	if err := store.RevokeAPIKey(user.ID, revokedKey.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"revoked key", "Bearer " + revoked},
+		{"unknown key", "Bearer " + apiKeyPrefix + "unknown"},
+		{"missing prefix", "Bearer " + strings.TrimPrefix(revoked, apiKeyPrefix)},
+		{"empty token", "Bearer "},
+		{"other scheme", "Basic " + revoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := callWithAPIKey(httpRoute{Auth: true, Scope: scopeBookingsRead}, tt.authorization)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("%d %s, want 401", rec.Code, rec.Body)
+			}
+			if code := apiErrorCode(t, rec); code != "invalid_api_key" {
+				t.Fatalf("error code %q, want invalid_api_key", code)
+			}
+			if challenge := rec.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Bearer") {
+				t.Fatalf("WWW-Authenticate %q, want a Bearer challenge", challenge)
+			}
+		})
+	}
+}
+
+// TestAPIKeyStoredAsHash keeps only the SHA-256 hash of a new key and a short prefix; the key itself is
+// returned once and never stored.
+func TestAPIKeyStoredAsHash(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		user, _ := createTestSession(t, "ada", roleCustomer)
+		plaintext, key, err := createAPIKey(user.ID, "script", []string{scopeBookingsRead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.Hash != hashAPIKey(plaintext) {
+			t.Fatalf("stored hash %q, want the SHA-256 of the key", key.Hash)
+		}
+		if !strings.HasPrefix(plaintext, key.Prefix) || len(key.Prefix) >= len(plaintext)/2 {
+			t.Fatalf("prefix %q gives away too much of the key", key.Prefix)
+		}
+
+		keys, err := store.ListAPIKeys(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || keys[0].Hash != key.Hash || keys[0].Prefix != key.Prefix {
+			t.Fatalf("listed %+v, want the one key", keys)
+		}
+
+		if s, ok := store.(*sqliteStore); ok {
+			var name, prefix, hash, scopes string
+			if err := s.db.QueryRow(`SELECT name, prefix, key_hash, scopes FROM api_keys WHERE id = ?`, key.ID).Scan(&name, &prefix, &hash, &scopes); err != nil {
+				t.Fatal(err)
+			}
+			for _, column := range []string{name, prefix, hash, scopes} {
+				if strings.Contains(column, plaintext) {
+					t.Fatalf("the api_keys row stores the key itself: %q", column)
+				}
+			}
+		}
+	})
+}
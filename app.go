@@ -5,9 +5,9 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -18,7 +18,6 @@ var eventName = "Scrabble National Championship"
 var remainingTickets uint = 200
 var bookings = make([]UserData, 0)
 var wg = sync.WaitGroup{}
-var simpleMutex = sync.Mutex{}
 
 type UserData struct {
 	firstName       string
@@ -27,15 +26,29 @@ type UserData struct {
 	numberOfTickets uint
 }
 
-// Validation function
-func ValidateUserInput(firstName string, lastName string, email string, userTickets uint, remainingTickets uint) (bool, bool, bool) {
-	isValidName := len(firstName) >= 2 && len(lastName) >= 2
-	isValidEmail := strings.Contains(email, "@")
-	isValidTicketNumber := userTickets > 0 && userTickets <= remainingTickets
-	return isValidName, isValidEmail, isValidTicketNumber
-}
-
 func main() {
+	// Schema migrations run against the raw database
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db := openDB()
+		defer db.Close()
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Open the booking store and restore previous bookings
+	db := initializeDB()
+	defer db.Close()
+	store = newSQLiteStore(db)
+
+	if err := initializeEvents(); err != nil {
+		log.Fatalf("Error initializing events: %v", err)
+	}
+	if err := loadBookings(); err != nil {
+		log.Fatalf("Error loading bookings: %v", err)
+	}
+
 	// Check for simple-web argument
 	if len(os.Args) > 1 && os.Args[1] == "simple-web" {
 		startSimpleWeb()
@@ -48,7 +61,7 @@ func main() {
 
 func startSimpleWeb() {
 	http.HandleFunc("/", simpleHomeHandler)
-	http.HandleFunc("/simple-book", simpleBookHandler)
+	http.HandleFunc("/simple-book", csrfMiddleware(simpleBookHandler))
 	http.HandleFunc("/simple-bookings", simpleBookingsHandler)
 	
 	fmt.Println("🚀 Simple Booking App starting on http://localhost:8080")
@@ -72,6 +85,11 @@ func startCLI() {
 	isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(firstName, lastName, email, userTickets, remainingTickets)
 
 	if isValidName && isValidEmail && isValidTicketNumber {
+		err := saveBooking(UserData{firstName: firstName, lastName: lastName, email: email, numberOfTickets: userTickets})
+		if err != nil {
+			fmt.Printf("Error saving booking: %v\n", err)
+			return
+		}
 		fmt.Println("Thank you for your booking!")
 		bookTicket(userTickets, firstName, lastName, email)
 		wg.Add(1)
@@ -168,6 +186,7 @@ func simpleHomeHandler(w http.ResponseWriter, r *http.Request) {
         {{if .Message}}<div class="success">✅ {{.Message}}</div>{{end}}
         
         <form method="POST" action="/simple-book">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
             <div class="form-group">
                 <label>👤 First Name:</label>
                 <input type="text" name="firstName" required minlength="2" placeholder="Enter your first name">
@@ -212,6 +231,7 @@ func simpleHomeHandler(w http.ResponseWriter, r *http.Request) {
 		Bookings         []UserData
 		Message          string
 		Error            string
+		CSRFToken        string
 	}{
 		EventName:        eventName,
 		TotalTickets:     eventTickets,
@@ -220,6 +240,7 @@ func simpleHomeHandler(w http.ResponseWriter, r *http.Request) {
 		Bookings:         bookings,
 		Message:          message,
 		Error:            errorMsg,
+		CSRFToken:        csrfToken(w, r),
 	}
 	
 	t.Execute(w, data)
@@ -244,9 +265,9 @@ func simpleBookHandler(w http.ResponseWriter, r *http.Request) {
 	
 	userTickets := uint(tickets)
 	
-	// Thread-safe booking
-	simpleMutex.Lock()
-	defer simpleMutex.Unlock()
+	// Thread-safe booking; the store itself guards against overselling across processes
+	ticketsMutex.Lock()
+	defer ticketsMutex.Unlock()
 	
 	// Validate input
 	isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(firstName, lastName, email, userTickets, remainingTickets)
@@ -266,16 +287,21 @@ func simpleBookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Process booking
-	remainingTickets -= userTickets
-	
 	userData := UserData{
 		firstName:       firstName,
 		lastName:        lastName,
 		email:           email,
 		numberOfTickets: userTickets,
 	}
-	
+
+	// Persist before updating the in-memory view
+	if err := saveBooking(userData); err != nil {
+		http.Redirect(w, r, "/?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	// Process booking
+	remainingTickets -= userTickets
 	bookings = append(bookings, userData)
 	
 	// Simulate async ticket sending
@@ -464,6 +490,9 @@ func getUserInput() (string, string, string, uint) {
 }
 
 func bookTicket(userTickets uint, firstName string, lastName string, email string) {
+	ticketsMutex.Lock()
+	defer ticketsMutex.Unlock()
+
 	remainingTickets = remainingTickets - userTickets
 
 	var userData = UserData{
@@ -3,18 +3,27 @@ package main
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID       int
-	Username string
-	Email    string
-	Password string // This should be hashed in production
-	Created  time.Time
+	ID            int
+	Username      string
+	Email         string
+	EmailVerified bool   // set once the user follows the link in the verification email
+	Password      string // bcrypt hash; accounts created before bcrypt hold a legacy SHA-256 hex digest
+	Role          string // customer, organizer or admin
+	Created       time.Time
 }
 
 type Session struct {
@@ -23,15 +32,46 @@ type Session struct {
 	Expires time.Time
 }
 
-var users = make(map[string]User)       // username -> User
-var usersByID = make(map[int]User)      // userID -> User
-var sessions = make(map[string]Session) // token -> Session
+// sessionTTL is how long a session lasts without activity; each request past the halfway point renews it.
+const sessionTTL = 24 * time.Hour
+
+// passwordCost is the bcrypt work factor for new password hashes. Stored hashes with a
+// lower cost are upgraded on the next successful login.
+const passwordCost = 12
+
+// dummyPasswordHash is compared against when the username is unknown, so a miss costs as much as a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), passwordCost)
 
-func hashPassword(password string) string {
+// hashPassword returns a salted bcrypt hash of password; the cost and salt are encoded in the result.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// legacyHashPassword is the unsalted SHA-256 digest stored by earlier versions.
+func legacyHashPassword(password string) string {
 	hash := sha256.Sum256([]byte(password))
 	return hex.EncodeToString(hash[:])
 }
 
+// checkPassword reports whether password matches the stored hash, and whether the hash
+// should be replaced because it is a legacy digest or uses an outdated cost.
+func checkPassword(storedHash, password string) (ok, needsRehash bool) {
+	if !strings.HasPrefix(storedHash, "$2") {
+		legacy := legacyHashPassword(password)
+		return subtle.ConstantTimeCompare([]byte(storedHash), []byte(legacy)) == 1, true
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(storedHash))
+	return true, err != nil || cost < passwordCost
+}
+
 func generateSessionToken() string {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -42,30 +82,63 @@ func generateSessionToken() string {
 }
 
 func registerUser(username, email, password string) error {
-	if _, exists := users[username]; exists {
+	if _, err := store.GetUserByUsername(username); err == nil {
 		return fmt.Errorf("user already exists")
+	} else if !errors.Is(err, errNotFound) {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
 	}
 	user := User{
-		ID:       len(users) + 1,
 		Username: username,
 		Email:    email,
-		Password: hashPassword(password),
+		Password: hash,
+		Role:     roleCustomer,
 		Created:  time.Now(),
 	}
 
-	users[username] = user
-	usersByID[user.ID] = user
+	created, err := store.CreateUser(user)
+	if err != nil {
+		return err
+	}
+	sendInBackground(func() { sendVerificationEmail(created) })
 	return nil
 }
 
-func loginUser(username, password string) (string, error) {
-	user, exists := users[username]
-	if !exists {
-		return "", fmt.Errorf("user not found")
+// loginUser checks a username and password from ip and opens a session. Unknown users and wrong
+// passwords both fail with errInvalidCredentials; repeated failures are throttled with errLoginThrottled.
+func loginUser(username, password, ip string) (string, error) {
+	wait, err := loginRetryAfter(username, ip, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		recordLoginAttempt(username, ip, false, loginReasonThrottled)
+		return "", errLoginThrottled
 	}
 
-	if user.Password != hashPassword(password) {
-		return "", fmt.Errorf("invalid password")
+	user, err := store.GetUserByUsername(username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		recordLoginAttempt(username, ip, false, loginReasonUnknownUser)
+		return "", errInvalidCredentials
+	}
+
+	ok, needsRehash := checkPassword(user.Password, password)
+	if !ok {
+		recordLoginAttempt(username, ip, false, loginReasonBadPassword)
+		return "", errInvalidCredentials
+	}
+	recordLoginAttempt(username, ip, true, loginReasonOK)
+	if needsRehash {
+		// Upgrade legacy and low-cost hashes while the plaintext is at hand.
+		if hash, err := hashPassword(password); err == nil {
+			if err := store.UpdateUserPassword(user.ID, hash); err != nil {
+				fmt.Printf("Error upgrading password hash for user %d: %v\n", user.ID, err)
+			}
+		}
 	}
 
 	// Create session
@@ -73,24 +146,100 @@ func loginUser(username, password string) (string, error) {
 	session := Session{
 		Token:   token,
 		UserID:  user.ID,
-		Expires: time.Now().Add(24 * time.Hour), // 24 hour session
+		Expires: time.Now().Add(sessionTTL),
 	}
 
-	sessions[token] = session
+	if err := store.CreateSession(session); err != nil {
+		return "", err
+	}
 	return token, nil
 }
 
 func validateSession(token string) (User, bool) {
-	session, exists := sessions[token]
-	if !exists || time.Now().After(session.Expires) {
-		return User{}, false
+	_, user, valid := lookupSession(token)
+	return user, valid
+}
+
+// lookupSession returns an unexpired session and its user.
+func lookupSession(token string) (Session, User, bool) {
+	session, err := store.GetSession(token)
+	if err != nil || time.Now().After(session.Expires) {
+		return Session{}, User{}, false
+	}
+	user, err := store.GetUser(session.UserID)
+	if err != nil {
+		return Session{}, User{}, false
+	}
+	return session, user, true
+}
+
+// slideSession renews a session to a full sessionTTL once less than half of it remains.
+func slideSession(session Session) (Session, bool) {
+	if time.Until(session.Expires) > sessionTTL/2 {
+		return session, false
+	}
+	session.Expires = time.Now().Add(sessionTTL)
+	if err := store.TouchSession(session.Token, session.Expires); err != nil {
+		return session, false
 	}
-	// Find user by ID efficiently
-	user, exists := usersByID[session.UserID]
-	if exists {
+	return session, true
+}
+
+// setSessionCookie hands the session token to the browser until the session expires.
+func setSessionCookie(w http.ResponseWriter, session Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   true,                    // Ensure cookie is sent only over HTTPS
+		SameSite: http.SameSiteStrictMode, // Optional: helps prevent CSRF
+	})
+}
+
+// clearSessionCookie tells the browser to drop its session token.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// startSessionReaper deletes expired sessions in the background every interval.
+func startSessionReaper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			deleted, err := store.DeleteExpiredSessions(time.Now())
+			if err != nil {
+				fmt.Printf("Error deleting expired sessions: %v\n", err)
+				continue
+			}
+			if deleted > 0 {
+				fmt.Printf("Deleted %d expired sessions\n", deleted)
+			}
+		}
+	}()
+}
+
+// currentUser returns the user authenticated by an API key or owning the request's session cookie, if any.
+func currentUser(r *http.Request) (User, bool) {
+	if user, ok := r.Context().Value(userContextKey{}).(User); ok {
 		return user, true
 	}
-	return User{}, false
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return User{}, false
+	}
+	return validateSession(cookie.Value)
 }
 
 func requireAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
@@ -101,11 +250,14 @@ func requireAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		_, valid := validateSession(cookie.Value)
+		session, _, valid := lookupSession(cookie.Value)
 		if !valid {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		if renewed, ok := slideSession(session); ok {
+			setSessionCookie(w, renewed)
+		}
 
 		next(w, r)
 	}
@@ -117,7 +269,10 @@ var loginTemplate = `
 <head><title>Login</title></head>
 <body>
 	<h2>Login</h2>
+	{{if .Error}}<p style="color: red;">{{.Error}}</p>{{end}}
+	{{if .Message}}<p style="color: green;">{{.Message}}</p>{{end}}
 	<form method="POST">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
 		<div>
 			<label>Username:</label>
 			<input type="text" name="username" required>
@@ -128,7 +283,7 @@ var loginTemplate = `
 		</div>
 		<button type="submit">Login</button>
 	</form>
-	<p><a href="/register">Register</a></p>
+	<p><a href="/register">Register</a> | <a href="/forgot-password">Forgot password?</a></p>
 </body>
 </html>`
 
@@ -137,23 +292,19 @@ func authLoginHandler(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
-		token, err := loginUser(username, password)
+		token, err := loginUser(username, password, clientIP(r))
+		if errors.Is(err, errLoginThrottled) {
+			http.Redirect(w, r, "/login?error="+url.QueryEscape("Too many failed attempts. Please try again later."), http.StatusSeeOther)
+			return
+		}
 		if err != nil {
 			// Redirect to a fixed, safe relative path to prevent SSRF
-			http.Redirect(w, r, "/login?error=1", http.StatusSeeOther)
+			http.Redirect(w, r, "/login?error="+url.QueryEscape("Invalid username or password"), http.StatusSeeOther)
 			return
 		}
 
 		// Set session cookie
-		cookie := &http.Cookie{
-			Name:     "session_token",
-			Value:    token,
-			Expires:  time.Now().Add(24 * time.Hour),
-			HttpOnly: true,
-			Secure:   true,                    // Ensure cookie is sent only over HTTPS
-			SameSite: http.SameSiteStrictMode, // Optional: helps prevent CSRF
-		}
-		http.SetCookie(w, cookie)
+		setSessionCookie(w, Session{Token: token, Expires: time.Now().Add(sessionTTL)})
 
 		// Redirect to a fixed, safe relative path to prevent SSRF
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -161,8 +312,7 @@ func authLoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Show login form
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(loginTemplate))
+	renderAuthForm(w, r, loginTemplate)
 }
 
 func authRegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -182,13 +332,34 @@ func authRegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Show registration form
-	tmpl := `
+	renderAuthForm(w, r, registerTemplate)
+}
+
+// renderAuthForm renders the login or register page with its CSRF token and any status message.
+func renderAuthForm(w http.ResponseWriter, r *http.Request, tmpl string) {
+	t, err := template.New("auth").Parse(tmpl)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	data := PageData{
+		Message:   r.URL.Query().Get("message"),
+		Error:     r.URL.Query().Get("error"),
+		CSRFToken: csrfToken(w, r),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	t.Execute(w, data)
+}
+
+var registerTemplate = `
 <!DOCTYPE html>
 <html>
 <head><title>Register</title></head>
 <body>
 	<h2>Register</h2>
+	{{if .Error}}<p style="color: red;">{{.Error}}</p>{{end}}
 	<form method="POST">
+		<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
 		<div>
 			<label>Username:</label>
 			<input type="text" name="username" required>
@@ -207,6 +378,35 @@ func authRegisterHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+// logoutHandler ends the current session and clears its cookie.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		if err := store.DeleteSession(cookie.Value); err != nil {
+			http.Error(w, "Could not log out", http.StatusInternalServerError)
+			return
+		}
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login?message=Logged out", http.StatusSeeOther)
+}
+
+// logoutAllHandler revokes every session of the current user, signing them out on all devices.
+func logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := currentUser(r)
+	if _, err := store.DeleteUserSessions(user.ID); err != nil {
+		http.Error(w, "Could not log out", http.StatusInternalServerError)
+		return
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login?message=Logged out on all devices", http.StatusSeeOther)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createPasswordUser adds a user whose password is stored as passwordHash.
+func createPasswordUser(t *testing.T, username, passwordHash string) User {
+	t.Helper()
+	user, err := store.CreateUser(User{Username: username, Email: username + "@example.com", Password: passwordHash, Role: roleCustomer, Created: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// storedPassword reads the password hash stored for a user.
+func storedPassword(t *testing.T, userID int) string {
+	t.Helper()
+	user, err := store.GetUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.Password
+}
+
+// TestLoginRehashesLegacyPassword signs in with a password stored as a legacy SHA-256 digest: the login
+// succeeds, the digest is replaced by a bcrypt hash at the current cost, and the password still works.
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		user := createPasswordUser(t, "ada", legacyHashPassword("correct horse"))
+
+		if _, err := loginUser("ada", "wrong horse", "192.0.2.1"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("wrong password: %v, want errInvalidCredentials", err)
+		}
+		if stored := storedPassword(t, user.ID); stored != legacyHashPassword("correct horse") {
+			t.Fatalf("a failed login changed the stored hash to %q", stored)
+		}
+
+		if _, err := loginUser("ada", "correct horse", "192.0.2.1"); err != nil {
+			t.Fatalf("legacy password: %v", err)
+		}
+		stored := storedPassword(t, user.ID)
+		if !strings.HasPrefix(stored, "$2") {
+			t.Fatalf("stored hash %q is still not bcrypt", stored)
+		}
+		if cost, err := bcrypt.Cost([]byte(stored)); err != nil || cost != passwordCost {
+			t.Fatalf("rehashed at cost %d (%v), want %d", cost, err, passwordCost)
+		}
+		if ok, needsRehash := checkPassword(stored, "correct horse"); !ok || needsRehash {
+			t.Fatalf("rehashed password: ok %v, needs rehash %v", ok, needsRehash)
+		}
+
+		if _, err := loginUser("ada", "correct horse", "192.0.2.1"); err != nil {
+			t.Fatalf("signing in again: %v", err)
+		}
+		if again := storedPassword(t, user.ID); again != stored {
+			t.Fatal("a current hash was rehashed again")
+		}
+	})
+}
+
+// TestLoginRehashesLowCostPassword upgrades a bcrypt hash made at a lower cost than passwordCost.
+func TestLoginRehashesLowCostPassword(t *testing.T) {
+	useStore(t, newMemoryStore())
+	lowCost, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createPasswordUser(t, "ada", string(lowCost))
+
+	if _, err := loginUser("ada", "correct horse", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if cost, err := bcrypt.Cost([]byte(storedPassword(t, user.ID))); err != nil || cost != passwordCost {
+		t.Fatalf("rehashed at cost %d (%v), want %d", cost, err, passwordCost)
+	}
+}
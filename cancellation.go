@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RefundTier refunds Percent of the amount paid when cancelling at least MinDaysBefore days before the event.
+type RefundTier struct {
+	MinDaysBefore int
+	Percent       int
+}
+
+// defaultRefundPolicy gives a full refund up to 7 days out and half up to 1 day out.
+const defaultRefundPolicy = "7:100,1:50"
+
+// getRefundPolicy reads REFUND_POLICY ("days:percent,..."), falling back to defaultRefundPolicy.
+func getRefundPolicy() []RefundTier {
+	policy, err := parseRefundPolicy(os.Getenv("REFUND_POLICY"))
+	if err != nil || len(policy) == 0 {
+		policy, _ = parseRefundPolicy(defaultRefundPolicy)
+	}
+	return policy
+}
+
+// parseRefundPolicy parses "7:100,1:50" into tiers ordered from the earliest cancellation.
+func parseRefundPolicy(spec string) ([]RefundTier, error) {
+	var tiers []RefundTier
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		days, percent, found := strings.Cut(part, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid refund tier %q", part)
+		}
+		d, err := strconv.Atoi(strings.TrimSpace(days))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid refund tier %q", part)
+		}
+		p, err := strconv.Atoi(strings.TrimSpace(percent))
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid refund tier %q", part)
+		}
+		tiers = append(tiers, RefundTier{MinDaysBefore: d, Percent: p})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinDaysBefore > tiers[j].MinDaysBefore })
+	return tiers, nil
+}
+
+// refundPercent picks the tier that applies when cancelling at now for an event starting at eventDate.
+func refundPercent(policy []RefundTier, eventDate, now time.Time) int {
+	until := eventDate.Sub(now)
+	for _, tier := range policy {
+		if until >= time.Duration(tier.MinDaysBefore)*24*time.Hour {
+			return tier.Percent
+		}
+	}
+	return 0
+}
+
+// PendingRefund is a cancellation refund owed to a customer, queued until the payment provider accepts it.
+type PendingRefund struct {
+	BookingID       int
+	PaymentIntentID string
+	Amount          int64 // in the smallest currency unit
+	Attempts        int   // failed attempts so far
+	LastError       string
+	Created         time.Time
+}
+
+// refundRetryDelay leaves a queued refund to the cancellation that queued it before the sweeper retries it.
+var refundRetryDelay = time.Minute
+
+// cancelBookingForUser cancels one of the user's bookings, refunds it per the refund policy and
+// returns its tickets to the event. It returns the amount refunded.
+func cancelBookingForUser(bookingID, userID int) (float64, error) {
+	booking, err := store.GetBooking(bookingID)
+	if err != nil || booking.UserID != userID {
+		return 0, errNotFound
+	}
+	if !booking.HoldsTickets() {
+		return 0, errAlreadyCancelled
+	}
+
+	event, err := store.GetEvent(booking.EventID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Claim the booking before any refund, so a second cancellation finds it cancelled and refunds nothing.
+	claimed, err := store.ReleaseBooking(booking.ID, "cancelled")
+	if err != nil {
+		return 0, err
+	}
+	reloadDefaultEvent(booking.EventID)
+	offerFreedTickets(booking.EventID)
+
+	refundAmount := 0.0
+	switch {
+	case claimed.Status == "confirmed" && claimed.PaymentIntentID != "":
+		percent := refundPercent(getRefundPolicy(), event.Date, time.Now())
+		cents := int64(math.Round(claimed.TotalAmount * 100 * float64(percent) / 100))
+		if cents > 0 {
+			// Queued before the provider is asked, so a refund that fails is retried by the hold sweeper.
+			refund := PendingRefund{BookingID: claimed.ID, PaymentIntentID: claimed.PaymentIntentID, Amount: cents,
+				Created: time.Now().UTC()}
+			if err := store.QueueRefund(refund); err != nil {
+				// Nothing would retry it, so the refund has to go through now.
+				fmt.Printf("Could not queue the refund of booking %d: %v\n", claimed.ID, err)
+				if err := paymentProvider.Refund(claimed.PaymentIntentID, cents); err != nil {
+					fmt.Printf("Booking %d was cancelled but its refund of %d failed: %v\n", claimed.ID, cents, err)
+					return 0, fmt.Errorf("booking cancelled, but the refund failed: %w", err)
+				}
+			} else if err := issueRefund(refund); err != nil {
+				fmt.Printf("Refund of %d for booking %d failed and will be retried: %v\n", cents, claimed.ID, err)
+			}
+			refundAmount = float64(cents) / 100
+		}
+	case claimed.Status == "pending" && claimed.PaymentIntentID != "":
+		// The checkout must not be charged now its tickets are gone. A payment that got in first is
+		// refunded in full when it is reported.
+		if err := paymentProvider.CancelIntent(claimed.PaymentIntentID); err != nil {
+			fmt.Printf("Could not cancel payment intent %s of booking %d: %v\n", claimed.PaymentIntentID, claimed.ID, err)
+		}
+	}
+
+	sendInBackground(func() { sendCancellationEmail(booking, event, refundAmount) })
+	return refundAmount, nil
+}
+
+// issueRefund asks the payment provider for a queued refund and drops it from the queue once accepted,
+// or counts the failed attempt.
+func issueRefund(refund PendingRefund) error {
+	if err := paymentProvider.Refund(refund.PaymentIntentID, refund.Amount); err != nil {
+		if recordErr := store.RecordRefundAttempt(refund.BookingID, err.Error()); recordErr != nil {
+			fmt.Printf("Could not record the failed refund of booking %d: %v\n", refund.BookingID, recordErr)
+		}
+		return err
+	}
+	if err := store.DeletePendingRefund(refund.BookingID); err != nil {
+		// The money is already back with the customer; a retry would refund it twice.
+		fmt.Printf("Booking %d was refunded but its refund is still queued: %v\n", refund.BookingID, err)
+	}
+	return nil
+}
+
+// retryPendingRefunds asks again for the queued refunds that failed, or were never asked for because
+// the process stopped, and returns how many went through.
+func retryPendingRefunds() (int, error) {
+	refunds, err := store.PendingRefunds(time.Now().UTC().Add(-refundRetryDelay))
+	if err != nil {
+		return 0, err
+	}
+
+	issued := 0
+	for _, refund := range refunds {
+		if err := issueRefund(refund); err != nil {
+			fmt.Printf("Refund of %d for booking %d failed again after %d attempts: %v\n",
+				refund.Amount, refund.BookingID, refund.Attempts+1, err)
+			continue
+		}
+		issued++
+	}
+	return issued, nil
+}
+
+// cancellationEmailTemplate is the email template for booking cancellations.
+const cancellationEmailTemplate = `Dear %s %s,
+
+Your booking has been cancelled.
+
+Booking Details:
+- Event: %s
+- Number of Tickets: %d
+- Refund: %.2f %s
+
+Best regards,
+Booking Team
+`
+
+// sendCancellationEmail tells the customer their booking was cancelled, or simulates sending if it fails.
+func sendCancellationEmail(booking EventBooking, event Event, refundAmount float64) {
+	subject := "Booking Cancelled - " + event.Name
+	body := fmt.Sprintf(cancellationEmailTemplate, booking.FirstName, booking.LastName, event.Name,
+		booking.NumberOfTickets, refundAmount, strings.ToUpper(event.Currency))
+
+	sendOrSimulate(booking.Email, subject, body,
+		fmt.Sprintf("Sending cancellation of booking %d to %s (refund %.2f)", booking.ID, booking.Email, refundAmount))
+}
+
+// cancelBookingHandler handles POST /cancel-booking/{id}, answering in JSON when the client asks for it.
+func cancelBookingHandler(w http.ResponseWriter, r *http.Request) {
+	wantsJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := currentUser(r)
+	bookingID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/cancel-booking/"))
+	if err != nil {
+		http.Error(w, "Invalid booking", http.StatusBadRequest)
+		return
+	}
+
+	refundAmount, err := cancelBookingForUser(bookingID, user.ID)
+
+	if wantsJSON {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case errors.Is(err, errNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, errAlreadyCancelled):
+			w.WriteHeader(http.StatusConflict)
+		case err != nil:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		response := struct {
+			BookingID    int     `json:"booking_id"`
+			RefundAmount float64 `json:"refund_amount"`
+			Error        string  `json:"error,omitempty"`
+		}{BookingID: bookingID, RefundAmount: refundAmount}
+		if err != nil {
+			response.Error = err.Error()
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if err != nil {
+		http.Redirect(w, r, "/my-bookings?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	message := fmt.Sprintf("Booking %d cancelled. Refund: %.2f", bookingID, refundAmount)
+	http.Redirect(w, r, "/my-bookings?message="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// myBookingsHandler lists the current user's bookings with a cancel button for each active one.
+func myBookingsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>My Bookings</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 900px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        button { background-color: #f44336; color: white; padding: 6px 12px; border: none; cursor: pointer; }
+        .error { color: red; }
+        .success { color: green; }
+    </style>
+</head>
+<body>
+    <h1>My Bookings</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    {{if .Unverified}}
+    <form method="POST" action="/resend-verification">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p class="error">Your email address is not verified yet, so new bookings stay pending.
+        <button type="submit">Resend verification email</button></p>
+    </form>
+    {{end}}
+
+    <table>
+        <tr>
+            <th>#</th>
+            <th>Event</th>
+            <th>Date</th>
+            <th>Tickets</th>
+            <th>Amount</th>
+            <th>Status</th>
+            <th></th>
+        </tr>
+        {{range .Bookings}}
+        <tr>
+            <td>{{.Booking.ID}}</td>
+            <td>{{.Event.Name}}</td>
+            <td>{{.Event.Date.Format "Jan 2, 2006"}}</td>
+            <td>{{.Booking.NumberOfTickets}}{{range .Booking.Seats}}<br><small>{{.Label}}</small>{{end}}
+                {{if eq .Booking.Status "confirmed"}}{{range .Booking.Tickets}}<br><a href="/tickets/{{.ID}}">Ticket {{.Number}}</a>{{end}}
+                <br><a href="/bookings/{{.Booking.ID}}/pdf">PDF tickets and receipt</a>{{end}}</td>
+            <td>{{printf "%.2f" .Booking.TotalAmount}}{{if .Booking.DiscountCode}} ({{.Booking.DiscountCode}} saved {{printf "%.2f" .Booking.DiscountAmount}}){{end}}</td>
+            <td>{{.Booking.Status}}{{if .Booking.AwaitsPayment}}<br><a href="/payment?booking_id={{.Booking.ID}}">Pay now</a>{{end}}</td>
+            <td>
+                {{if .Booking.HoldsTickets}}
+                <form method="POST" action="/cancel-booking/{{.Booking.ID}}" onsubmit="return confirm('Cancel this booking? Refund: {{.RefundPercent}}%')">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Cancel ({{.RefundPercent}}% refund)</button>
+                </form>
+                {{end}}
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    <p><a href="/events">Back to Events</a> | <a href="/waitlist">Waitlists</a> | <a href="/account/api-keys">API keys</a>{{if .CanManageEvents}} | <a href="/organizer/events">Manage events</a>{{end}}{{if .CanCheckIn}} | <a href="/checkin">Door check-in</a>{{end}}</p>
+
+    <form method="POST" action="/logout" style="display:inline">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit">Log out</button>
+    </form>
+    <form method="POST" action="/logout-all" style="display:inline">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit">Log out all devices</button>
+    </form>
+</body>
+</html>`
+
+	type bookingRow struct {
+		Booking       EventBooking
+		Event         Event
+		RefundPercent int
+	}
+
+	user, _ := currentUser(r)
+	all, err := store.ListBookings()
+	if err != nil {
+		http.Error(w, "Failed to load bookings", http.StatusInternalServerError)
+		return
+	}
+
+	policy := getRefundPolicy()
+	rows := make([]bookingRow, 0)
+	for _, booking := range all {
+		if booking.UserID != user.ID {
+			continue
+		}
+		event, _ := store.GetEvent(booking.EventID)
+		rows = append(rows, bookingRow{
+			Booking:       booking,
+			Event:         event,
+			RefundPercent: refundPercent(policy, event.Date, time.Now()),
+		})
+	}
+
+	t, _ := template.New("my-bookings").Parse(tmpl)
+	data := struct {
+		Bookings        []bookingRow
+		Message         string
+		Error           string
+		CSRFToken       string
+		Unverified      bool
+		CanManageEvents bool
+		CanCheckIn      bool
+	}{
+		Unverified:      !user.EmailVerified,
+		CanManageEvents: user.Can(permManageEvents),
+		CanCheckIn:      user.Can(permCheckIn),
+		Bookings:        rows,
+		Message:         r.URL.Query().Get("message"),
+		Error:           r.URL.Query().Get("error"),
+		CSRFToken:       csrfToken(w, r),
+	}
+	t.Execute(w, data)
+}
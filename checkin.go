@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons a scanned ticket is turned away at the door.
+var (
+	errInvalidTicketCode = errors.New("not a valid ticket code")
+	errWrongEvent        = errors.New("ticket is for another event")
+	errTicketNotValid    = errors.New("ticket is not valid")
+	errAlreadyCheckedIn  = errors.New("already checked in")
+)
+
+// CheckIn is a scan that admitted someone.
+type CheckIn struct {
+	Ticket      Ticket `json:"ticket"`
+	Attendee    string `json:"attendee"`
+	Description string `json:"description"` // tier and seat
+	Admitted    int    `json:"admitted"`    // people admitted to the event so far, this one included
+}
+
+// CheckInStats counts the people admitted to an event, in total and per gate.
+type CheckInStats struct {
+	EventID  int         `json:"event_id"`
+	Tickets  int         `json:"tickets"` // tickets of confirmed bookings
+	Admitted int         `json:"admitted"`
+	Gates    []GateCount `json:"gates"`
+}
+
+// GateCount is how many people were admitted at one gate.
+type GateCount struct {
+	Gate     string `json:"gate"`
+	Admitted int    `json:"admitted"`
+}
+
+func newCheckInStats(eventID int) CheckInStats {
+	return CheckInStats{EventID: eventID, Gates: []GateCount{}}
+}
+
+// count adds a ticket of a confirmed booking to the stats.
+func (s *CheckInStats) count(ticket Ticket) {
+	s.Tickets++
+	if ticket.CheckedInAt == nil {
+		return
+	}
+	s.Admitted++
+	for i := range s.Gates {
+		if s.Gates[i].Gate == ticket.Gate {
+			s.Gates[i].Admitted++
+			return
+		}
+	}
+	s.Gates = append(s.Gates, GateCount{Gate: ticket.Gate, Admitted: 1})
+}
+
+func (s *CheckInStats) sortGates() {
+	sort.Slice(s.Gates, func(i, j int) bool { return s.Gates[i].Gate < s.Gates[j].Gate })
+}
+
+// parseTicketPayload checks the signature of a scanned QR code and returns the event and ticket it is for.
+func parseTicketPayload(code string) (int, string, error) {
+	parts := strings.Split(strings.TrimSpace(code), ".")
+	if len(parts) != 4 || parts[0] != "TKT1" {
+		return 0, "", errInvalidTicketCode
+	}
+	eventID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, "", errInvalidTicketCode
+	}
+	expected := ticketMAC(strings.Join(parts[:3], "."))
+	if !hmac.Equal([]byte(parts[3]), []byte(expected)) {
+		return 0, "", errInvalidTicketCode
+	}
+	return eventID, parts[2], nil
+}
+
+// checkInTicket admits the holder of a scanned ticket to eventID at gate, if the ticket is genuine,
+// for this event, of a confirmed booking and not used yet.
+func checkInTicket(code string, eventID int, gate string, staff User) (CheckIn, error) {
+	gate = strings.TrimSpace(gate)
+	if len(gate) > 20 {
+		return CheckIn{}, validationError("gate must be at most 20 characters")
+	}
+	event, err := store.GetEvent(eventID)
+	if err != nil {
+		return CheckIn{}, err
+	}
+
+	ticketEventID, ticketID, err := parseTicketPayload(code)
+	if err != nil {
+		return CheckIn{}, err
+	}
+	if ticketEventID != event.ID {
+		return CheckIn{}, wrongEventError(ticketEventID)
+	}
+	ticket, err := store.GetTicket(ticketID)
+	if errors.Is(err, errNotFound) {
+		return CheckIn{}, errInvalidTicketCode
+	} else if err != nil {
+		return CheckIn{}, err
+	}
+	booking, err := store.GetBooking(ticket.BookingID)
+	if err != nil {
+		return CheckIn{}, err
+	}
+	if booking.EventID != event.ID {
+		return CheckIn{}, wrongEventError(booking.EventID)
+	}
+	if booking.Status != "confirmed" {
+		return CheckIn{}, fmt.Errorf("%w: the booking is %s", errTicketNotValid, booking.Status)
+	}
+
+	ticket, err = store.CheckInTicket(ticket.ID, time.Now(), gate, staff.ID)
+	if errors.Is(err, errAlreadyCheckedIn) {
+		at := ticket.CheckedInAt.Local().Format("15:04")
+		if ticket.Gate == "" {
+			return CheckIn{}, fmt.Errorf("%w at %s", errAlreadyCheckedIn, at)
+		}
+		return CheckIn{}, fmt.Errorf("%w at %s, gate %s", errAlreadyCheckedIn, at, ticket.Gate)
+	}
+	if err != nil {
+		return CheckIn{}, err
+	}
+	stats, err := store.CheckInStats(event.ID)
+	if err != nil {
+		return CheckIn{}, err
+	}
+	return CheckIn{
+		Ticket:      ticket,
+		Attendee:    booking.FirstName + " " + booking.LastName,
+		Description: ticketDescription(booking, ticket),
+		Admitted:    stats.Admitted,
+	}, nil
+}
+
+// wrongEventError names the event a ticket is really for.
+func wrongEventError(eventID int) error {
+	if event, err := store.GetEvent(eventID); err == nil {
+		return fmt.Errorf("%w: %s", errWrongEvent, event.Name)
+	}
+	return errWrongEvent
+}
+
+// checkInEventsHandler lists the published events for door staff to pick the one they are admitting to.
+func checkInEventsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Door Check-in</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+    </style>
+</head>
+<body>
+    <h1>Door Check-in</h1>
+    <table>
+        <tr><th>Event</th><th>When</th><th>Admitted</th><th></th></tr>
+        {{range .Events}}
+        <tr>
+            <td>{{.Event.Name}}</td>
+            <td>{{.Event.Date.Format "Jan 2, 2006 15:04"}}</td>
+            <td>{{.Stats.Admitted}} of {{.Stats.Tickets}}</td>
+            <td><a href="/checkin/{{.Event.ID}}">Scan tickets</a></td>
+        </tr>
+        {{else}}
+        <tr><td colspan="4">No published events.</td></tr>
+        {{end}}
+    </table>
+    <p><a href="/my-bookings">My Bookings</a></p>
+</body>
+</html>`
+
+	list, err := store.ListEvents()
+	if err != nil {
+		http.Error(w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	type eventStats struct {
+		Event Event
+		Stats CheckInStats
+	}
+	var events []eventStats
+	for _, event := range list {
+		if !event.Active {
+			continue
+		}
+		stats, err := store.CheckInStats(event.ID)
+		if err != nil {
+			http.Error(w, "Failed to load check-ins", http.StatusInternalServerError)
+			return
+		}
+		events = append(events, eventStats{Event: event, Stats: stats})
+	}
+
+	t, _ := template.New("checkin-events").Parse(tmpl)
+	t.Execute(w, struct{ Events []eventStats }{events})
+}
+
+// checkInHandler is the scanning page for one event: a barcode scanner types the QR code into the
+// focused field and presses Enter. It shows the outcome of the last scan and a live count of the
+// people admitted.
+func checkInHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, _ := strconv.Atoi(r.PathValue("id"))
+	event, err := store.GetEvent(eventID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == "POST" {
+		gate := r.FormValue("gate")
+		user, _ := currentUser(r)
+		next := "/checkin/" + strconv.Itoa(event.ID) + "?gate=" + url.QueryEscape(gate)
+		checkIn, err := checkInTicket(r.FormValue("code"), event.ID, gate, user)
+		if err != nil {
+			http.Redirect(w, r, next+"&error="+url.QueryEscape("Rejected: "+err.Error()), http.StatusSeeOther)
+			return
+		}
+		message := fmt.Sprintf("Admitted %s - %s", checkIn.Attendee, checkIn.Description)
+		http.Redirect(w, r, next+"&message="+url.QueryEscape(message), http.StatusSeeOther)
+		return
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Check-in - {{.Event.Name}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+        input { padding: 8px; margin-bottom: 10px; }
+        input[name=code] { width: 100%; font-size: 18px; }
+        button { background-color: #4CAF50; color: white; padding: 10px 20px; border: none; cursor: pointer; }
+        .result { font-size: 22px; padding: 15px; border-radius: 5px; }
+        .error { background: #fdd; color: #900; }
+        .success { background: #dfd; color: #060; }
+        .counter { font-size: 28px; }
+    </style>
+</head>
+<body>
+    <h1>Check-in: {{.Event.Name}}</h1>
+    <p>{{.Event.Date.Format "Jan 2, 2006 15:04"}} | {{.Event.Location}}</p>
+
+    {{if .Error}}<p class="result error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="result success">{{.Message}}</p>{{end}}
+
+    <form method="POST" action="/checkin/{{.Event.ID}}">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <label>Gate: <input type="text" name="gate" value="{{.Gate}}" maxlength="20" size="6"></label>
+        <input type="text" name="code" placeholder="Scan a ticket" autocomplete="off" autofocus required>
+        <button type="submit">Check in</button>
+    </form>
+
+    <p class="counter">Admitted: <strong id="admitted">{{.Stats.Admitted}}</strong> of <span id="tickets">{{.Stats.Tickets}}</span></p>
+    <ul id="gates">{{range .Stats.Gates}}<li>Gate {{if .Gate}}{{.Gate}}{{else}}(none){{end}}: {{.Admitted}}</li>{{end}}</ul>
+    <p><a href="/checkin">Other events</a></p>
+
+    <script>
+    // Keep the counter live while other gates admit people.
+    setInterval(function() {
+        fetch('/api/v1/events/{{.Event.ID}}/checkins').then(function(response) {
+            return response.ok ? response.json() : null;
+        }).then(function(stats) {
+            if (!stats) return;
+            document.getElementById('admitted').textContent = stats.admitted;
+            document.getElementById('tickets').textContent = stats.tickets;
+            var gates = document.getElementById('gates');
+            gates.innerHTML = '';
+            stats.gates.forEach(function(gate) {
+                var item = document.createElement('li');
+                item.textContent = 'Gate ' + (gate.gate || '(none)') + ': ' + gate.admitted;
+                gates.appendChild(item);
+            });
+        });
+    }, 5000);
+    </script>
+</body>
+</html>`
+
+	stats, err := store.CheckInStats(event.ID)
+	if err != nil {
+		http.Error(w, "Failed to load check-ins", http.StatusInternalServerError)
+		return
+	}
+	t, _ := template.New("checkin").Parse(tmpl)
+	data := struct {
+		Event     Event
+		Stats     CheckInStats
+		Gate      string
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Event:     event,
+		Stats:     stats,
+		Gate:      r.URL.Query().Get("gate"),
+		Message:   r.URL.Query().Get("message"),
+		Error:     r.URL.Query().Get("error"),
+		CSRFToken: csrfToken(w, r),
+	}
+	t.Execute(w, data)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// reserveTestBooking books tickets to an event with the given status and returns the booking with its tickets.
+func reserveTestBooking(t *testing.T, eventID, tickets int, status string) EventBooking {
+	t.Helper()
+	booking, err := store.ReserveTickets(draftTestBooking(t, eventID, tickets, status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(booking.Tickets) != tickets {
+		t.Fatalf("booking %d has %d tickets, want %d", booking.ID, len(booking.Tickets), tickets)
+	}
+	return booking
+}
+
+// admittedTo reads how many people have been admitted to an event.
+func admittedTo(t *testing.T, eventID int) int {
+	t.Helper()
+	stats, err := store.CheckInStats(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats.Admitted
+}
+
+// TestCheckInRefusesTickets turns away forged codes, tickets for another event and tickets of bookings
+// that are not confirmed, admitting no one.
+func TestCheckInRefusesTickets(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		staff, _ := createTestSession(t, "door", roleStaff)
+		event := createTestEvent(t, 10, 25)
+		other := createTestEvent(t, 10, 25)
+		ticket := reserveTestBooking(t, event.ID, 1, "confirmed").Tickets[0]
+		otherTicket := reserveTestBooking(t, other.ID, 1, "confirmed").Tickets[0]
+		pendingTicket := reserveTestBooking(t, event.ID, 1, "pending").Tickets[0]
+		cancelled := reserveTestBooking(t, event.ID, 1, "confirmed")
+		if err := store.CancelBooking(cancelled.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		payload := ticketPayload(event.ID, ticket)
+		signed := payload[:strings.LastIndex(payload, ".")]
+		unsigned := fmt.Sprintf("TKT1.%d.%s", event.ID, ticket.ID)
+		tests := []struct {
+			name string
+			code string
+			want error
+		}{
+			{"forged signature", signed + "." + ticketMAC("TKT1.0.forged"), errInvalidTicketCode},
+			{"missing signature", unsigned, errInvalidTicketCode},
+			{"made-up ticket", fmt.Sprintf("TKT1.%d.FORGED.%s", event.ID, ticketMAC(unsigned)), errInvalidTicketCode},
+			{"unknown ticket", ticketPayload(event.ID, Ticket{ID: "0000000000000000"}), errInvalidTicketCode},
+			{"not a ticket", "hello", errInvalidTicketCode},
+			{"ticket for another event", ticketPayload(other.ID, otherTicket), errWrongEvent},
+			{"another event's ticket signed for this one", ticketPayload(event.ID, otherTicket), errWrongEvent},
+			{"pending booking", ticketPayload(event.ID, pendingTicket), errTicketNotValid},
+			{"cancelled booking", ticketPayload(event.ID, cancelled.Tickets[0]), errTicketNotValid},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := checkInTicket(tt.code, event.ID, "North", staff); !errors.Is(err, tt.want) {
+					t.Fatalf("%v, want %v", err, tt.want)
+				}
+			})
+		}
+		if admitted := admittedTo(t, event.ID); admitted != 0 {
+			t.Fatalf("%d admitted, want 0", admitted)
+		}
+	})
+}
+
+// TestCheckInOnce admits a ticket once: scanning it again, at any gate, says when and where it was first
+// used and admits no one else.
+func TestCheckInOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		staff, _ := createTestSession(t, "door", roleStaff)
+		event := createTestEvent(t, 10, 25)
+		booking := reserveTestBooking(t, event.ID, 2, "confirmed")
+		code := ticketPayload(event.ID, booking.Tickets[0])
+
+		first, err := checkInTicket(code, event.ID, "North", staff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Admitted != 1 || first.Ticket.Gate != "North" || first.Ticket.CheckedInBy != staff.ID || first.Ticket.CheckedInAt == nil {
+			t.Fatalf("first scan %+v, want ticket admitted at North by staff %d", first, staff.ID)
+		}
+
+		want := fmt.Sprintf("already checked in at %s, gate North", first.Ticket.CheckedInAt.Local().Format("15:04"))
+		for _, gate := range []string{"North", "South"} {
+			_, err := checkInTicket(code, event.ID, gate, staff)
+			if !errors.Is(err, errAlreadyCheckedIn) || err.Error() != want {
+				t.Fatalf("scanning again at %s: %v, want %q", gate, err, want)
+			}
+		}
+		if admitted := admittedTo(t, event.ID); admitted != 1 {
+			t.Fatalf("%d admitted after scanning one ticket three times, want 1", admitted)
+		}
+
+		second, err := checkInTicket(ticketPayload(event.ID, booking.Tickets[1]), event.ID, "South", staff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.Admitted != 2 {
+			t.Fatalf("%d admitted after the booking's second ticket, want 2", second.Admitted)
+		}
+	})
+}
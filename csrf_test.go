@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestSession adds a user with role and opens a session for them, returning the user and session token.
+func createTestSession(t *testing.T, username, role string) (User, string) {
+	t.Helper()
+	user, err := store.CreateUser(User{Username: username, Email: username + "@example.com", Password: "hash", Role: role, Created: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := generateSessionToken()
+	if err := store.CreateSession(Session{Token: token, UserID: user.ID, Expires: time.Now().Add(sessionTTL)}); err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// TestCSRFMiddleware posts forms and JSON with and without tokens: only a token signed for the request's
+// own session or visitor cookie gets through.
+func TestCSRFMiddleware(t *testing.T) {
+	useStore(t, newMemoryStore())
+	_, session := createTestSession(t, "ada", roleCustomer)
+	_, otherSession := createTestSession(t, "grace", roleCustomer)
+	sessionCookie := &http.Cookie{Name: "session_token", Value: session}
+	visitorCookie := &http.Cookie{Name: csrfCookieName, Value: "visitor-1"}
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		form    string // csrf_token form field
+		header  string // X-CSRF-Token header, sent with a JSON body
+		want    int
+	}{
+		{"session form token", []*http.Cookie{sessionCookie}, signCSRF("session:" + session), "", http.StatusOK},
+		{"visitor form token", []*http.Cookie{visitorCookie}, signCSRF("visitor:visitor-1"), "", http.StatusOK},
+		{"session header token", []*http.Cookie{sessionCookie}, "", signCSRF("session:" + session), http.StatusOK},
+		{"missing token", []*http.Cookie{sessionCookie}, "", "", http.StatusForbidden},
+		{"wrong token", []*http.Cookie{sessionCookie}, "not-a-token", "", http.StatusForbidden},
+		{"wrong header token", []*http.Cookie{sessionCookie}, "", "not-a-token", http.StatusForbidden},
+		{"another session's token", []*http.Cookie{sessionCookie}, signCSRF("session:" + otherSession), "", http.StatusForbidden},
+		{"visitor token with a session", []*http.Cookie{sessionCookie, visitorCookie}, signCSRF("visitor:visitor-1"), "", http.StatusForbidden},
+		{"another visitor's token", []*http.Cookie{visitorCookie}, signCSRF("visitor:visitor-2"), "", http.StatusForbidden},
+		{"no session or visitor cookie", nil, signCSRF("visitor:"), "", http.StatusForbidden},
+		{"unknown session", []*http.Cookie{{Name: "session_token", Value: "unknown"}}, signCSRF("session:unknown"), "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.header != "" {
+				req = httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(csrfHeaderName, tt.header)
+			} else {
+				form := url.Values{}
+				if tt.form != "" {
+					form.Set(csrfFieldName, tt.form)
+				}
+				req = httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for _, cookie := range tt.cookies {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			csrfMiddleware(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("%d %s, want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tt.want)
+			}
+		})
+	}
+}
+
+// TestCSRFTokenIssuesVisitorCookie gives a visitor without a session a cookie along with the form token,
+// and the token is accepted once the cookie comes back.
+func TestCSRFTokenIssuesVisitorCookie(t *testing.T) {
+	useStore(t, newMemoryStore())
+	rec := httptest.NewRecorder()
+	token := csrfToken(rec, httptest.NewRequest("GET", "/login", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("set cookies %v, want one %s cookie", cookies, csrfCookieName)
+	}
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{csrfFieldName: {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	if !validCSRFToken(req) {
+		t.Fatal("the token issued with the visitor cookie was rejected")
+	}
+}
@@ -2,61 +2,1469 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func initializeDB() *sql.DB {
-	db, err := sql.Open("sqlite3", "./bookings.db")
+// openDB opens bookings.db without touching its schema.
+func openDB() *sql.DB {
+	return openDBAt("./bookings.db")
+}
+
+// openDBAt opens the SQLite database at path. Transactions start with BEGIN IMMEDIATE so
+// that concurrent writers, including other processes, queue on the busy timeout instead of
+// failing part-way through a reservation.
+func openDBAt(path string) *sql.DB {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_txlock=immediate&_busy_timeout=5000")
 	if err != nil {
 
 		log.Fatal(err)
 	}
+	return db
+}
 
-	// Create bookings table
-	createTable := `
-	CREATE TABLE IF NOT EXISTS bookings (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		first_name TEXT NOT NULL,
-		last_name TEXT NOT NULL,
-		email TEXT NOT NULL,
-		number_of_tickets INTEGER NOT NULL,
-		booking_date DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
+// initializeDB opens bookings.db and brings its schema up to date.
+func initializeDB() *sql.DB {
+	db := openDB()
 
-	_, err = db.Exec(createTable)
-	if err != nil {
+	if err := migrateUp(db); err != nil {
 		log.Fatal(err)
 	}
 
 	return db
 }
 
-func saveBookingToDB(db *sql.DB, userData UserData) error {
-	query := `INSERT INTO bookings (first_name, last_name, email, number_of_tickets) 
-			  VALUES (?, ?, ?, ?)`
+// sqliteStore is a BookingStore backed by the tables created by the migrations.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(db *sql.DB) *sqliteStore {
+	return &sqliteStore{db: db}
+}
+
+const eventColumns = `id, name, description, date, location, total_tickets, remaining_tickets, ticket_price, currency, active`
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (Event, error) {
+	var event Event
+	err := row.Scan(&event.ID, &event.Name, &event.Description, &event.Date, &event.Location,
+		&event.TotalTickets, &event.RemainingTickets, &event.TicketPrice, &event.Currency, &event.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Event{}, errNotFound
+	}
+	return event, err
+}
+
+func (s *sqliteStore) CreateEvent(event Event) (Event, error) {
+	query := `INSERT INTO events (name, description, date, location, total_tickets, remaining_tickets, ticket_price, currency, active)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := s.db.Exec(query, event.Name, event.Description, event.Date, event.Location,
+		event.TotalTickets, event.RemainingTickets, event.TicketPrice, event.Currency, event.Active)
+	if err != nil {
+		return Event{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Event{}, err
+	}
+	event.ID = int(id)
+	return event, nil
+}
+
+func (s *sqliteStore) GetEvent(id int) (Event, error) {
+	return scanEvent(s.db.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = ?", id))
+}
+
+func (s *sqliteStore) ListEvents() ([]Event, error) {
+	rows, err := s.db.Query("SELECT " + eventColumns + " FROM events ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Event
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, event)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) UpdateEvent(event Event) error {
+	query := `UPDATE events SET name = ?, description = ?, date = ?, location = ?, total_tickets = ?,
+			  remaining_tickets = ?, ticket_price = ?, currency = ?, active = ? WHERE id = ?`
+
+	result, err := s.db.Exec(query, event.Name, event.Description, event.Date, event.Location,
+		event.TotalTickets, event.RemainingTickets, event.TicketPrice, event.Currency, event.Active, event.ID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) ReviseEvent(event Event) (Event, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Event{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanEvent(tx.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = ?", event.ID))
+	if err != nil {
+		return Event{}, err
+	}
+	sold := current.TotalTickets - current.RemainingTickets
+	if event.TotalTickets < sold {
+		return Event{}, errCapacityBelowSold
+	}
+	event.RemainingTickets = event.TotalTickets - sold
+
+	_, err = tx.Exec(`UPDATE events SET name = ?, description = ?, date = ?, location = ?, total_tickets = ?,
+			  remaining_tickets = ?, ticket_price = ?, currency = ?, active = ? WHERE id = ?`,
+		event.Name, event.Description, event.Date, event.Location, event.TotalTickets,
+		event.RemainingTickets, event.TicketPrice, event.Currency, event.Active, event.ID)
+	if err != nil {
+		return Event{}, err
+	}
+	return event, tx.Commit()
+}
+
+func (s *sqliteStore) DeleteEvent(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var booked bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM bookings WHERE event_id = ?)", id).Scan(&booked); err != nil {
+		return err
+	}
+	if booked {
+		return errEventHasBookings
+	}
+	if _, err := tx.Exec("DELETE FROM ticket_types WHERE event_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM waitlist_entries WHERE event_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM seats WHERE event_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM events WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := requireRowsAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const ticketTypeColumns = `id, event_id, name, price, quota, remaining, sales_start, sales_end, min_per_order, max_per_order`
+
+func scanTicketType(row interface{ Scan(...interface{}) error }) (TicketType, error) {
+	var t TicketType
+	err := row.Scan(&t.ID, &t.EventID, &t.Name, &t.Price, &t.Quota, &t.Remaining, &t.SalesStart, &t.SalesEnd,
+		&t.MinPerOrder, &t.MaxPerOrder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TicketType{}, errNotFound
+	}
+	return t, err
+}
+
+func (s *sqliteStore) CreateTicketType(t TicketType) (TicketType, error) {
+	result, err := s.db.Exec(`INSERT INTO ticket_types (event_id, name, price, quota, remaining, sales_start, sales_end,
+			  min_per_order, max_per_order) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.EventID, t.Name, t.Price, t.Quota, t.Remaining, utcTime(t.SalesStart), utcTime(t.SalesEnd), t.MinPerOrder, t.MaxPerOrder)
+	if err != nil {
+		return TicketType{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return TicketType{}, err
+	}
+	t.ID = int(id)
+	return t, nil
+}
+
+func (s *sqliteStore) GetTicketType(id int) (TicketType, error) {
+	return scanTicketType(s.db.QueryRow("SELECT "+ticketTypeColumns+" FROM ticket_types WHERE id = ?", id))
+}
+
+func (s *sqliteStore) ListTicketTypes(eventID int) ([]TicketType, error) {
+	rows, err := s.db.Query("SELECT "+ticketTypeColumns+" FROM ticket_types WHERE event_id = ? ORDER BY id", eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]TicketType, 0)
+	for rows.Next() {
+		t, err := scanTicketType(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) ReviseTicketType(t TicketType) (TicketType, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return TicketType{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanTicketType(tx.QueryRow("SELECT "+ticketTypeColumns+" FROM ticket_types WHERE id = ?", t.ID))
+	if err != nil {
+		return TicketType{}, err
+	}
+	sold := current.Quota - current.Remaining
+	if t.Quota < sold {
+		return TicketType{}, errQuotaBelowSold
+	}
+	t.EventID = current.EventID
+	t.Remaining = t.Quota - sold
+
+	_, err = tx.Exec(`UPDATE ticket_types SET name = ?, price = ?, quota = ?, remaining = ?, sales_start = ?, sales_end = ?,
+			  min_per_order = ?, max_per_order = ? WHERE id = ?`,
+		t.Name, t.Price, t.Quota, t.Remaining, utcTime(t.SalesStart), utcTime(t.SalesEnd), t.MinPerOrder, t.MaxPerOrder, t.ID)
+	if err != nil {
+		return TicketType{}, err
+	}
+	return t, tx.Commit()
+}
+
+func (s *sqliteStore) DeleteTicketType(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var booked bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM booking_items WHERE ticket_type_id = ?)", id).Scan(&booked); err != nil {
+		return err
+	}
+	if booked {
+		return errTicketTypeHasBookings
+	}
+	result, err := tx.Exec("DELETE FROM ticket_types WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := requireRowsAffected(result); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// utcTime stores optional times in UTC like every other time in the database.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// takeTierTickets takes each item's tickets off its tier, failing with errSoldOut if a tier has too few left.
+func takeTierTickets(tx *sql.Tx, booking EventBooking) error {
+	for _, item := range booking.Items {
+		result, err := tx.Exec(`UPDATE ticket_types SET remaining = remaining - ? WHERE id = ? AND event_id = ? AND remaining >= ?`,
+			item.Quantity, item.TicketTypeID, booking.EventID, item.Quantity)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("%w for %s", errSoldOut, item.Name)
+		}
+	}
+	return nil
+}
+
+// returnTierTickets gives each item's tickets back to its tier, never above its quota.
+func returnTierTickets(tx *sql.Tx, booking EventBooking) error {
+	for _, item := range booking.Items {
+		_, err := tx.Exec(`UPDATE ticket_types SET remaining = MIN(quota, remaining + ?) WHERE id = ?`, item.Quantity, item.TicketTypeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertBookingItems records the tiers booked in a new booking.
+func insertBookingItems(tx *sql.Tx, booking EventBooking) error {
+	for _, item := range booking.Items {
+		_, err := tx.Exec(`INSERT INTO booking_items (booking_id, ticket_type_id, name, quantity, unit_price) VALUES (?, ?, ?, ?, ?)`,
+			booking.ID, item.TicketTypeID, item.Name, item.Quantity, item.UnitPrice)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeSeats gives a new or re-reserved booking its seats, failing with errSoldOut if one is taken. A booking
+// without seats at a seated event gets the first free seats in layout order, accessible seats last.
+func takeSeats(tx *sql.Tx, booking *EventBooking) error {
+	if len(booking.Seats) == 0 {
+		var seated bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM seats WHERE event_id = ?)", booking.EventID).Scan(&seated); err != nil {
+			return err
+		}
+		if !seated {
+			return nil
+		}
+		rows, err := tx.Query("SELECT "+seatColumns+" FROM seats WHERE event_id = ? AND booking_id IS NULL ORDER BY accessible, id LIMIT ?",
+			booking.EventID, booking.NumberOfTickets)
+		if err != nil {
+			return err
+		}
+		seats, err := scanSeats(rows)
+		if err != nil {
+			return err
+		}
+		if len(seats) < booking.NumberOfTickets {
+			return errSoldOut
+		}
+		booking.Seats = seats
+	}
+
+	for i, seat := range booking.Seats {
+		result, err := tx.Exec("UPDATE seats SET booking_id = ? WHERE id = ? AND event_id = ? AND booking_id IS NULL",
+			booking.ID, seat.ID, booking.EventID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("%w: %s is taken", errSoldOut, seat.Label())
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO booking_seats (booking_id, seat_id) VALUES (?, ?)", booking.ID, seat.ID); err != nil {
+			return err
+		}
+		booking.Seats[i].Taken = true
+	}
+	return nil
+}
 
-	_, err := db.Exec(query, userData.firstName, userData.lastName, userData.email, userData.numberOfTickets)
+// returnSeats frees the seats a booking holds.
+func returnSeats(tx *sql.Tx, bookingID int) error {
+	_, err := tx.Exec("UPDATE seats SET booking_id = NULL WHERE booking_id = ?", bookingID)
 	return err
 }
 
-func getBookingsFromDB(db *sql.DB) ([]UserData, error) {
-	rows, err := db.Query("SELECT first_name, last_name, email, number_of_tickets FROM bookings")
+// queryer is a *sql.DB or *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// loadBookingDetails fills in the items, seats and tickets of each booking in list.
+func loadBookingDetails(q queryer, list []EventBooking) error {
+	if err := loadBookingItems(q, list); err != nil {
+		return err
+	}
+	if err := loadBookingSeats(q, list); err != nil {
+		return err
+	}
+	return loadBookingTickets(q, list)
+}
+
+// loadBookingTickets fills in the tickets of each booking in list; with a single booking only its tickets are read.
+func loadBookingTickets(q queryer, list []EventBooking) error {
+	if len(list) == 0 {
+		return nil
+	}
+	query := "SELECT " + ticketColumns + " FROM tickets ORDER BY booking_id, number"
+	var args []interface{}
+	if len(list) == 1 {
+		query = "SELECT " + ticketColumns + " FROM tickets WHERE booking_id = ? ORDER BY number"
+		args = append(args, list[0].ID)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tickets := map[int][]Ticket{}
+	for rows.Next() {
+		ticket, err := scanTicket(rows)
+		if err != nil {
+			return err
+		}
+		tickets[ticket.BookingID] = append(tickets[ticket.BookingID], ticket)
+	}
+	for i := range list {
+		list[i].Tickets = tickets[list[i].ID]
+	}
+	return rows.Err()
+}
+
+// loadBookingItems fills in the items of each booking in list; with a single booking only its items are read.
+func loadBookingItems(q queryer, list []EventBooking) error {
+	if len(list) == 0 {
+		return nil
+	}
+	query := "SELECT booking_id, ticket_type_id, name, quantity, unit_price FROM booking_items ORDER BY id"
+	var args []interface{}
+	if len(list) == 1 {
+		query = "SELECT booking_id, ticket_type_id, name, quantity, unit_price FROM booking_items WHERE booking_id = ? ORDER BY id"
+		args = append(args, list[0].ID)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	items := map[int][]BookingItem{}
+	for rows.Next() {
+		var bookingID int
+		var item BookingItem
+		if err := rows.Scan(&bookingID, &item.TicketTypeID, &item.Name, &item.Quantity, &item.UnitPrice); err != nil {
+			return err
+		}
+		items[bookingID] = append(items[bookingID], item)
+	}
+	for i := range list {
+		list[i].Items = items[list[i].ID]
+	}
+	return rows.Err()
+}
+
+// ReserveTickets takes the booking's tickets off its event, tiers and seats, checks its discount code's usage
+// limits, and records the booking in one transaction. The conditional UPDATE only succeeds while enough tickets remain, so two
+// processes sharing bookings.db can never sell more than TotalTickets.
+func (s *sqliteStore) ReserveTickets(booking EventBooking) (EventBooking, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return EventBooking{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE events SET remaining_tickets = remaining_tickets - ?
+			  WHERE id = ? AND active = 1 AND remaining_tickets >= ?`,
+		booking.NumberOfTickets, booking.EventID, booking.NumberOfTickets)
+	if err != nil {
+		return EventBooking{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return EventBooking{}, err
+	} else if n == 0 {
+		event, err := scanEvent(tx.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = ?", booking.EventID))
+		if err != nil {
+			return EventBooking{}, err
+		}
+		if !event.Active {
+			return EventBooking{}, errEventInactive
+		}
+		return EventBooking{}, errSoldOut
+	}
+	if err := takeTierTickets(tx, booking); err != nil {
+		return EventBooking{}, err
+	}
+	if err := checkDiscountLimits(tx, booking); err != nil {
+		return EventBooking{}, err
+	}
+
+	query := `INSERT INTO bookings (event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status,
+			  payment_intent_id, hold_expires, original_amount, discount_amount, discount_code_id, discount_code, awaiting_verification)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err = tx.Exec(query, booking.EventID, booking.UserID, booking.FirstName, booking.LastName,
+		booking.Email, booking.NumberOfTickets, booking.TotalAmount, booking.BookingDate, booking.Status,
+		booking.PaymentIntentID, booking.HoldExpires, booking.OriginalAmount, booking.DiscountAmount,
+		nullableID(booking.DiscountCodeID), booking.DiscountCode, booking.AwaitingVerification)
+	if err != nil {
+		return EventBooking{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return EventBooking{}, err
+	}
+	booking.ID = int(id)
+	if err := insertBookingItems(tx, booking); err != nil {
+		return EventBooking{}, err
+	}
+	if err := takeSeats(tx, &booking); err != nil {
+		return EventBooking{}, err
+	}
+	booking.Tickets = newTickets(booking)
+	for _, ticket := range booking.Tickets {
+		if _, err := tx.Exec("INSERT INTO tickets (id, booking_id, number) VALUES (?, ?, ?)", ticket.ID, ticket.BookingID, ticket.Number); err != nil {
+			return EventBooking{}, err
+		}
+	}
+	return booking, tx.Commit()
+}
+
+const bookingColumns = `id, event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status, payment_intent_id, hold_expires,
+	original_amount, discount_amount, discount_code_id, discount_code, awaiting_verification`
+
+func scanBooking(row interface{ Scan(...interface{}) error }) (EventBooking, error) {
+	var booking EventBooking
+	var discountCodeID sql.NullInt64
+	err := row.Scan(&booking.ID, &booking.EventID, &booking.UserID, &booking.FirstName, &booking.LastName,
+		&booking.Email, &booking.NumberOfTickets, &booking.TotalAmount, &booking.BookingDate, &booking.Status,
+		&booking.PaymentIntentID, &booking.HoldExpires, &booking.OriginalAmount, &booking.DiscountAmount,
+		&discountCodeID, &booking.DiscountCode, &booking.AwaitingVerification)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EventBooking{}, errNotFound
+	}
+	booking.DiscountCodeID = int(discountCodeID.Int64)
+	return booking, err
+}
+
+// nullableID stores 0 as NULL, for optional references.
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (s *sqliteStore) CreateBooking(booking EventBooking) (EventBooking, error) {
+	query := `INSERT INTO bookings (event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status,
+			  payment_intent_id, hold_expires, original_amount, discount_amount, discount_code_id, discount_code, awaiting_verification)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := s.db.Exec(query, booking.EventID, booking.UserID, booking.FirstName, booking.LastName,
+		booking.Email, booking.NumberOfTickets, booking.TotalAmount, booking.BookingDate, booking.Status,
+		booking.PaymentIntentID, booking.HoldExpires, booking.OriginalAmount, booking.DiscountAmount,
+		nullableID(booking.DiscountCodeID), booking.DiscountCode, booking.AwaitingVerification)
+	if err != nil {
+		return EventBooking{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return EventBooking{}, err
+	}
+	booking.ID = int(id)
+	return booking, nil
+}
+
+const ticketColumns = `id, booking_id, number, checked_in_at, gate, checked_in_by`
+
+func scanTicket(row interface{ Scan(...interface{}) error }) (Ticket, error) {
+	var ticket Ticket
+	var checkedInBy sql.NullInt64
+	err := row.Scan(&ticket.ID, &ticket.BookingID, &ticket.Number, &ticket.CheckedInAt, &ticket.Gate, &checkedInBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Ticket{}, errNotFound
+	}
+	ticket.CheckedInBy = int(checkedInBy.Int64)
+	return ticket, err
+}
+
+func (s *sqliteStore) GetTicket(id string) (Ticket, error) {
+	return scanTicket(s.db.QueryRow("SELECT "+ticketColumns+" FROM tickets WHERE id = ?", id))
+}
+
+func (s *sqliteStore) CheckInTicket(id string, at time.Time, gate string, staffID int) (Ticket, error) {
+	result, err := s.db.Exec("UPDATE tickets SET checked_in_at = ?, gate = ?, checked_in_by = ? WHERE id = ? AND checked_in_at IS NULL",
+		at.UTC(), gate, nullableID(staffID), id)
+	if err != nil {
+		return Ticket{}, err
+	}
+	ticket, err := s.GetTicket(id)
+	if err != nil {
+		return Ticket{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Ticket{}, err
+	} else if n == 0 {
+		return ticket, errAlreadyCheckedIn
+	}
+	return ticket, nil
+}
+
+func (s *sqliteStore) CheckInStats(eventID int) (CheckInStats, error) {
+	rows, err := s.db.Query(`SELECT tickets.`+strings.ReplaceAll(ticketColumns, ", ", ", tickets.")+` FROM tickets
+			  JOIN bookings ON bookings.id = tickets.booking_id WHERE bookings.event_id = ? AND bookings.status = 'confirmed'`, eventID)
+	if err != nil {
+		return CheckInStats{}, err
+	}
+	defer rows.Close()
+
+	stats := newCheckInStats(eventID)
+	for rows.Next() {
+		ticket, err := scanTicket(rows)
+		if err != nil {
+			return CheckInStats{}, err
+		}
+		stats.count(ticket)
+	}
+	stats.sortGates()
+	return stats, rows.Err()
+}
+
+func (s *sqliteStore) GetBooking(id int) (EventBooking, error) {
+	return getBooking(s.db, id)
+}
+
+// getBooking reads a booking with its items, seats and tickets.
+func getBooking(q queryer, id int) (EventBooking, error) {
+	booking, err := scanBooking(q.QueryRow("SELECT "+bookingColumns+" FROM bookings WHERE id = ?", id))
+	if err != nil {
+		return EventBooking{}, err
+	}
+	list := []EventBooking{booking}
+	err = loadBookingDetails(q, list)
+	return list[0], err
+}
+
+func (s *sqliteStore) ListBookings() ([]EventBooking, error) {
+	rows, err := s.db.Query("SELECT " + bookingColumns + " FROM bookings ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var bookings []UserData
+	var list []EventBooking
 	for rows.Next() {
-		var userData UserData
-		err := rows.Scan(&userData.firstName, &userData.lastName, &userData.email, &userData.numberOfTickets)
+		booking, err := scanBooking(rows)
 		if err != nil {
 			return nil, err
 		}
-		bookings = append(bookings, userData)
+		list = append(list, booking)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, loadBookingDetails(s.db, list)
+}
+
+func (s *sqliteStore) CancelBooking(id int) error {
+	booking, err := s.GetBooking(id)
+	if err != nil {
+		return err
 	}
+	if booking.Status == "cancelled" {
+		return errAlreadyCancelled
+	}
+
+	_, err = s.db.Exec("UPDATE bookings SET status = 'cancelled' WHERE id = ?", id)
+	return err
+}
 
-	return bookings, nil
+func (s *sqliteStore) SetPaymentIntent(bookingID int, paymentIntentID string) error {
+	result, err := s.db.Exec("UPDATE bookings SET payment_intent_id = ? WHERE id = ?", paymentIntentID, bookingID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) ConfirmBooking(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	booking, err := getBooking(tx, id)
+	if err != nil {
+		return err
+	}
+
+	switch booking.Status {
+	case "confirmed":
+		return nil
+	case "pending":
+	case "expired", "failed":
+		// The hold lapsed before payment arrived; take the tickets again if they are still there, and
+		// the discount code if others have not used it up meanwhile.
+		if err := checkDiscountLimits(tx, booking); err != nil {
+			return err
+		}
+		result, err := tx.Exec(`UPDATE events SET remaining_tickets = remaining_tickets - ?
+				  WHERE id = ? AND remaining_tickets >= ?`,
+			booking.NumberOfTickets, booking.EventID, booking.NumberOfTickets)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errSoldOut
+		}
+		if err := takeTierTickets(tx, booking); err != nil {
+			return err
+		}
+		if err := takeSeats(tx, &booking); err != nil {
+			return err
+		}
+	default:
+		return errNotPending
+	}
+
+	if _, err := tx.Exec("UPDATE bookings SET status = 'confirmed', hold_expires = NULL WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) ReleaseHold(id int, status string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	booking, err := getBooking(tx, id)
+	if err != nil {
+		return err
+	}
+	if booking.Status != "pending" {
+		return errNotPending
+	}
+
+	_, err = tx.Exec(`UPDATE events SET remaining_tickets = MIN(total_tickets, remaining_tickets + ?) WHERE id = ?`,
+		booking.NumberOfTickets, booking.EventID)
+	if err != nil {
+		return err
+	}
+	if err := returnTierTickets(tx, booking); err != nil {
+		return err
+	}
+	if err := returnSeats(tx, booking.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE bookings SET status = ?, hold_expires = NULL WHERE id = ?", status, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) ExpiredHolds(now time.Time) ([]EventBooking, error) {
+	rows, err := s.db.Query("SELECT "+bookingColumns+" FROM bookings WHERE status = 'pending' AND hold_expires < ? ORDER BY id", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []EventBooking
+	for rows.Next() {
+		booking, err := scanBooking(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, booking)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, loadBookingDetails(s.db, list)
+}
+
+func (s *sqliteStore) GetBookingByPaymentIntent(paymentIntentID string) (EventBooking, error) {
+	if paymentIntentID == "" {
+		return EventBooking{}, errNotFound
+	}
+	booking, err := scanBooking(s.db.QueryRow("SELECT "+bookingColumns+" FROM bookings WHERE payment_intent_id = ?", paymentIntentID))
+	if err != nil {
+		return EventBooking{}, err
+	}
+	return getBooking(s.db, booking.ID)
+}
+
+func (s *sqliteStore) ReleaseBooking(id int, status string) (EventBooking, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return EventBooking{}, err
+	}
+	defer tx.Rollback()
+
+	booking, err := getBooking(tx, id)
+	if err != nil {
+		return EventBooking{}, err
+	}
+
+	// Claim the booking first, so of two concurrent releases only one goes on to return tickets.
+	result, err := tx.Exec("UPDATE bookings SET status = ?, hold_expires = NULL WHERE id = ? AND status NOT IN (?, 'refunded')",
+		status, id, status)
+	if err != nil {
+		return EventBooking{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return EventBooking{}, err
+	} else if n == 0 {
+		return EventBooking{}, errAlreadyCancelled
+	}
+
+	if booking.HoldsTickets() {
+		_, err = tx.Exec(`UPDATE events SET remaining_tickets = MIN(total_tickets, remaining_tickets + ?) WHERE id = ?`,
+			booking.NumberOfTickets, booking.EventID)
+		if err != nil {
+			return EventBooking{}, err
+		}
+		if err := returnTierTickets(tx, booking); err != nil {
+			return EventBooking{}, err
+		}
+		if err := returnSeats(tx, booking.ID); err != nil {
+			return EventBooking{}, err
+		}
+	}
+	return booking, tx.Commit()
+}
+
+func (s *sqliteStore) RecordWebhookEvent(eventID, eventType string) (bool, error) {
+	result, err := s.db.Exec("INSERT OR IGNORE INTO webhook_events (event_id, type, processed_at) VALUES (?, ?, ?)",
+		eventID, eventType, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqliteStore) ForgetWebhookEvent(eventID string) error {
+	_, err := s.db.Exec("DELETE FROM webhook_events WHERE event_id = ?", eventID)
+	return err
+}
+
+func (s *sqliteStore) QueueRefund(refund PendingRefund) error {
+	_, err := s.db.Exec(`INSERT INTO pending_refunds (booking_id, payment_intent_id, amount, attempts, last_error, created)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		refund.BookingID, refund.PaymentIntentID, refund.Amount, refund.Attempts, refund.LastError, refund.Created.UTC())
+	return err
+}
+
+func (s *sqliteStore) PendingRefunds(before time.Time) ([]PendingRefund, error) {
+	rows, err := s.db.Query(`SELECT booking_id, payment_intent_id, amount, attempts, last_error, created
+		FROM pending_refunds WHERE created < ? ORDER BY created, booking_id`, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []PendingRefund
+	for rows.Next() {
+		var refund PendingRefund
+		err := rows.Scan(&refund.BookingID, &refund.PaymentIntentID, &refund.Amount, &refund.Attempts, &refund.LastError, &refund.Created)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, refund)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) RecordRefundAttempt(bookingID int, lastError string) error {
+	result, err := s.db.Exec("UPDATE pending_refunds SET attempts = attempts + 1, last_error = ? WHERE booking_id = ?",
+		lastError, bookingID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) DeletePendingRefund(bookingID int) error {
+	result, err := s.db.Exec("DELETE FROM pending_refunds WHERE booking_id = ?", bookingID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+const userColumns = `id, username, email, email_verified, password, role, created`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password, &user.Role, &user.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errNotFound
+	}
+	return user, err
+}
+
+func (s *sqliteStore) CreateUser(user User) (User, error) {
+	query := `INSERT INTO users (username, email, email_verified, password, role, created) VALUES (?, ?, ?, ?, ?, ?)`
+
+	result, err := s.db.Exec(query, user.Username, user.Email, user.EmailVerified, user.Password, user.Role, user.Created)
+	if err != nil {
+		return User{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = int(id)
+	return user, nil
+}
+
+func (s *sqliteStore) GetUser(id int) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s *sqliteStore) GetUserByUsername(username string) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func (s *sqliteStore) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, user)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) SetEmailVerified(id int) error {
+	result, err := s.db.Exec("UPDATE users SET email_verified = 1 WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) SetUserRole(id int, role string) error {
+	result, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) UpdateUserPassword(id int, passwordHash string) error {
+	result, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) RecordLoginAttempt(attempt LoginAttempt) error {
+	_, err := s.db.Exec(`INSERT INTO login_attempts (username, ip, success, reason, attempted_at) VALUES (?, ?, ?, ?, ?)`,
+		attempt.Username, attempt.IP, attempt.Success, attempt.Reason, attempt.AttemptedAt.UTC())
+	return err
+}
+
+func (s *sqliteStore) AccountLoginFailures(username string, since time.Time) (int, time.Time, error) {
+	return s.loginFailures(`SELECT attempted_at FROM login_attempts
+			  WHERE username = ? AND success = 0 AND reason != ? AND attempted_at > ?
+			  AND attempted_at > COALESCE((SELECT MAX(attempted_at) FROM login_attempts WHERE username = ? AND success = 1), '')
+			  ORDER BY attempted_at DESC`,
+		username, loginReasonThrottled, since.UTC(), username)
+}
+
+func (s *sqliteStore) IPLoginFailures(ip string, since time.Time) (int, time.Time, error) {
+	return s.loginFailures(`SELECT attempted_at FROM login_attempts
+			  WHERE ip = ? AND success = 0 AND reason != ? AND attempted_at > ?
+			  ORDER BY attempted_at DESC`,
+		ip, loginReasonThrottled, since.UTC())
+}
+
+// loginFailures counts the rows of a newest-first failed-attempt query and returns the newest time.
+func (s *sqliteStore) loginFailures(query string, args ...interface{}) (int, time.Time, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer rows.Close()
+
+	count, latest := 0, time.Time{}
+	for rows.Next() {
+		var attemptedAt time.Time
+		if err := rows.Scan(&attemptedAt); err != nil {
+			return 0, time.Time{}, err
+		}
+		if count == 0 {
+			latest = attemptedAt
+		}
+		count++
+	}
+	return count, latest, rows.Err()
+}
+
+func (s *sqliteStore) ListLoginAttempts(limit int) ([]LoginAttempt, error) {
+	rows, err := s.db.Query(`SELECT id, username, ip, success, reason, attempted_at FROM login_attempts
+			  ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []LoginAttempt
+	for rows.Next() {
+		var attempt LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.Success, &attempt.Reason, &attempt.AttemptedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, attempt)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) CreateSession(session Session) error {
+	// Expiries are stored in UTC so the reaper can compare them as text.
+	_, err := s.db.Exec("INSERT INTO sessions (token, user_id, expires) VALUES (?, ?, ?)",
+		session.Token, session.UserID, session.Expires.UTC())
+	return err
+}
+
+func (s *sqliteStore) GetSession(token string) (Session, error) {
+	var session Session
+	err := s.db.QueryRow("SELECT token, user_id, expires FROM sessions WHERE token = ?", token).
+		Scan(&session.Token, &session.UserID, &session.Expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, errNotFound
+	}
+	return session, err
+}
+
+func (s *sqliteStore) DeleteSession(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token = ?", token)
+	return err
+}
+
+func (s *sqliteStore) TouchSession(token string, expires time.Time) error {
+	result, err := s.db.Exec("UPDATE sessions SET expires = ? WHERE token = ?", expires.UTC(), token)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) DeleteUserSessions(userID int) (int, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqliteStore) DeleteExpiredSessions(now time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE expires < ?", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created, last_used, revoked`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.Created, &key.LastUsed, &key.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, errNotFound
+	}
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+func (s *sqliteStore) CreateAPIKey(key APIKey) (APIKey, error) {
+	result, err := s.db.Exec(`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created) VALUES (?, ?, ?, ?, ?, ?)`,
+		key.UserID, key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "), key.Created.UTC())
+	if err != nil {
+		return APIKey{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.ID = int(id)
+	return key, nil
+}
+
+func (s *sqliteStore) GetAPIKeyByHash(hash string) (APIKey, error) {
+	return scanAPIKey(s.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", hash))
+}
+
+func (s *sqliteStore) ListAPIKeys(userID int) ([]APIKey, error) {
+	rows, err := s.db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, key)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) RevokeAPIKey(userID, id int, at time.Time) error {
+	result, err := s.db.Exec("UPDATE api_keys SET revoked = ? WHERE id = ? AND user_id = ? AND revoked IS NULL", at.UTC(), id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+func (s *sqliteStore) TouchAPIKey(id int, at time.Time) error {
+	result, err := s.db.Exec("UPDATE api_keys SET last_used = ? WHERE id = ?", at.UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+// requireRowsAffected turns an UPDATE or DELETE that matched nothing into errNotFound.
+func requireRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// discountCodeColumns ends with the code's uses: its bookings that hold tickets.
+const discountCodeColumns = `id, code, kind, value, event_ids, ticket_type_ids, max_uses, max_uses_per_user, valid_from, valid_until, active, created,
+	(SELECT COUNT(*) FROM bookings WHERE discount_code_id = discount_codes.id AND status IN ('pending', 'confirmed'))`
+
+func scanDiscountCode(row interface{ Scan(...interface{}) error }) (DiscountCode, error) {
+	var d DiscountCode
+	var eventIDs, ticketTypeIDs string
+	err := row.Scan(&d.ID, &d.Code, &d.Kind, &d.Value, &eventIDs, &ticketTypeIDs, &d.MaxUses, &d.MaxUsesPerUser,
+		&d.ValidFrom, &d.ValidUntil, &d.Active, &d.Created, &d.Uses)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DiscountCode{}, errNotFound
+	}
+	d.EventIDs = parseIDs(eventIDs)
+	d.TicketTypeIDs = parseIDs(ticketTypeIDs)
+	return d, err
+}
+
+// formatIDs and parseIDs store a list of IDs as a space-separated string.
+func formatIDs(ids []int) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseIDs(value string) []int {
+	ids := make([]int, 0)
+	for _, field := range strings.Fields(value) {
+		if id, err := strconv.Atoi(field); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *sqliteStore) CreateDiscountCode(d DiscountCode) (DiscountCode, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return DiscountCode{}, err
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM discount_codes WHERE code = ?)", d.Code).Scan(&taken); err != nil {
+		return DiscountCode{}, err
+	}
+	if taken {
+		return DiscountCode{}, errDiscountCodeTaken
+	}
+	result, err := tx.Exec(`INSERT INTO discount_codes (code, kind, value, event_ids, ticket_type_ids, max_uses, max_uses_per_user,
+			  valid_from, valid_until, active, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.Code, d.Kind, d.Value, formatIDs(d.EventIDs), formatIDs(d.TicketTypeIDs), d.MaxUses, d.MaxUsesPerUser,
+		utcTime(d.ValidFrom), utcTime(d.ValidUntil), d.Active, d.Created.UTC())
+	if err != nil {
+		return DiscountCode{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DiscountCode{}, err
+	}
+	d.ID = int(id)
+	return d, tx.Commit()
+}
+
+func (s *sqliteStore) GetDiscountCode(id int) (DiscountCode, error) {
+	return scanDiscountCode(s.db.QueryRow("SELECT "+discountCodeColumns+" FROM discount_codes WHERE id = ?", id))
+}
+
+func (s *sqliteStore) GetDiscountCodeByCode(code string) (DiscountCode, error) {
+	return scanDiscountCode(s.db.QueryRow("SELECT "+discountCodeColumns+" FROM discount_codes WHERE code = ?", code))
+}
+
+func (s *sqliteStore) ListDiscountCodes() ([]DiscountCode, error) {
+	rows, err := s.db.Query("SELECT " + discountCodeColumns + " FROM discount_codes ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]DiscountCode, 0)
+	for rows.Next() {
+		d, err := scanDiscountCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) UpdateDiscountCode(d DiscountCode) error {
+	result, err := s.db.Exec(`UPDATE discount_codes SET kind = ?, value = ?, event_ids = ?, ticket_type_ids = ?, max_uses = ?,
+			  max_uses_per_user = ?, valid_from = ?, valid_until = ?, active = ? WHERE id = ?`,
+		d.Kind, d.Value, formatIDs(d.EventIDs), formatIDs(d.TicketTypeIDs), d.MaxUses, d.MaxUsesPerUser,
+		utcTime(d.ValidFrom), utcTime(d.ValidUntil), d.Active, d.ID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+const seatColumns = `id, event_id, section, row_label, number, accessible, booking_id IS NOT NULL`
+
+func scanSeats(rows *sql.Rows) ([]Seat, error) {
+	defer rows.Close()
+
+	list := make([]Seat, 0)
+	for rows.Next() {
+		var seat Seat
+		if err := rows.Scan(&seat.ID, &seat.EventID, &seat.Section, &seat.Row, &seat.Number, &seat.Accessible, &seat.Taken); err != nil {
+			return nil, err
+		}
+		list = append(list, seat)
+	}
+	return list, rows.Err()
+}
+
+// loadBookingSeats fills in the seats each booking in list was given; with a single booking only its seats are read.
+func loadBookingSeats(q queryer, list []EventBooking) error {
+	if len(list) == 0 {
+		return nil
+	}
+	query := `SELECT booking_seats.booking_id, seats.id, seats.event_id, seats.section, seats.row_label, seats.number, seats.accessible,
+			  seats.booking_id IS NOT NULL FROM booking_seats JOIN seats ON seats.id = booking_seats.seat_id ORDER BY seats.id`
+	var args []interface{}
+	if len(list) == 1 {
+		query = `SELECT booking_seats.booking_id, seats.id, seats.event_id, seats.section, seats.row_label, seats.number, seats.accessible,
+				  seats.booking_id IS NOT NULL FROM booking_seats JOIN seats ON seats.id = booking_seats.seat_id
+				  WHERE booking_seats.booking_id = ? ORDER BY seats.id`
+		args = append(args, list[0].ID)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	seats := map[int][]Seat{}
+	for rows.Next() {
+		var bookingID int
+		var seat Seat
+		if err := rows.Scan(&bookingID, &seat.ID, &seat.EventID, &seat.Section, &seat.Row, &seat.Number, &seat.Accessible, &seat.Taken); err != nil {
+			return err
+		}
+		seats[bookingID] = append(seats[bookingID], seat)
+	}
+	for i := range list {
+		list[i].Seats = seats[list[i].ID]
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) SetSeatMap(eventID int, seats []Seat) (Event, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Event{}, err
+	}
+	defer tx.Rollback()
+
+	event, err := scanEvent(tx.QueryRow("SELECT "+eventColumns+" FROM events WHERE id = ?", eventID))
+	if err != nil {
+		return Event{}, err
+	}
+	var booked bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM bookings WHERE event_id = ?)", eventID).Scan(&booked); err != nil {
+		return Event{}, err
+	}
+	if booked {
+		return Event{}, errSeatMapLocked
+	}
+	if _, err := tx.Exec("DELETE FROM seats WHERE event_id = ?", eventID); err != nil {
+		return Event{}, err
+	}
+	for _, seat := range seats {
+		_, err := tx.Exec("INSERT INTO seats (event_id, section, row_label, number, accessible) VALUES (?, ?, ?, ?, ?)",
+			eventID, seat.Section, seat.Row, seat.Number, seat.Accessible)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+	if len(seats) > 0 {
+		event.TotalTickets = len(seats)
+		event.RemainingTickets = len(seats)
+		if _, err := tx.Exec("UPDATE events SET total_tickets = ?, remaining_tickets = ? WHERE id = ?", len(seats), len(seats), eventID); err != nil {
+			return Event{}, err
+		}
+	}
+	return event, tx.Commit()
+}
+
+func (s *sqliteStore) ListSeats(eventID int) ([]Seat, error) {
+	rows, err := s.db.Query("SELECT "+seatColumns+" FROM seats WHERE event_id = ? ORDER BY id", eventID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSeats(rows)
+}
+
+const waitlistColumns = `id, event_id, user_id, first_name, last_name, email, ticket_type_id, tickets, status, booking_id,
+	offer_expires, created`
+
+func scanWaitlistEntry(row interface{ Scan(...interface{}) error }) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	var bookingID sql.NullInt64
+	err := row.Scan(&entry.ID, &entry.EventID, &entry.UserID, &entry.FirstName, &entry.LastName, &entry.Email,
+		&entry.TicketTypeID, &entry.Tickets, &entry.Status, &bookingID, &entry.OfferExpires, &entry.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WaitlistEntry{}, errNotFound
+	}
+	entry.BookingID = int(bookingID.Int64)
+	return entry, err
+}
+
+func (s *sqliteStore) listWaitlist(query string, args ...interface{}) ([]WaitlistEntry, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]WaitlistEntry, 0)
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, entry)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqliteStore) CreateWaitlistEntry(entry WaitlistEntry) (WaitlistEntry, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	defer tx.Rollback()
+
+	var waiting bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM waitlist_entries WHERE event_id = ? AND user_id = ?
+			  AND status IN ('waiting', 'offered'))`, entry.EventID, entry.UserID).Scan(&waiting)
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	if waiting {
+		return WaitlistEntry{}, errAlreadyWaitlisted
+	}
+	result, err := tx.Exec(`INSERT INTO waitlist_entries (event_id, user_id, first_name, last_name, email, ticket_type_id, tickets,
+			  status, booking_id, offer_expires, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.EventID, entry.UserID, entry.FirstName, entry.LastName, entry.Email, entry.TicketTypeID, entry.Tickets,
+		entry.Status, nullableID(entry.BookingID), utcTime(entry.OfferExpires), entry.Created.UTC())
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	entry.ID = int(id)
+	return entry, tx.Commit()
+}
+
+func (s *sqliteStore) GetWaitlistEntry(id int) (WaitlistEntry, error) {
+	return scanWaitlistEntry(s.db.QueryRow("SELECT "+waitlistColumns+" FROM waitlist_entries WHERE id = ?", id))
+}
+
+func (s *sqliteStore) ListWaitlist(eventID int) ([]WaitlistEntry, error) {
+	if eventID == 0 {
+		return s.listWaitlist("SELECT " + waitlistColumns + " FROM waitlist_entries ORDER BY id")
+	}
+	return s.listWaitlist("SELECT "+waitlistColumns+" FROM waitlist_entries WHERE event_id = ? ORDER BY id", eventID)
+}
+
+func (s *sqliteStore) ListUserWaitlist(userID int) ([]WaitlistEntry, error) {
+	return s.listWaitlist("SELECT "+waitlistColumns+" FROM waitlist_entries WHERE user_id = ? ORDER BY id", userID)
+}
+
+func (s *sqliteStore) UpdateWaitlistEntry(entry WaitlistEntry) error {
+	result, err := s.db.Exec("UPDATE waitlist_entries SET status = ?, booking_id = ?, offer_expires = ? WHERE id = ?",
+		entry.Status, nullableID(entry.BookingID), utcTime(entry.OfferExpires), entry.ID)
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+// checkDiscountLimits fails if the booking's discount code has already been used as often as it allows,
+// overall or by the booking's user. Transactions start with BEGIN IMMEDIATE, so concurrent checkouts
+// cannot both take the last use.
+func checkDiscountLimits(tx *sql.Tx, booking EventBooking) error {
+	if booking.DiscountCodeID == 0 {
+		return nil
+	}
+	var maxUses, maxUsesPerUser, uses, userUses int
+	err := tx.QueryRow("SELECT max_uses, max_uses_per_user FROM discount_codes WHERE id = ?", booking.DiscountCodeID).
+		Scan(&maxUses, &maxUsesPerUser)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`SELECT COUNT(*), COALESCE(SUM(user_id = ?), 0) FROM bookings
+			  WHERE discount_code_id = ? AND status IN ('pending', 'confirmed')`, booking.UserID, booking.DiscountCodeID).
+		Scan(&uses, &userUses)
+	if err != nil {
+		return err
+	}
+	switch {
+	case maxUses > 0 && uses >= maxUses:
+		return errDiscountUsedUp
+	case maxUsesPerUser > 0 && userUses >= maxUsesPerUser:
+		return errDiscountUserLimit
+	}
+	return nil
 }
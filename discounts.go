@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscountCode takes a percentage or a fixed amount off an order at checkout.
+type DiscountCode struct {
+	ID             int        `json:"id"`
+	Code           string     `json:"code"` // upper case; customers may type it in any case
+	Kind           string     `json:"kind"` // percent or fixed
+	Value          float64    `json:"value"`
+	EventIDs       []int      `json:"event_ids"`       // empty: every event
+	TicketTypeIDs  []int      `json:"ticket_type_ids"` // empty: every tier, and standard admission
+	MaxUses        int        `json:"max_uses"`        // 0: unlimited
+	MaxUsesPerUser int        `json:"max_uses_per_user"`
+	ValidFrom      *time.Time `json:"valid_from,omitempty"`
+	ValidUntil     *time.Time `json:"valid_until,omitempty"`
+	Active         bool       `json:"active"`
+	Uses           int        `json:"uses"` // bookings holding tickets with the code, counted by the store
+	Created        time.Time  `json:"created"`
+}
+
+// Discount kinds. A percent code takes Value percent off the tickets it applies to; a fixed code takes
+// Value off the order, in the event's currency, but never more than those tickets cost.
+const (
+	discountPercent = "percent"
+	discountFixed   = "fixed"
+)
+
+// errDiscountCodeTaken is returned when creating a code that already exists.
+var errDiscountCodeTaken = errors.New("that discount code already exists")
+
+// errDiscountUsedUp is returned when a code has been used as often as it allows.
+var errDiscountUsedUp = errors.New("discount code has been used up")
+
+// errDiscountUserLimit is returned when a user has used a code as often as it allows per user.
+var errDiscountUserLimit = errors.New("you have already used this discount code as often as allowed")
+
+var discountCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// normalizeDiscountCode is how codes are stored and looked up.
+func normalizeDiscountCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validateDiscountCode checks a code before it is saved, including that its events and tiers exist.
+func validateDiscountCode(d DiscountCode) error {
+	switch {
+	case !discountCodePattern.MatchString(d.Code):
+		return validationError("code must be 3 to 32 letters, digits, dashes or underscores")
+	case d.Kind != discountPercent && d.Kind != discountFixed:
+		return validationError("kind must be percent or fixed")
+	case d.Value <= 0:
+		return validationError("value must be more than 0")
+	case d.Kind == discountPercent && d.Value > 100:
+		return validationError("a percentage cannot be more than 100")
+	case d.MaxUses < 0 || d.MaxUsesPerUser < 0:
+		return validationError("usage limits cannot be negative")
+	case d.ValidFrom != nil && d.ValidUntil != nil && !d.ValidUntil.After(*d.ValidFrom):
+		return validationError("the code must expire after it becomes valid")
+	}
+
+	for _, id := range d.EventIDs {
+		if _, err := store.GetEvent(id); errors.Is(err, errNotFound) {
+			return validationError(fmt.Sprintf("event %d does not exist", id))
+		} else if err != nil {
+			return err
+		}
+	}
+	for _, id := range d.TicketTypeIDs {
+		tier, err := store.GetTicketType(id)
+		if errors.Is(err, errNotFound) {
+			return validationError(fmt.Sprintf("ticket type %d does not exist", id))
+		} else if err != nil {
+			return err
+		}
+		if len(d.EventIDs) > 0 && !containsID(d.EventIDs, tier.EventID) {
+			return validationError(fmt.Sprintf("ticket type %d is not sold for the code's events", id))
+		}
+	}
+	return nil
+}
+
+// addDiscountCode validates and stores a new code.
+func addDiscountCode(d DiscountCode) (DiscountCode, error) {
+	d.Code = normalizeDiscountCode(d.Code)
+	if err := validateDiscountCode(d); err != nil {
+		return DiscountCode{}, err
+	}
+	d.Created = time.Now()
+	return store.CreateDiscountCode(d)
+}
+
+// editDiscountCode validates and saves changes to a code. The code itself cannot change, since
+// bookings record it.
+func editDiscountCode(d DiscountCode) (DiscountCode, error) {
+	if err := validateDiscountCode(d); err != nil {
+		return DiscountCode{}, err
+	}
+	if err := store.UpdateDiscountCode(d); err != nil {
+		return DiscountCode{}, err
+	}
+	return store.GetDiscountCode(d.ID)
+}
+
+// setDiscountCodeActive turns a code on or off.
+func setDiscountCodeActive(id int, active bool) (DiscountCode, error) {
+	d, err := store.GetDiscountCode(id)
+	if err != nil {
+		return DiscountCode{}, err
+	}
+	d.Active = active
+	if err := store.UpdateDiscountCode(d); err != nil {
+		return DiscountCode{}, err
+	}
+	return d, nil
+}
+
+func containsID(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// priceBooking prices an order for userID, applying discountCode if one is given. The booking it returns
+// has its tickets, items and amounts filled in.
+func priceBooking(event Event, orders []TicketOrder, discountCode string, userID int) (EventBooking, error) {
+	items, numberOfTickets, subtotal, err := priceOrder(event, orders)
+	if err != nil {
+		return EventBooking{}, err
+	}
+	booking := EventBooking{
+		EventID:         event.ID,
+		UserID:          userID,
+		NumberOfTickets: numberOfTickets,
+		OriginalAmount:  subtotal,
+		TotalAmount:     subtotal,
+		Items:           items,
+	}
+	if strings.TrimSpace(discountCode) == "" {
+		return booking, nil
+	}
+
+	d, discount, err := priceDiscount(discountCode, event, items, subtotal, userID)
+	if err != nil {
+		return EventBooking{}, err
+	}
+	booking.DiscountCodeID = d.ID
+	booking.DiscountCode = d.Code
+	booking.DiscountAmount = discount
+	booking.TotalAmount = math.Round((subtotal-discount)*100) / 100
+	return booking, nil
+}
+
+// priceDiscount works out what a code takes off an order, failing if the code does not apply to it.
+// Usage limits are checked again by the store when the tickets are reserved.
+func priceDiscount(code string, event Event, items []BookingItem, subtotal float64, userID int) (DiscountCode, float64, error) {
+	d, err := store.GetDiscountCodeByCode(normalizeDiscountCode(code))
+	if errors.Is(err, errNotFound) {
+		return DiscountCode{}, 0, validationError("discount code not recognised")
+	}
+	if err != nil {
+		return DiscountCode{}, 0, err
+	}
+
+	now := time.Now()
+	switch {
+	case !d.Active:
+		return DiscountCode{}, 0, validationError("discount code is no longer valid")
+	case d.ValidFrom != nil && now.Before(*d.ValidFrom):
+		return DiscountCode{}, 0, validationError("discount code is not valid yet")
+	case d.ValidUntil != nil && !now.Before(*d.ValidUntil):
+		return DiscountCode{}, 0, validationError("discount code has expired")
+	case len(d.EventIDs) > 0 && !containsID(d.EventIDs, event.ID):
+		return DiscountCode{}, 0, validationError("discount code does not apply to this event")
+	case d.MaxUsesPerUser > 0 && userID == 0:
+		return DiscountCode{}, 0, validationError("sign in to use this discount code")
+	case d.MaxUses > 0 && d.Uses >= d.MaxUses:
+		return DiscountCode{}, 0, errDiscountUsedUp
+	}
+
+	eligible := subtotal
+	if len(d.TicketTypeIDs) > 0 {
+		eligible = 0
+		for _, item := range items {
+			if containsID(d.TicketTypeIDs, item.TicketTypeID) {
+				eligible += float64(item.Quantity) * item.UnitPrice
+			}
+		}
+		if eligible == 0 {
+			return DiscountCode{}, 0, validationError("discount code does not apply to these tickets")
+		}
+	}
+
+	discount := d.Value
+	if d.Kind == discountPercent {
+		discount = eligible * d.Value / 100
+	}
+	discount = math.Round(min(discount, eligible)*100) / 100
+	return d, discount, nil
+}
+
+// discountCodesHandler lists every discount code with its uses, and creates new ones.
+func discountCodesHandler(w http.ResponseWriter, r *http.Request) {
+	draft := DiscountCode{Kind: discountPercent}
+	var errMessage string
+	if r.Method == "POST" {
+		r.ParseForm()
+		created, err := parseDiscountCodeForm(r, &draft)
+		if err == nil {
+			created, err = addDiscountCode(draft)
+		}
+		if err == nil {
+			http.Redirect(w, r, "/organizer/discounts?message="+url.QueryEscape("Created "+created.Code), http.StatusSeeOther)
+			return
+		}
+		errMessage = err.Error()
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Discount Codes</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1000px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        form.inline { display: inline; }
+        .error { color: red; }
+        .success { color: green; }
+        .inactive { color: #999; }
+    </style>
+</head>
+<body>
+    <h1>Discount Codes</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    <table>
+        <tr>
+            <th>Code</th>
+            <th>Discount</th>
+            <th>Applies to</th>
+            <th>Uses</th>
+            <th>Per user</th>
+            <th>Valid</th>
+            <th></th>
+        </tr>
+        {{range .Codes}}
+        <tr {{if not .Active}}class="inactive"{{end}}>
+            <td>{{.Code}}</td>
+            <td>{{if eq .Kind "percent"}}{{.Value}}%{{else}}{{printf "%.2f" .Value}} off{{end}}</td>
+            <td>{{scope .}}</td>
+            <td>{{.Uses}}{{if .MaxUses}} of {{.MaxUses}}{{end}}</td>
+            <td>{{if .MaxUsesPerUser}}{{.MaxUsesPerUser}}{{else}}unlimited{{end}}</td>
+            <td>
+                {{if .ValidFrom}}from {{.ValidFrom.Local.Format "Jan 2, 2006 15:04"}}{{end}}
+                {{if .ValidUntil}}until {{.ValidUntil.Local.Format "Jan 2, 2006 15:04"}}{{end}}
+                {{if not (or .ValidFrom .ValidUntil)}}always{{end}}
+            </td>
+            <td>
+                {{if .Active}}
+                <form class="inline" method="POST" action="/organizer/discounts/{{.ID}}/disable">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Disable</button>
+                </form>
+                {{else}}
+                <form class="inline" method="POST" action="/organizer/discounts/{{.ID}}/enable">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Enable</button>
+                </form>
+                {{end}}
+            </td>
+        </tr>
+        {{else}}
+        <tr><td colspan="7">No discount codes yet.</td></tr>
+        {{end}}
+    </table>
+
+    <h2>New Discount Code</h2>
+    <form method="POST" action="/organizer/discounts">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p><label>Code: <input type="text" name="code" value="{{.Draft.Code}}" required pattern="[A-Za-z0-9_-]{3,32}"></label></p>
+        <p>
+            <label><input type="radio" name="kind" value="percent" {{if eq .Draft.Kind "percent"}}checked{{end}}> Percent off</label>
+            <label><input type="radio" name="kind" value="fixed" {{if eq .Draft.Kind "fixed"}}checked{{end}}> Amount off the order</label>
+            <input type="number" name="value" value="{{if .Draft.Value}}{{.Draft.Value}}{{end}}" min="0" step="0.01" required>
+        </p>
+        <p>Events (none for all):<br>
+            {{range .Events}}<label><input type="checkbox" name="event_ids" value="{{.ID}}" {{if has $.Draft.EventIDs .ID}}checked{{end}}> {{.Name}}</label><br>{{end}}
+        </p>
+        {{if .Tiers}}
+        <p>Ticket types (none for all):<br>
+            {{range .Tiers}}<label><input type="checkbox" name="ticket_type_ids" value="{{.ID}}" {{if has $.Draft.TicketTypeIDs .ID}}checked{{end}}> {{eventName .EventID}}: {{.Name}}</label><br>{{end}}
+        </p>
+        {{end}}
+        <p><label>Total uses (0 for unlimited): <input type="number" name="max_uses" value="{{.Draft.MaxUses}}" min="0" required></label></p>
+        <p><label>Uses per customer (0 for unlimited): <input type="number" name="max_uses_per_user" value="{{.Draft.MaxUsesPerUser}}" min="0" required></label></p>
+        <p><label>Valid from (optional): <input type="datetime-local" name="valid_from" value="{{formTime .Draft.ValidFrom}}"></label></p>
+        <p><label>Valid until (optional): <input type="datetime-local" name="valid_until" value="{{formTime .Draft.ValidUntil}}"></label></p>
+        <button type="submit">Create code</button>
+    </form>
+    <p><a href="/organizer/events">Manage events</a></p>
+</body>
+</html>`
+
+	codes, err := store.ListDiscountCodes()
+	if err != nil {
+		http.Error(w, "Failed to load discount codes", http.StatusInternalServerError)
+		return
+	}
+	events, err := store.ListEvents()
+	if err != nil {
+		http.Error(w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+	names := map[int]string{}
+	var tiers []TicketType
+	for _, event := range events {
+		names[event.ID] = event.Name
+		eventTiers, err := store.ListTicketTypes(event.ID)
+		if err != nil {
+			http.Error(w, "Failed to load ticket types", http.StatusInternalServerError)
+			return
+		}
+		tiers = append(tiers, eventTiers...)
+	}
+	tierNames := map[int]string{}
+	for _, tier := range tiers {
+		tierNames[tier.ID] = names[tier.EventID] + ": " + tier.Name
+	}
+
+	funcs := template.FuncMap{
+		"has":       containsID,
+		"eventName": func(id int) string { return names[id] },
+		"formTime": func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.Local().Format(eventFormLayout)
+		},
+		// scope describes which events and tiers a code applies to.
+		"scope": func(d DiscountCode) string {
+			var parts []string
+			for _, id := range d.EventIDs {
+				parts = append(parts, names[id])
+			}
+			for _, id := range d.TicketTypeIDs {
+				parts = append(parts, tierNames[id])
+			}
+			if parts == nil {
+				return "everything"
+			}
+			return strings.Join(parts, ", ")
+		},
+	}
+	if errMessage == "" {
+		errMessage = r.URL.Query().Get("error")
+	}
+
+	t, _ := template.New("discount-codes").Funcs(funcs).Parse(tmpl)
+	data := struct {
+		Codes     []DiscountCode
+		Events    []Event
+		Tiers     []TicketType
+		Draft     DiscountCode
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Codes:     codes,
+		Events:    events,
+		Tiers:     tiers,
+		Draft:     draft,
+		Message:   r.URL.Query().Get("message"),
+		Error:     errMessage,
+		CSRFToken: csrfToken(w, r),
+	}
+	if errMessage != "" && r.Method == "POST" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	t.Execute(w, data)
+}
+
+// parseDiscountCodeForm copies the new discount code form onto d.
+func parseDiscountCodeForm(r *http.Request, d *DiscountCode) (DiscountCode, error) {
+	d.Code = r.FormValue("code")
+	d.Kind = r.FormValue("kind")
+	d.Active = true
+	d.EventIDs = make([]int, 0)
+	d.TicketTypeIDs = make([]int, 0)
+
+	var err error
+	if d.Value, err = strconv.ParseFloat(r.FormValue("value"), 64); err != nil {
+		return DiscountCode{}, validationError("value must be a number")
+	}
+	for _, value := range r.Form["event_ids"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return DiscountCode{}, validationError("invalid event")
+		}
+		d.EventIDs = append(d.EventIDs, id)
+	}
+	for _, value := range r.Form["ticket_type_ids"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return DiscountCode{}, validationError("invalid ticket type")
+		}
+		d.TicketTypeIDs = append(d.TicketTypeIDs, id)
+	}
+	if d.MaxUses, err = strconv.Atoi(r.FormValue("max_uses")); err != nil {
+		return DiscountCode{}, validationError("total uses must be a whole number")
+	}
+	if d.MaxUsesPerUser, err = strconv.Atoi(r.FormValue("max_uses_per_user")); err != nil {
+		return DiscountCode{}, validationError("uses per customer must be a whole number")
+	}
+	if d.ValidFrom, err = parseOptionalFormTime(r.FormValue("valid_from")); err != nil {
+		return DiscountCode{}, validationError("valid from is not a valid date")
+	}
+	if d.ValidUntil, err = parseOptionalFormTime(r.FormValue("valid_until")); err != nil {
+		return DiscountCode{}, validationError("valid until is not a valid date")
+	}
+	return *d, nil
+}
+
+// enableDiscountCodeHandler and disableDiscountCodeHandler put a code back in use or withdraw it.
+func enableDiscountCodeHandler(w http.ResponseWriter, r *http.Request) {
+	changeDiscountCodeActive(w, r, true)
+}
+
+func disableDiscountCodeHandler(w http.ResponseWriter, r *http.Request) {
+	changeDiscountCodeActive(w, r, false)
+}
+
+func changeDiscountCodeActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	d, err := setDiscountCodeActive(id, active)
+	if err != nil {
+		http.Redirect(w, r, "/organizer/discounts?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	state := "Disabled "
+	if active {
+		state = "Enabled "
+	}
+	http.Redirect(w, r, "/organizer/discounts?message="+url.QueryEscape(state+d.Code), http.StatusSeeOther)
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 	"os"
+	"sync"
 )
 
 // EmailConfig holds SMTP server configuration and sender credentials.
@@ -24,8 +29,42 @@ func getEmailConfig() EmailConfig {
 	}
 }
 
+// EmailAttachment is a file sent along with an email.
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// emailsInFlight counts the emails being sent in the background.
+var emailsInFlight sync.WaitGroup
+
+// sendInBackground sends an email without holding up the request that triggered it.
+func sendInBackground(send func()) {
+	emailsInFlight.Add(1)
+	go func() {
+		defer emailsInFlight.Done()
+		send()
+	}()
+}
+
+// sendOrSimulate sends an email, or prints summary in its place if sending fails.
+func sendOrSimulate(recipient, subject, body, summary string, attachments ...EmailAttachment) {
+	err := sendRealEmail(recipient, subject, body, attachments...)
+	if err != nil {
+		fmt.Printf("Failed to send email: %v\n", err)
+		fmt.Println("Falling back to simulation...")
+		fmt.Println(summary)
+		for _, attachment := range attachments {
+			fmt.Printf("  attachment %s (%d bytes)\n", attachment.Filename, len(attachment.Data))
+		}
+	} else {
+		fmt.Printf("Email sent successfully to %s\n", recipient)
+	}
+}
+
 // sendRealEmail sends an email using the SMTP configuration.
-func sendRealEmail(recipientEmail, subject, body string) error {
+func sendRealEmail(recipientEmail, subject, body string, attachments ...EmailAttachment) error {
 	config := getEmailConfig()
 
 	if config.SenderEmail == "" || config.SenderPass == "" {
@@ -40,12 +79,54 @@ func sendRealEmail(recipientEmail, subject, body string) error {
 
 	// Compose the message.
 	message := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", recipientEmail, subject, body))
+	if len(attachments) > 0 {
+		var err error
+		if message, err = composeMultipartEmail(recipientEmail, subject, body, attachments); err != nil {
+			return err
+		}
+	}
 
 	// Send the email.
 	err := smtp.SendMail(config.SMTPHost+":"+config.SMTPPort, auth, config.SenderEmail, []string{recipientEmail}, message)
 	return err
 }
 
+// composeMultipartEmail builds a MIME message with body as its text part, followed by the attachments.
+func composeMultipartEmail(recipientEmail, subject, body string, attachments []EmailAttachment) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "To: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		recipientEmail, subject, writer.Boundary())
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(part, "%s\r\n", body)
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Base64 in lines of 76 characters, as MIME requires.
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // ticketConfirmationTemplate is the email template for ticket confirmations.
 const ticketConfirmationTemplate = `Dear %s %s,
 
@@ -68,22 +149,25 @@ type TicketConfirmationParams struct {
 	FirstName   string
 	LastName    string
 	Email       string
+	BookingID   int // when set, the email lists the booking's tickets and attaches their QR codes
 }
 
 // sendTicketConfirmation sends a ticket confirmation email, or simulates sending if it fails.
 func sendTicketConfirmation(params TicketConfirmationParams) {
 	subject := "Ticket Confirmation - Scrabble National Championship"
 	body := fmt.Sprintf(ticketConfirmationTemplate, params.FirstName, params.LastName, params.UserTickets, params.Email)
-
-	err := sendRealEmail(params.Email, subject, body)
-	if err != nil {
-		fmt.Printf("Failed to send email: %v\n", err)
-		fmt.Println("Falling back to simulation...")
-		// Fall back to the original simulation.
-		fmt.Printf("Sending ticket confirmation to %s %s at %s for %d tickets.\n", params.FirstName, params.LastName, params.Email, params.UserTickets)
-	} else {
-		fmt.Printf("Email sent successfully to %s\n", params.Email)
+	var attachments []EmailAttachment
+	if params.BookingID != 0 {
+		ticketSubject, ticketBody, ticketAttachments, err := bookingTicketsEmail(params.BookingID)
+		if err != nil {
+			fmt.Printf("Could not attach the tickets of booking %d: %v\n", params.BookingID, err)
+		} else {
+			subject, body, attachments = ticketSubject, ticketBody, ticketAttachments
+		}
 	}
+
+	sendOrSimulate(params.Email, subject, body, fmt.Sprintf("Sending ticket confirmation to %s %s at %s for %d tickets.",
+		params.FirstName, params.LastName, params.Email, params.UserTickets), attachments...)
 }
 
 // main function to demonstrate sending a ticket confirmation email.
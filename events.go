@@ -1,7 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,33 +21,78 @@ type Event struct {
 	TotalTickets     int       `json:"total_tickets"`
 	RemainingTickets int       `json:"remaining_tickets"`
 	TicketPrice      float64   `json:"ticket_price"`
+	Currency         string    `json:"currency"` // ISO 4217 code in lower case, e.g. "usd"
 	Active           bool      `json:"active"`
 }
 
 type EventBooking struct {
-	ID              int       `json:"id"`
-	EventID         int       `json:"event_id"`
-	UserID          int       `json:"user_id"`
-	FirstName       string    `json:"first_name"`
-	LastName        string    `json:"last_name"`
-	Email           string    `json:"email"`
-	NumberOfTickets int       `json:"number_of_tickets"`
-	TotalAmount     float64   `json:"total_amount"`
-	BookingDate     time.Time `json:"booking_date"`
-	Status          string    `json:"status"` // pending, confirmed, cancelled
-}
-
-var events = make(map[int]Event)
-var eventBookings = make([]EventBooking, 0)
-var nextEventID = 1
-var nextBookingID = 1
-
-/* Removed duplicate initializeEvents function to resolve redeclaration error.
-   The implementation should exist in only one file in the package. */
-
-func createEvent(name, description, location string, date time.Time, totalTickets int, ticketPrice float64) Event {
+	ID              int        `json:"id"`
+	EventID         int        `json:"event_id"`
+	UserID          int        `json:"user_id"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Email           string     `json:"email"`
+	NumberOfTickets int        `json:"number_of_tickets"`
+	OriginalAmount  float64    `json:"original_amount"` // before any discount
+	DiscountAmount  float64    `json:"discount_amount"`
+	TotalAmount     float64    `json:"total_amount"` // what the customer pays
+	DiscountCodeID  int        `json:"discount_code_id,omitempty"`
+	DiscountCode    string     `json:"discount_code,omitempty"`
+	BookingDate     time.Time  `json:"booking_date"`
+	Status          string     `json:"status"` // pending, confirmed, cancelled, expired, failed, refunded
+	PaymentIntentID string     `json:"payment_intent_id,omitempty"`
+	HoldExpires     *time.Time `json:"hold_expires,omitempty"` // set while a pending booking holds tickets
+	// AwaitingVerification marks a free booking held until its user verifies their email address.
+	AwaitingVerification bool          `json:"awaiting_verification,omitempty"`
+	Items                []BookingItem `json:"items,omitempty"` // tickets per tier; empty for standard admission
+	Seats                []Seat        `json:"seats,omitempty"` // one per ticket at events with assigned seating
+	Tickets              []Ticket      `json:"tickets,omitempty"`
+}
+
+// HoldsTickets reports whether the booking's tickets are taken off its event.
+func (b EventBooking) HoldsTickets() bool {
+	return b.Status == "pending" || b.Status == "confirmed"
+}
+
+// AwaitsPayment reports whether the booking is a hold that is confirmed once it is paid for.
+func (b EventBooking) AwaitsPayment() bool {
+	return b.Status == "pending" && b.TotalAmount > 0
+}
+
+// defaultCurrency is the currency new events are priced in.
+const defaultCurrency = "usd"
+
+// defaultEventID is the event that the single-event CLI and web modes book against.
+const defaultEventID = 1
+
+// initializeEvents makes sure the default event exists in the store.
+func initializeEvents() error {
+	_, err := store.GetEvent(defaultEventID)
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	event, err := createEvent(eventName, "The annual national Scrabble tournament.", "National Convention Centre",
+		time.Now().AddDate(0, 1, 0), eventTickets, 50.00)
+	if err != nil {
+		return err
+	}
+
+	// Bookings migrated from before events existed already belong to this event.
+	existing, err := store.ListBookings()
+	if err != nil {
+		return err
+	}
+	for _, booking := range existing {
+		if booking.EventID == event.ID && booking.HoldsTickets() {
+			event.RemainingTickets -= booking.NumberOfTickets
+		}
+	}
+	return store.UpdateEvent(event)
+}
+
+func createEvent(name, description, location string, date time.Time, totalTickets int, ticketPrice float64) (Event, error) {
 	event := Event{
-		ID:               nextEventID,
 		Name:             name,
 		Description:      description,
 		Date:             date,
@@ -48,54 +100,388 @@ func createEvent(name, description, location string, date time.Time, totalTicket
 		TotalTickets:     totalTickets,
 		RemainingTickets: totalTickets,
 		TicketPrice:      ticketPrice,
+		Currency:         defaultCurrency,
 		Active:           true,
 	}
 
-	events[nextEventID] = event
-	nextEventID++
-	return event
+	return store.CreateEvent(event)
 }
 
-func bookEventTicket(eventID, userID int, firstName, lastName, email string, numberOfTickets int) (*EventBooking, error) {
-	event, exists := events[eventID]
-	if !exists {
-		return nil, fmt.Errorf("event not found")
+// validationError is a problem with user input, reported back to the user as is.
+type validationError string
+
+func (e validationError) Error() string { return string(e) }
+
+// errDefaultEventRequired stops the event the single-event pages book against from being deleted.
+var errDefaultEventRequired = errors.New("the default event cannot be deleted")
+
+// validateEvent checks an event before it is saved. previous is the stored event when editing, or nil
+// when creating; an edit may keep a date that has since passed but not move an event into the past.
+func validateEvent(event Event, previous *Event) error {
+	switch {
+	case event.Name == "" || len(event.Name) > 200:
+		return validationError("name must be between 1 and 200 characters")
+	case event.Date.IsZero():
+		return validationError("date is required")
+	case (previous == nil || !event.Date.Equal(previous.Date)) && !event.Date.After(time.Now()):
+		return validationError("date must be in the future")
+	case event.TotalTickets <= 0:
+		return validationError("total tickets must be at least 1")
+	case event.TicketPrice < 0:
+		return validationError("ticket price cannot be negative")
+	case len(event.Currency) != 3:
+		return validationError("currency must be a three-letter ISO 4217 code")
+	}
+	return nil
+}
+
+// addEvent validates and stores a new event with all of its tickets available.
+func addEvent(event Event) (Event, error) {
+	event.Name = strings.TrimSpace(event.Name)
+	event.Currency = strings.ToLower(event.Currency)
+	if err := validateEvent(event, nil); err != nil {
+		return Event{}, err
+	}
+	event.RemainingTickets = event.TotalTickets
+	return store.CreateEvent(event)
+}
+
+// editEvent validates and saves changes to an existing event, keeping the tickets already booked.
+func editEvent(event Event) (Event, error) {
+	previous, err := store.GetEvent(event.ID)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Name = strings.TrimSpace(event.Name)
+	event.Currency = strings.ToLower(event.Currency)
+	if err := validateEvent(event, &previous); err != nil {
+		return Event{}, err
+	}
+	seats, err := store.ListSeats(event.ID)
+	if err != nil {
+		return Event{}, err
+	}
+	if len(seats) > 0 && event.TotalTickets != len(seats) {
+		return Event{}, validationError(fmt.Sprintf("this event is seated; its capacity is its %d seats", len(seats)))
+	}
+
+	updated, err := store.ReviseEvent(event)
+	if err != nil {
+		return Event{}, err
+	}
+	reloadDefaultEvent(updated.ID)
+	offerFreedTickets(updated.ID)
+	return updated, nil
+}
+
+// setEventActive publishes or unpublishes an event.
+func setEventActive(id int, active bool) (Event, error) {
+	event, err := store.GetEvent(id)
+	if err != nil {
+		return Event{}, err
+	}
+	event.Active = active
+	updated, err := store.ReviseEvent(event)
+	if err != nil {
+		return Event{}, err
+	}
+	reloadDefaultEvent(id)
+	if active {
+		offerFreedTickets(id)
+	}
+	return updated, nil
+}
+
+// deleteEvent removes an event that nobody has booked.
+func deleteEvent(id int) error {
+	if id == defaultEventID {
+		return errDefaultEventRequired
+	}
+	return store.DeleteEvent(id)
+}
+
+// draftBooking prices the tickets in orders for userID, in the chosen seats if any and less any
+// discount code, as a booking ready to be reserved.
+func draftBooking(eventID, userID int, firstName, lastName, email string, orders []TicketOrder, seatIDs []int,
+	discountCode string) (Event, EventBooking, error) {
+	event, err := store.GetEvent(eventID)
+	if errors.Is(err, errNotFound) {
+		return Event{}, EventBooking{}, fmt.Errorf("event not found")
+	}
+	if err != nil {
+		return Event{}, EventBooking{}, err
 	}
 
 	if !event.Active {
-		return nil, fmt.Errorf("event is not active")
+		return Event{}, EventBooking{}, errEventInactive
 	}
 
-	if numberOfTickets > event.RemainingTickets {
-		return nil, fmt.Errorf("not enough tickets available")
+	booking, err := priceBooking(event, orders, discountCode, userID)
+	if err != nil {
+		return Event{}, EventBooking{}, err
+	}
+	if booking.NumberOfTickets > event.RemainingTickets {
+		return Event{}, EventBooking{}, errSoldOut
+	}
+	if err := chooseSeats(event, &booking, seatIDs); err != nil {
+		return Event{}, EventBooking{}, err
 	}
 
-	// Update event tickets
-	event.RemainingTickets -= numberOfTickets
-	events[eventID] = event
+	booking.FirstName = firstName
+	booking.LastName = lastName
+	booking.Email = email
+	booking.BookingDate = time.Now()
+	return event, booking, nil
+}
+
+// bookEventTicket books the tickets in orders for userID, in the chosen seats if any and less any
+// discount code. Free bookings are confirmed at once, or held until the email is verified; paid
+// ones are held for checkout and confirmed by the payment.
+func bookEventTicket(eventID, userID int, firstName, lastName, email string, orders []TicketOrder, seatIDs []int,
+	discountCode string) (*EventBooking, error) {
+	event, booking, err := draftBooking(eventID, userID, firstName, lastName, email, orders, seatIDs, discountCode)
+	if err != nil {
+		return nil, err
+	}
+	if bookingAmountCents(event, booking) > 0 {
+		if awaitingVerification(userID) {
+			return nil, errVerifyToPay
+		}
+		return holdBooking(booking)
+	}
 
-	// Create booking
-	booking := EventBooking{
-		ID:              nextBookingID,
-		EventID:         eventID,
-		UserID:          userID,
-		FirstName:       firstName,
-		LastName:        lastName,
-		Email:           email,
-		NumberOfTickets: numberOfTickets,
-		TotalAmount:     float64(numberOfTickets) * event.TicketPrice,
-		BookingDate:     time.Now(),
-		Status:          "confirmed",
+	if awaitingVerification(userID) {
+		// Held like a checkout until the account's email is verified, which confirms it.
+		booking.AwaitingVerification = true
+		return holdBooking(booking)
 	}
+	booking.Status = "confirmed"
 
-	eventBookings = append(eventBookings, booking)
-	nextBookingID++
+	// Reserve the tickets and create the booking in one step; the store re-checks
+	// availability so concurrent bookings cannot oversell the event.
+	booking, err = store.ReserveTickets(booking)
+	if err != nil {
+		return nil, err
+	}
+	if booking.Status == "confirmed" {
+		sendInBackground(func() { sendTicketConfirmation(bookingConfirmation(booking)) })
+	}
 
 	return &booking, nil
 }
 
-/* Removed duplicate eventsListHandler to resolve redeclaration error.
-   The implementation should exist in only one file in the package. */
+func eventsListHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Events</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+        .event { border: 1px solid #ddd; padding: 15px; margin-bottom: 15px; border-radius: 5px; }
+        input { padding: 8px; margin-bottom: 10px; }
+        button { background-color: #4CAF50; color: white; padding: 10px 20px; border: none; cursor: pointer; }
+        .error { color: red; }
+        .success { color: green; }
+        .stage { text-align: center; background: #eee; padding: 4px; }
+        .seat { display: inline-block; width: 34px; font-size: 11px; text-align: center; }
+        .seat input { margin: 0; padding: 0; }
+        .seat.accessible { outline: 2px solid #06c; }
+        .row-label { display: inline-block; width: 30px; }
+    </style>
+</head>
+<body>
+    <h1>Events</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    {{range .Events}}
+    <div class="event">
+        <h2>{{.Name}}</h2>
+        <p>{{.Description}}</p>
+        <p><strong>When:</strong> {{.Date.Format "Jan 2, 2006 15:04"}} | <strong>Where:</strong> {{.Location}}</p>
+        {{if .Tiers}}
+        <p><strong>Remaining:</strong> {{.RemainingTickets}} of {{.TotalTickets}}</p>
+        {{else}}
+        <p><strong>Price:</strong> {{printf "%.2f" .TicketPrice}} {{.Currency}} | <strong>Remaining:</strong> {{.RemainingTickets}} of {{.TotalTickets}}</p>
+        {{end}}
+        <p><a href="/payment?event_id={{.ID}}">Pay by card</a></p>
+        {{if eq .RemainingTickets 0}}
+        <p><strong>Sold out.</strong> <a href="/events/{{.ID}}/waitlist">Join the waitlist</a> and we will email you if tickets come back.</p>
+        {{else}}
+        <p>Need more tickets than are left? <a href="/events/{{.ID}}/waitlist">Join the waitlist</a>.</p>
+        {{end}}
+        <form method="POST" action="/book-event/{{.ID}}">
+            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+            <input type="text" name="firstName" placeholder="First name" required>
+            <input type="text" name="lastName" placeholder="Last name" required>
+            <input type="email" name="email" placeholder="Email" required>
+            {{if .Tiers}}
+            {{$currency := .Currency}}
+            {{range .Tiers}}
+            <label>{{.Name}} ({{printf "%.2f" .Price}} {{$currency}}, {{.Remaining}} left):
+                <input type="number" name="quantity_{{.ID}}" min="0" max="{{if .MaxPerOrder}}{{.MaxPerOrder}}{{else}}{{.Remaining}}{{end}}" value="0">
+            </label>
+            {{end}}
+            {{else}}
+            <input type="number" name="tickets" min="1" max="{{.RemainingTickets}}" required>
+            {{end}}
+            {{if .Sections}}
+            <fieldset class="seats">
+                <legend>Seats (one per ticket; leave empty for best available; blue outline = accessible)</legend>
+                <p class="stage">Stage</p>
+                {{range .Sections}}
+                <p><strong>{{.Name}}</strong></p>
+                {{range .Rows}}
+                <div><span class="row-label">{{.Name}}</span>{{range .Seats}}<label class="seat{{if .Accessible}} accessible{{end}}" title="{{.Label}}"><input type="checkbox" name="seat_ids" value="{{.ID}}"{{if .Taken}} disabled{{end}}><br>{{.Number}}</label>{{end}}</div>
+                {{end}}
+                {{end}}
+            </fieldset>
+            {{end}}
+            <input type="text" name="discount_code" placeholder="Discount code">
+            <button type="submit">Book</button>
+        </form>
+    </div>
+    {{else}}
+    <p>No events available.</p>
+    {{end}}
+
+    <p><a href="/waitlist">Your waitlists</a> | <a href="/">Back to Booking</a></p>
+    <script>
+    // Picking seats for an event without tiers sets the number of tickets to match.
+    document.querySelectorAll('fieldset.seats').forEach(function(fieldset) {
+        var tickets = fieldset.form.querySelector('input[name="tickets"]');
+        if (!tickets) return;
+        fieldset.addEventListener('change', function() {
+            var picked = fieldset.querySelectorAll('input[name="seat_ids"]:checked').length;
+            if (picked > 0) tickets.value = picked;
+        });
+    });
+    </script>
+</body>
+</html>`
+
+	list, err := store.ListEvents()
+	if err != nil {
+		http.Error(w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	// eventListing is an event with the ticket tiers on sale for it and its seat map, if seated.
+	type eventListing struct {
+		Event
+		Tiers    []TicketType
+		Sections []seatSection
+	}
+	active := make([]eventListing, 0, len(list))
+	for _, event := range list {
+		if !event.Active {
+			continue
+		}
+		tiers, err := onSaleTicketTypes(event.ID)
+		if err != nil {
+			http.Error(w, "Failed to load events", http.StatusInternalServerError)
+			return
+		}
+		seats, err := store.ListSeats(event.ID)
+		if err != nil {
+			http.Error(w, "Failed to load events", http.StatusInternalServerError)
+			return
+		}
+		active = append(active, eventListing{Event: event, Tiers: tiers, Sections: seatSections(seats)})
+	}
+
+	t, _ := template.New("events").Parse(tmpl)
+	data := struct {
+		Events    []eventListing
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Events:    active,
+		Message:   r.URL.Query().Get("message"),
+		Error:     r.URL.Query().Get("error"),
+		CSRFToken: csrfToken(w, r),
+	}
+	t.Execute(w, data)
+}
+
+func bookEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/events", http.StatusSeeOther)
+		return
+	}
+
+	eventID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/book-event/"))
+	if err != nil {
+		http.Redirect(w, r, "/events?error=Invalid+event", http.StatusSeeOther)
+		return
+	}
+
+	orders, err := formTicketOrders(r)
+	if err != nil {
+		http.Redirect(w, r, "/events?error=Invalid+ticket+number", http.StatusSeeOther)
+		return
+	}
+
+	seatIDs, err := formSeatIDs(r)
+	if err != nil {
+		http.Redirect(w, r, "/events?error=Invalid+seat", http.StatusSeeOther)
+		return
+	}
+
+	user, _ := currentUser(r)
+	booking, err := bookEventTicket(eventID, user.ID, r.FormValue("firstName"), r.FormValue("lastName"), r.FormValue("email"), orders,
+		seatIDs, r.FormValue("discount_code"))
+	if errors.Is(err, errSoldOut) && len(seatIDs) == 0 {
+		http.Redirect(w, r, "/events/"+strconv.Itoa(eventID)+"/waitlist?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		http.Redirect(w, r, "/events?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	if booking.AwaitsPayment() {
+		http.Redirect(w, r, checkoutURL(*booking), http.StatusSeeOther)
+		return
+	}
+	if booking.Status == "pending" {
+		http.Redirect(w, r, "/events?message="+url.QueryEscape(verifyToConfirmMessage), http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/events?message=Booking+successful!", http.StatusSeeOther)
+}
+
+// formTicketOrders reads the booking form: a quantity_<ticket type ID> field per tier for events with
+// ticket types, or a plain tickets count.
+func formTicketOrders(r *http.Request) ([]TicketOrder, error) {
+	r.ParseForm()
+	var orders []TicketOrder
+	for name, values := range r.PostForm {
+		id, isTier := strings.CutPrefix(name, "quantity_")
+		if !isTier {
+			continue
+		}
+		tierID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, err
+		}
+		quantity, err := strconv.Atoi(values[0])
+		if err != nil || quantity < 0 {
+			return nil, errors.New("invalid quantity")
+		}
+		orders = append(orders, TicketOrder{TicketTypeID: tierID, Quantity: quantity})
+	}
+	if orders != nil {
+		sort.Slice(orders, func(i, j int) bool { return orders[i].TicketTypeID < orders[j].TicketTypeID })
+		return orders, nil
+	}
 
-/* Removed duplicate bookEventHandler to resolve redeclaration error.
-   The implementation should exist in only one file in the package. */
+	tickets, err := strconv.Atoi(r.FormValue("tickets"))
+	if err != nil || tickets <= 0 {
+		return nil, errors.New("invalid ticket number")
+	}
+	return ticketOrders(tickets), nil
+}
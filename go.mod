@@ -5,4 +5,5 @@ go 1.24.4
 require (
 	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/stripe/stripe-go/v72 v72.122.0
+	golang.org/x/crypto v0.45.0
 )
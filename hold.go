@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultHoldTTL is how long checkout keeps tickets aside when SEAT_HOLD_TTL is not set.
+const defaultHoldTTL = 15 * time.Minute
+
+// getHoldTTL retrieves the seat hold duration from the SEAT_HOLD_TTL environment variable (e.g. "10m").
+func getHoldTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("SEAT_HOLD_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultHoldTTL
+	}
+	return ttl
+}
+
+// placeHold sets tickets, and the chosen seats if any, aside for a checkout as a pending booking that
+// expires after the hold TTL.
+func placeHold(eventID, userID int, firstName, lastName, email string, orders []TicketOrder, seatIDs []int,
+	discountCode string) (*EventBooking, error) {
+	_, booking, err := draftBooking(eventID, userID, firstName, lastName, email, orders, seatIDs, discountCode)
+	if err != nil {
+		return nil, err
+	}
+	return holdBooking(booking)
+}
+
+// holdBooking reserves a drafted booking's tickets as a pending hold that expires after the hold TTL
+// unless it is paid for.
+func holdBooking(booking EventBooking) (*EventBooking, error) {
+	expires := time.Now().UTC().Add(getHoldTTL())
+	booking.Status = "pending"
+	booking.HoldExpires = &expires
+	booking, err := store.ReserveTickets(booking)
+	if err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// confirmHold marks a held booking as paid and emails its tickets. A hold that already lapsed is
+// re-reserved if the tickets are still available.
+func confirmHold(bookingID int) error {
+	booking, err := store.GetBooking(bookingID)
+	if err != nil {
+		return err
+	}
+	if err := store.ConfirmBooking(bookingID); err != nil {
+		return err
+	}
+	if booking.Status != "confirmed" {
+		sendInBackground(func() { sendTicketConfirmation(bookingConfirmation(booking)) })
+	}
+	return nil
+}
+
+// refundUnconfirmedPayment gives back in full a payment for a hold that can no longer be confirmed,
+// marks the booking refunded and tells the customer.
+func refundUnconfirmedPayment(booking EventBooking, paymentIntentID string) error {
+	pi, err := paymentProvider.GetIntent(paymentIntentID)
+	if err != nil {
+		return err
+	}
+	if err := paymentProvider.Refund(paymentIntentID, pi.Amount); err != nil {
+		return fmt.Errorf("refund failed: %w", err)
+	}
+	if _, err := store.ReleaseBooking(booking.ID, "refunded"); err != nil {
+		// The money is already back with the customer; retrying would refund it twice.
+		fmt.Printf("Booking %d was refunded but could not be marked refunded: %v\n", booking.ID, err)
+	}
+	sendInBackground(func() { sendUnconfirmedPaymentEmail(booking, pi) })
+	return nil
+}
+
+// unconfirmedPaymentEmailTemplate is the email template for payments refunded because the booking
+// could not be confirmed.
+const unconfirmedPaymentEmailTemplate = `Dear %s %s,
+
+Your payment arrived after we stopped holding your tickets, and they are no longer available, so
+your booking could not be confirmed. We have refunded your payment in full.
+
+Booking Details:
+- Booking: #%d
+- Number of Tickets: %d
+- Refund: %.2f %s
+
+Best regards,
+Booking Team
+`
+
+// sendUnconfirmedPaymentEmail tells the customer their payment was refunded, or simulates sending if it fails.
+func sendUnconfirmedPaymentEmail(booking EventBooking, pi PaymentIntent) {
+	subject := fmt.Sprintf("Booking #%d Not Confirmed - Payment Refunded", booking.ID)
+	body := fmt.Sprintf(unconfirmedPaymentEmailTemplate, booking.FirstName, booking.LastName, booking.ID,
+		booking.NumberOfTickets, float64(pi.Amount)/100, strings.ToUpper(pi.Currency))
+
+	sendOrSimulate(booking.Email, subject, body,
+		fmt.Sprintf("Sending refund of unconfirmed booking %d to %s", booking.ID, booking.Email))
+}
+
+// releaseExpiredHolds returns the tickets of every unpaid hold past its expiry.
+func releaseExpiredHolds() (int, error) {
+	expired, err := store.ExpiredHolds(time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	released, reload := 0, false
+	for _, booking := range expired {
+		// A payment may have confirmed the booking since it was listed.
+		if err := store.ReleaseHold(booking.ID, "expired"); err != nil {
+			continue
+		}
+		released++
+		reload = reload || booking.EventID == defaultEventID
+	}
+	if reload {
+		return released, loadBookings()
+	}
+	return released, nil
+}
+
+// startHoldSweeper releases expired holds, retries failed refunds and passes lapsed waitlist offers on in the
+// background every interval.
+func startHoldSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			released, err := releaseExpiredHolds()
+			if err != nil {
+				fmt.Printf("Error releasing expired holds: %v\n", err)
+				continue
+			}
+			if released > 0 {
+				fmt.Printf("Released %d expired seat holds\n", released)
+			}
+			if refunded, err := retryPendingRefunds(); err != nil {
+				fmt.Printf("Error retrying refunds: %v\n", err)
+			} else if refunded > 0 {
+				fmt.Printf("Issued %d refunds that had failed\n", refunded)
+			}
+			// Lapsed holds and waitlist offers free tickets for the next customers in line.
+			if err := processWaitlists(); err != nil {
+				fmt.Printf("Error offering tickets to waitlists: %v\n", err)
+			}
+		}
+	}()
+}
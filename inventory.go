@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// errSoldOut is returned when an event has fewer remaining tickets than requested.
+var errSoldOut = errors.New("not enough tickets available")
+
+// errEventInactive is returned when booking an event that has been unpublished.
+var errEventInactive = errors.New("event is not active")
+
+// errCapacityBelowSold is returned when an event's capacity is cut below the tickets already taken.
+var errCapacityBelowSold = errors.New("total tickets cannot be less than tickets already booked")
+
+// errEventHasBookings is returned when deleting an event that has bookings; unpublish it instead.
+var errEventHasBookings = errors.New("events with bookings cannot be deleted, unpublish it instead")
+
+// ticketsMutex guards the in-memory remainingTickets and bookings mirrors of the default event.
+// The store stays the source of truth; the mirrors only feed the single-event pages and CLI.
+var ticketsMutex = sync.Mutex{}
+
+// ticketsLeft reads the default event's remaining tickets from the store, so holds released in the
+// background are counted, falling back to the mirror if the store cannot be read.
+func ticketsLeft() uint {
+	if event, err := store.GetEvent(defaultEventID); err == nil {
+		return uint(event.RemainingTickets)
+	}
+	ticketsMutex.Lock()
+	defer ticketsMutex.Unlock()
+	return remainingTickets
+}
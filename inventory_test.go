@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Environment passed to the booking processes started by TestInventoryAcrossProcesses.
+const (
+	stressDBEnv    = "BOOKING_STRESS_DB"
+	stressEventEnv = "BOOKING_STRESS_EVENT"
+)
+
+// TestInventoryAcrossProcesses starts several processes that each book one event in the same
+// bookings.db from many goroutines until it sells out, and checks that it is never oversold.
+func TestInventoryAcrossProcesses(t *testing.T) {
+	const processes, totalTickets = 4, 200
+
+	path := filepath.Join(t.TempDir(), "bookings.db")
+	setup := openDBAt(path)
+	defer setup.Close()
+	if err := migrateUp(setup); err != nil {
+		t.Fatal(err)
+	}
+	event, err := newSQLiteStore(setup).CreateEvent(Event{
+		Name:             "Stress Test",
+		Date:             time.Now().AddDate(0, 0, 7),
+		TotalTickets:     totalTickets,
+		RemainingTickets: totalTickets,
+		TicketPrice:      10,
+		Currency:         defaultCurrency,
+		Active:           true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := make([]*exec.Cmd, processes)
+	outputs := make([]bytes.Buffer, processes)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^TestInventoryBookingProcess$")
+		cmds[i].Env = append(os.Environ(), stressDBEnv+"="+path, stressEventEnv+"="+strconv.Itoa(event.ID))
+		cmds[i].Stdout = &outputs[i]
+		cmds[i].Stderr = &outputs[i]
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sold := 0
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("booking process %d: %v\n%s", i, err, outputs[i].String())
+		}
+		n, err := soldByProcess(outputs[i].String())
+		if err != nil {
+			t.Fatalf("booking process %d: %v\n%s", i, err, outputs[i].String())
+		}
+		sold += n
+	}
+
+	final, err := newSQLiteStore(setup).GetEvent(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var booked int
+	if err := setup.QueryRow("SELECT COALESCE(SUM(number_of_tickets), 0) FROM bookings WHERE event_id = ?", event.ID).Scan(&booked); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("sold %d of %d tickets, %d remaining, %d booked in the database", sold, totalTickets, final.RemainingTickets, booked)
+
+	if booked > totalTickets || sold != booked || final.RemainingTickets != totalTickets-booked || final.RemainingTickets < 0 {
+		t.Fatalf("inventory mismatch: %d sold, %d booked and %d remaining of %d", sold, booked, final.RemainingTickets, totalTickets)
+	}
+	// Bookings are of one to three tickets, so a sold-out event has fewer than three left.
+	if final.RemainingTickets >= 3 {
+		t.Fatalf("%d tickets left unsold", final.RemainingTickets)
+	}
+}
+
+// TestInventoryBookingProcess is the body of one booking process started by TestInventoryAcrossProcesses;
+// run on its own it does nothing. It books from several goroutines until the event sells out and
+// prints how many tickets it sold.
+func TestInventoryBookingProcess(t *testing.T) {
+	path := os.Getenv(stressDBEnv)
+	if path == "" {
+		return
+	}
+	eventID, err := strconv.Atoi(os.Getenv(stressEventEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := openDBAt(path)
+	defer db.Close()
+	processStore := newSQLiteStore(db)
+
+	var mu sync.Mutex
+	var sold int
+	var failures []error
+	var group sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		group.Add(1)
+		go func(tickets int) {
+			defer group.Done()
+			for {
+				_, err := processStore.ReserveTickets(EventBooking{
+					EventID:         eventID,
+					FirstName:       "Stress",
+					LastName:        "Worker",
+					Email:           "stress@example.com",
+					NumberOfTickets: tickets,
+					BookingDate:     time.Now(),
+					Status:          "confirmed",
+				})
+
+				mu.Lock()
+				switch {
+				case err == nil:
+					sold += tickets
+				case !errors.Is(err, errSoldOut):
+					failures = append(failures, err)
+				}
+				mu.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}(worker%3 + 1)
+	}
+	group.Wait()
+
+	if len(failures) > 0 {
+		t.Fatalf("%d reservations failed unexpectedly, first: %v", len(failures), failures[0])
+	}
+	fmt.Printf("sold %d\n", sold)
+}
+
+// soldByProcess reads the tickets a booking process reported selling.
+func soldByProcess(output string) (int, error) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if n, found := strings.CutPrefix(scanner.Text(), "sold "); found {
+			return strconv.Atoi(n)
+		}
+	}
+	return 0, errors.New("no tickets sold reported")
+}
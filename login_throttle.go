@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+)
+
+// LoginAttempt is one entry in the sign-in audit log.
+type LoginAttempt struct {
+	ID          int
+	Username    string
+	IP          string
+	Success     bool
+	Reason      string // ok, unknown_user, bad_password or throttled
+	AttemptedAt time.Time
+}
+
+const (
+	loginReasonOK          = "ok"
+	loginReasonUnknownUser = "unknown_user"
+	loginReasonBadPassword = "bad_password"
+	loginReasonThrottled   = "throttled"
+)
+
+// countsAsFailure reports whether the attempt counts toward throttling. Refused attempts do not,
+// so hammering a locked account does not keep extending the lockout.
+func (a LoginAttempt) countsAsFailure() bool {
+	return !a.Success && a.Reason != loginReasonThrottled
+}
+
+const (
+	// loginFailureWindow is how long a failed attempt counts toward throttling.
+	loginFailureWindow = 15 * time.Minute
+	// loginFreeAttempts failures are allowed before any delay is imposed.
+	loginFreeAttempts = 3
+	// loginBackoffBase is the first delay; it doubles with every further failure.
+	loginBackoffBase = time.Second
+	// loginLockoutDuration is how long an account or IP stays locked once it hits its limit.
+	loginLockoutDuration = 15 * time.Minute
+	// loginAccountMaxFailures locks an account; loginIPMaxFailures locks an IP trying many accounts.
+	loginAccountMaxFailures = 10
+	loginIPMaxFailures      = 50
+)
+
+// errInvalidCredentials is the single error for an unknown username or a wrong password.
+var errInvalidCredentials = errors.New("invalid username or password")
+
+// errLoginThrottled is returned while an account or IP has to wait before trying again.
+var errLoginThrottled = errors.New("too many failed login attempts, please try again later")
+
+// loginDelay is how long to wait after the latest of failures failed attempts.
+func loginDelay(failures, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return loginLockoutDuration
+	}
+	if failures < loginFreeAttempts {
+		return 0
+	}
+	// Double step by step rather than shifting, which would overflow long before loginIPMaxFailures.
+	delay := loginBackoffBase
+	for i := loginFreeAttempts; i < failures && delay < loginLockoutDuration; i++ {
+		delay *= 2
+	}
+	return min(delay, loginLockoutDuration)
+}
+
+// loginRetryAfter returns how long username at ip must wait before its next attempt is considered.
+func loginRetryAfter(username, ip string, now time.Time) (time.Duration, error) {
+	since := now.Add(-loginFailureWindow)
+
+	accountFailures, accountLatest, err := store.AccountLoginFailures(username, since)
+	if err != nil {
+		return 0, err
+	}
+	ipFailures, ipLatest, err := store.IPLoginFailures(ip, since)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := accountLatest.Add(loginDelay(accountFailures, loginAccountMaxFailures)).Sub(now)
+	if ipWait := ipLatest.Add(loginDelay(ipFailures, loginIPMaxFailures)).Sub(now); ipWait > wait {
+		wait = ipWait
+	}
+	return max(wait, 0), nil
+}
+
+// recordLoginAttempt writes to the audit log, logging failed attempts to stdout as well.
+func recordLoginAttempt(username, ip string, success bool, reason string) {
+	attempt := LoginAttempt{
+		Username:    username,
+		IP:          ip,
+		Success:     success,
+		Reason:      reason,
+		AttemptedAt: time.Now(),
+	}
+	if err := store.RecordLoginAttempt(attempt); err != nil {
+		fmt.Printf("Error recording login attempt: %v\n", err)
+	}
+	if !success {
+		fmt.Printf("Failed login for %q from %s: %s\n", username, ip, reason)
+	}
+}
+
+// clientIP returns the address the request came from. Forwarding headers are not trusted,
+// since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// adminLoginAttemptsHandler shows the most recent sign-in attempts.
+func adminLoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Login Attempts</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 900px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        .failed { color: red; }
+    </style>
+</head>
+<body>
+    <h1>Login Attempts</h1>
+    <table>
+        <tr>
+            <th>Time</th>
+            <th>Username</th>
+            <th>IP</th>
+            <th>Result</th>
+        </tr>
+        {{range .}}
+        <tr>
+            <td>{{.AttemptedAt.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Username}}</td>
+            <td>{{.IP}}</td>
+            <td {{if not .Success}}class="failed"{{end}}>{{.Reason}}</td>
+        </tr>
+        {{end}}
+    </table>
+    <p><a href="/admin/users">Users</a></p>
+</body>
+</html>`
+
+	attempts, err := store.ListLoginAttempts(200)
+	if err != nil {
+		http.Error(w, "Failed to load login attempts", http.StatusInternalServerError)
+		return
+	}
+
+	t, _ := template.New("login-attempts").Parse(tmpl)
+	t.Execute(w, attempts)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// recordTestAttempts records failures failed attempts by username from ip, one a second, the last at latest.
+func recordTestAttempts(t *testing.T, username, ip string, failures int, latest time.Time) {
+	t.Helper()
+	for i := failures - 1; i >= 0; i-- {
+		attempt := LoginAttempt{Username: username, IP: ip, Reason: loginReasonBadPassword, AttemptedAt: latest.Add(-time.Duration(i) * time.Second)}
+		if err := store.RecordLoginAttempt(attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestLoginDelay checks the backoff and lockout thresholds for accounts and IPs.
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures, maxFailures int
+		want                  time.Duration
+	}{
+		{0, loginAccountMaxFailures, 0},
+		{loginFreeAttempts - 1, loginAccountMaxFailures, 0},
+		{loginFreeAttempts, loginAccountMaxFailures, loginBackoffBase},
+		{loginFreeAttempts + 1, loginAccountMaxFailures, 2 * loginBackoffBase},
+		{loginAccountMaxFailures - 1, loginAccountMaxFailures, 64 * loginBackoffBase},
+		{loginAccountMaxFailures, loginAccountMaxFailures, loginLockoutDuration},
+		{loginAccountMaxFailures + 5, loginAccountMaxFailures, loginLockoutDuration},
+		{loginAccountMaxFailures, loginIPMaxFailures, 128 * loginBackoffBase},
+		{loginIPMaxFailures - 1, loginIPMaxFailures, loginLockoutDuration},
+		{loginIPMaxFailures, loginIPMaxFailures, loginLockoutDuration},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures, tt.maxFailures); got != tt.want {
+			t.Errorf("loginDelay(%d, %d) = %v, want %v", tt.failures, tt.maxFailures, got, tt.want)
+		}
+	}
+}
+
+// TestAccountLockout locks an account at loginAccountMaxFailures failures, keeps it locked for
+// loginLockoutDuration, and forgets failures once they fall out of loginFailureWindow.
+func TestAccountLockout(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+		recordTestAttempts(t, "ada", "192.0.2.1", loginAccountMaxFailures-1, now)
+		if wait, err := loginRetryAfter("ada", "192.0.2.2", now); err != nil || wait != 64*loginBackoffBase {
+			t.Fatalf("after %d failures: wait %v (%v), want %v", loginAccountMaxFailures-1, wait, err, 64*loginBackoffBase)
+		}
+
+		recordTestAttempts(t, "ada", "192.0.2.1", 1, now.Add(time.Second))
+		for _, tt := range []struct {
+			at   time.Time
+			want time.Duration
+		}{
+			{now.Add(time.Second), loginLockoutDuration},
+			{now.Add(time.Second + loginLockoutDuration - time.Minute), time.Minute},
+			{now.Add(time.Second + loginLockoutDuration), 0},
+		} {
+			if wait, err := loginRetryAfter("ada", "192.0.2.2", tt.at); err != nil || wait != tt.want {
+				t.Fatalf("at %v: wait %v (%v), want %v", tt.at.Sub(now), wait, err, tt.want)
+			}
+		}
+		if wait, err := loginRetryAfter("grace", "192.0.2.2", now.Add(time.Second)); err != nil || wait != 0 {
+			t.Fatalf("another account: wait %v (%v), want none", wait, err)
+		}
+	})
+}
+
+// TestIPLockout locks an IP that fails against many accounts, without locking those accounts elsewhere.
+func TestIPLockout(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+		for i := 0; i < loginIPMaxFailures; i++ {
+			recordTestAttempts(t, fmt.Sprintf("user%d", i), "192.0.2.1", 1, now.Add(time.Duration(i-loginIPMaxFailures+1)*time.Second))
+		}
+		if wait, err := loginRetryAfter("someone", "192.0.2.1", now); err != nil || wait != loginLockoutDuration {
+			t.Fatalf("locked IP: wait %v (%v), want %v", wait, err, loginLockoutDuration)
+		}
+		if wait, err := loginRetryAfter("user0", "192.0.2.2", now); err != nil || wait != 0 {
+			t.Fatalf("from another IP: wait %v (%v), want none", wait, err)
+		}
+		if wait, err := loginRetryAfter("someone", "192.0.2.1", now.Add(loginLockoutDuration)); err != nil || wait != 0 {
+			t.Fatalf("after the lockout: wait %v (%v), want none", wait, err)
+		}
+	})
+}
+
+// TestLockedAccountRefusesLogin refuses even the right password while the account is locked, without
+// extending the lockout, and a successful login afterwards clears the failures.
+func TestLockedAccountRefusesLogin(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		hash, err := hashPassword("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		createPasswordUser(t, "ada", hash)
+		locked := time.Now().Add(-loginLockoutDuration + time.Minute)
+		recordTestAttempts(t, "ada", "192.0.2.1", loginAccountMaxFailures, locked)
+
+		if _, err := loginUser("ada", "correct horse", "192.0.2.1"); !errors.Is(err, errLoginThrottled) {
+			t.Fatalf("locked account: %v, want errLoginThrottled", err)
+		}
+		wait, err := loginRetryAfter("ada", "192.0.2.1", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait > time.Minute {
+			t.Fatalf("a refused attempt extended the lockout to %v", wait)
+		}
+
+		// Once the lockout has passed, signing in clears the account's failures.
+		if err := store.RecordLoginAttempt(LoginAttempt{Username: "ada", IP: "192.0.2.1", Success: true, Reason: loginReasonOK, AttemptedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if failures, _, err := store.AccountLoginFailures("ada", time.Now().Add(-loginFailureWindow)); err != nil || failures != 0 {
+			t.Fatalf("%d failures (%v) after a successful login, want 0", failures, err)
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 )
@@ -31,12 +32,74 @@ func main() {
 	webMode := flag.Bool("web", false, "Run in web mode")
 	flag.Parse()
 
+	// Print the OpenAPI document, or check the checked-in copy against the handlers
+	if flag.Arg(0) == "openapi" {
+		if flag.Arg(1) == "check" {
+			if err := checkOpenAPISpec(openAPIFile); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%s matches the registered routes\n", openAPIFile)
+			return
+		}
+		spec, err := renderOpenAPISpec()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(spec)
+		return
+	}
+
+	// Schema migrations run against the raw database
+	if flag.Arg(0) == "migrate" {
+		db = openDB()
+		defer db.Close()
+		if err := runMigrateCommand(db, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Select the payment provider (PAYMENT_PROVIDER=stripe|fake)
+	provider, err := newPaymentProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+	paymentProvider = provider
+
+	// Post a locally signed Stripe fixture to a running server
+	if flag.Arg(0) == "send-webhook" {
+		for _, path := range flag.Args()[1:] {
+			if err := sendWebhookFixture("http://localhost:8080/stripe/webhook", path); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
 	// Initialize database
-	db = initDB()
+	db = initializeDB()
 	defer db.Close()
+	store = newSQLiteStore(db)
 
 	// Initialize events for multi-event support
-	initializeEvents()
+	if err := initializeEvents(); err != nil {
+		log.Fatalf("Error initializing events: %v", err)
+	}
+	if err := loadBookings(); err != nil {
+		log.Fatalf("Error loading bookings: %v", err)
+	}
+
+	// Bootstrap the first administrator
+	if flag.Arg(0) == "create-admin" {
+		if flag.NArg() != 4 {
+			log.Fatal("usage: create-admin <username> <email> <password>")
+		}
+		if err := createAdmin(flag.Arg(1), flag.Arg(2), flag.Arg(3)); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s is now an admin\n", flag.Arg(1))
+		return
+	}
 
 	if *webMode {
 		startWebMode()
@@ -46,18 +109,17 @@ func main() {
 }
 
 func startWebMode() {
-	// Set up routes - Remove auth middleware from login/register and add public home
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/book", authMiddleware(bookHandler))
-	http.HandleFunc("/bookings", authMiddleware(bookingsHandler))
-	http.HandleFunc("/events", eventsListHandler)
-	http.HandleFunc("/book-event/", authMiddleware(bookEventHandler))
-	http.HandleFunc("/payment", authMiddleware(paymentPageHandler))
-	http.HandleFunc("/create-payment-intent", authMiddleware(paymentHandler))
-	
-	// Auth routes
-	http.HandleFunc("/login", loginHandler)
-	http.HandleFunc("/register", registerHandler)
+	// Pages, forms and browser checkout endpoints
+	registerWebRoutes()
+
+	// JSON API for mobile and other clients
+	registerAPIRoutes()
+
+	// Give unpaid checkout holds back to their events
+	startHoldSweeper(time.Minute)
+
+	// Drop sessions that have run out
+	startSessionReaper(time.Hour)
 	
 	fmt.Println("Enhanced booking application starting on http://localhost:8080")
 	fmt.Println("Features available:")
@@ -72,18 +134,6 @@ func startWebMode() {
 
 func startCLIMode() {
 	fmt.Println("Starting CLI mode...")
-	
-	// Load existing bookings from database
-	loadedBookings, err := getBookingsFromDB(db)
-	if err != nil {
-		fmt.Printf("Error loading bookings: %v\n", err)
-	} else {
-		bookings = loadedBookings
-		// Update remaining tickets based on loaded bookings
-		for _, booking := range bookings {
-			remainingTickets -= booking.numberOfTickets
-		}
-	}
 
 	greetUsers()
 
@@ -92,11 +142,6 @@ func startCLIMode() {
 	isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(firstName, lastName, email, userTickets, remainingTickets)
 
 	if isValidName && isValidEmail && isValidTicketNumber {
-		fmt.Println("Thank you for your booking!")
-		
-		// Book the ticket
-		bookTicket(userTickets, firstName, lastName, email)
-		
 		// Save to database
 		userData := UserData{
 			firstName:       firstName,
@@ -105,12 +150,17 @@ func startCLIMode() {
 			numberOfTickets: userTickets,
 		}
 		
-		err := saveBookingToDB(db, userData)
+		err := saveBooking(userData)
 		if err != nil {
 			fmt.Printf("Error saving booking to database: %v\n", err)
-		} else {
-			fmt.Println("Booking saved to database successfully!")
+			return
 		}
+		fmt.Println("Booking saved to database successfully!")
+
+		fmt.Println("Thank you for your booking!")
+		
+		// Book the ticket
+		bookTicket(userTickets, firstName, lastName, email)
 
 		wg.Add(1)
 		go sendTicketEnhanced(userTickets, firstName, lastName, email)
@@ -140,8 +190,8 @@ func sendTicketEnhanced(userTickets uint, firstName, lastName, email string) {
 	fmt.Println("###############")
 	fmt.Printf("Sending ticket:\n %v tickets for %v %v\n", userTickets, firstName, lastName)
 	
-	// Try to send real email first, fall back to simulation
-	sendTicketConfirmation(userTickets, firstName, lastName, email)
+	// The confirmation email with the QR code tickets went out when the booking was saved.
+	fmt.Printf("Tickets with QR codes were emailed to %v\n", email)
 	
 	fmt.Println("###############")
 }
@@ -180,6 +230,9 @@ func getUserInput() (string, string, string, uint) {
 }
 
 func bookTicket(userTickets uint, firstName string, lastName string, email string) {
+	ticketsMutex.Lock()
+	defer ticketsMutex.Unlock()
+
 	remainingTickets = remainingTickets - userTickets
 
 	var userData = UserData{
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS bookings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	number_of_tickets INTEGER NOT NULL,
+	booking_date DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+DROP TABLE sessions;
+DROP TABLE users;
+DROP TABLE event_bookings;
+DROP TABLE events;
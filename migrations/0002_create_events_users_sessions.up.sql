@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	date DATETIME NOT NULL,
+	location TEXT NOT NULL DEFAULT '',
+	total_tickets INTEGER NOT NULL,
+	remaining_tickets INTEGER NOT NULL,
+	ticket_price REAL NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS event_bookings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL REFERENCES events(id),
+	user_id INTEGER NOT NULL DEFAULT 0,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	number_of_tickets INTEGER NOT NULL,
+	total_amount REAL NOT NULL,
+	booking_date DATETIME NOT NULL,
+	status TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	email TEXT NOT NULL,
+	password TEXT NOT NULL,
+	created DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	expires DATETIME NOT NULL
+);
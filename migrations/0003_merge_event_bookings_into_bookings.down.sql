@@ -0,0 +1,26 @@
+DROP INDEX idx_bookings_event_id;
+
+CREATE TABLE event_bookings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL REFERENCES events(id),
+	user_id INTEGER NOT NULL DEFAULT 0,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	number_of_tickets INTEGER NOT NULL,
+	total_amount REAL NOT NULL,
+	booking_date DATETIME NOT NULL,
+	status TEXT NOT NULL
+);
+
+INSERT INTO event_bookings (id, event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status)
+SELECT id, event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status
+FROM bookings
+ORDER BY id;
+
+DELETE FROM bookings;
+
+ALTER TABLE bookings DROP COLUMN status;
+ALTER TABLE bookings DROP COLUMN total_amount;
+ALTER TABLE bookings DROP COLUMN user_id;
+ALTER TABLE bookings DROP COLUMN event_id;
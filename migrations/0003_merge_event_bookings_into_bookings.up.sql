@@ -0,0 +1,25 @@
+-- Give the original bookings table the EventBooking columns. Rows written
+-- before events existed belong to the default event (id 1).
+ALTER TABLE bookings ADD COLUMN event_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE bookings ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN total_amount REAL NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN status TEXT NOT NULL DEFAULT 'confirmed';
+
+UPDATE bookings
+SET total_amount = number_of_tickets * COALESCE((SELECT ticket_price FROM events WHERE events.id = bookings.event_id), 0);
+
+INSERT INTO bookings (event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status)
+SELECT event_id, user_id, first_name, last_name, email, number_of_tickets, total_amount, booking_date, status
+FROM event_bookings
+ORDER BY id;
+
+DROP TABLE event_bookings;
+
+-- Legacy rows never touched an event's inventory, so recount it.
+UPDATE events
+SET remaining_tickets = total_tickets - (
+	SELECT COALESCE(SUM(number_of_tickets), 0) FROM bookings
+	WHERE bookings.event_id = events.id AND bookings.status != 'cancelled'
+);
+
+CREATE INDEX idx_bookings_event_id ON bookings(event_id);
@@ -0,0 +1 @@
+DROP TRIGGER events_inventory_bounds;
@@ -0,0 +1,8 @@
+-- Last line of defence against overselling: no write may leave an event
+-- with negative or more-than-total remaining tickets.
+CREATE TRIGGER events_inventory_bounds
+BEFORE UPDATE OF remaining_tickets, total_tickets ON events
+WHEN NEW.remaining_tickets < 0 OR NEW.remaining_tickets > NEW.total_tickets
+BEGIN
+	SELECT RAISE(ABORT, 'event inventory out of bounds');
+END;
@@ -0,0 +1,5 @@
+DROP INDEX idx_bookings_payment_intent_id;
+DROP INDEX idx_bookings_status_hold_expires;
+
+ALTER TABLE bookings DROP COLUMN hold_expires;
+ALTER TABLE bookings DROP COLUMN payment_intent_id;
@@ -0,0 +1,6 @@
+-- Checkout places a pending booking that holds its tickets until hold_expires.
+ALTER TABLE bookings ADD COLUMN payment_intent_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE bookings ADD COLUMN hold_expires DATETIME;
+
+CREATE INDEX idx_bookings_status_hold_expires ON bookings(status, hold_expires);
+CREATE INDEX idx_bookings_payment_intent_id ON bookings(payment_intent_id);
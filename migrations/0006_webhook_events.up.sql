@@ -0,0 +1,6 @@
+-- Stripe delivers webhooks at least once; remember which events were handled.
+CREATE TABLE webhook_events (
+	event_id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	processed_at DATETIME NOT NULL
+);
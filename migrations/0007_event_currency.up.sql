@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN currency TEXT NOT NULL DEFAULT 'usd';
@@ -0,0 +1,3 @@
+-- Sessions are looked up by user for "log out all devices" and swept by expiry.
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires);
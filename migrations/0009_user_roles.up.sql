@@ -0,0 +1,2 @@
+-- Every existing account becomes a customer; admins are bootstrapped with "create-admin".
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'customer';
@@ -0,0 +1,12 @@
+-- Audit log of sign-in attempts; recent failures drive login throttling and lockout.
+CREATE TABLE login_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	success BOOLEAN NOT NULL,
+	reason TEXT NOT NULL,
+	attempted_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_login_attempts_username ON login_attempts(username, attempted_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts(ip, attempted_at);
@@ -0,0 +1,2 @@
+ALTER TABLE bookings DROP COLUMN awaiting_verification;
+ALTER TABLE users DROP COLUMN email_verified;
@@ -0,0 +1,7 @@
+-- New accounts must verify their email; accounts created before verification existed are trusted.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT 0;
+UPDATE users SET email_verified = 1;
+
+-- Free bookings by unverified accounts are held until the email is verified; only those are
+-- confirmed by verifying it.
+ALTER TABLE bookings ADD COLUMN awaiting_verification BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+-- User-scoped API keys for machine clients. Only a SHA-256 hash of each key is kept.
+CREATE TABLE api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created DATETIME NOT NULL,
+	last_used DATETIME,
+	revoked DATETIME
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys(user_id);
@@ -0,0 +1,30 @@
+-- Ticket tiers with their own price, quota and sale window. Tickets of every tier also count
+-- against the event's remaining_tickets.
+CREATE TABLE ticket_types (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL REFERENCES events(id),
+	name TEXT NOT NULL,
+	price REAL NOT NULL,
+	quota INTEGER NOT NULL CHECK (quota > 0),
+	remaining INTEGER NOT NULL CHECK (remaining >= 0 AND remaining <= quota),
+	sales_start DATETIME,
+	sales_end DATETIME,
+	min_per_order INTEGER NOT NULL DEFAULT 1,
+	max_per_order INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_ticket_types_event ON ticket_types(event_id);
+
+-- The tiers booked in each booking, at the price they were sold for. Bookings without items are
+-- standard admission at the event's ticket_price.
+CREATE TABLE booking_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	booking_id INTEGER NOT NULL REFERENCES bookings(id),
+	ticket_type_id INTEGER NOT NULL REFERENCES ticket_types(id),
+	name TEXT NOT NULL,
+	quantity INTEGER NOT NULL CHECK (quantity > 0),
+	unit_price REAL NOT NULL
+);
+
+CREATE INDEX idx_booking_items_booking ON booking_items(booking_id);
+CREATE INDEX idx_booking_items_ticket_type ON booking_items(ticket_type_id);
@@ -0,0 +1,8 @@
+DROP INDEX idx_bookings_discount_code;
+
+ALTER TABLE bookings DROP COLUMN discount_code;
+ALTER TABLE bookings DROP COLUMN discount_code_id;
+ALTER TABLE bookings DROP COLUMN discount_amount;
+ALTER TABLE bookings DROP COLUMN original_amount;
+
+DROP TABLE discount_codes;
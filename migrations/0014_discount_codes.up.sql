@@ -0,0 +1,25 @@
+-- Promo codes taking a percentage or a fixed amount off an order. Scopes are space-separated IDs;
+-- an empty scope applies to everything.
+CREATE TABLE discount_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code TEXT NOT NULL UNIQUE,
+	kind TEXT NOT NULL CHECK (kind IN ('percent', 'fixed')),
+	value REAL NOT NULL,
+	event_ids TEXT NOT NULL DEFAULT '',
+	ticket_type_ids TEXT NOT NULL DEFAULT '',
+	max_uses INTEGER NOT NULL DEFAULT 0,
+	max_uses_per_user INTEGER NOT NULL DEFAULT 0,
+	valid_from DATETIME,
+	valid_until DATETIME,
+	active INTEGER NOT NULL DEFAULT 1,
+	created DATETIME NOT NULL
+);
+
+-- Bookings keep the price before the discount; total_amount stays what the customer pays.
+ALTER TABLE bookings ADD COLUMN original_amount REAL NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN discount_amount REAL NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN discount_code_id INTEGER;
+ALTER TABLE bookings ADD COLUMN discount_code TEXT NOT NULL DEFAULT '';
+UPDATE bookings SET original_amount = total_amount;
+
+CREATE INDEX idx_bookings_discount_code ON bookings(discount_code_id, user_id);
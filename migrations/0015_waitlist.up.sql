@@ -0,0 +1,19 @@
+-- Customers waiting for an event to free up tickets, in the order they joined. An offer holds the
+-- tickets as a pending booking until offer_expires.
+CREATE TABLE waitlist_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL REFERENCES events(id),
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	ticket_type_id INTEGER NOT NULL DEFAULT 0,
+	tickets INTEGER NOT NULL CHECK (tickets > 0),
+	status TEXT NOT NULL CHECK (status IN ('waiting', 'offered', 'claimed', 'expired', 'left')),
+	booking_id INTEGER,
+	offer_expires DATETIME,
+	created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_waitlist_entries_event ON waitlist_entries(event_id, status);
+CREATE INDEX idx_waitlist_entries_user ON waitlist_entries(user_id);
@@ -0,0 +1,2 @@
+DROP TABLE booking_seats;
+DROP TABLE seats;
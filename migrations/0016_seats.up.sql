@@ -0,0 +1,21 @@
+-- Numbered seats of events with assigned seating, in the order they were laid out. booking_id is the
+-- booking holding the seat now; a seat can only ever hold one.
+CREATE TABLE seats (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id INTEGER NOT NULL REFERENCES events(id),
+	section TEXT NOT NULL,
+	row_label TEXT NOT NULL,
+	number INTEGER NOT NULL,
+	accessible INTEGER NOT NULL DEFAULT 0,
+	booking_id INTEGER REFERENCES bookings(id),
+	UNIQUE (event_id, section, row_label, number)
+);
+
+CREATE INDEX idx_seats_booking ON seats(booking_id);
+
+-- The seats each booking was given, kept after the booking releases them.
+CREATE TABLE booking_seats (
+	booking_id INTEGER NOT NULL REFERENCES bookings(id),
+	seat_id INTEGER NOT NULL REFERENCES seats(id),
+	PRIMARY KEY (booking_id, seat_id)
+);
@@ -0,0 +1,17 @@
+-- One ticket per admission, numbered from 1 within its booking. id is the random code printed on the
+-- ticket and signed into its QR code.
+CREATE TABLE tickets (
+	id TEXT PRIMARY KEY,
+	booking_id INTEGER NOT NULL REFERENCES bookings(id),
+	number INTEGER NOT NULL,
+	UNIQUE (booking_id, number)
+);
+
+-- Issue tickets for the bookings made before tickets existed.
+WITH RECURSIVE numbers(booking_id, number, total) AS (
+	SELECT id, 1, number_of_tickets FROM bookings WHERE number_of_tickets > 0
+	UNION ALL
+	SELECT booking_id, number + 1, total FROM numbers WHERE number < total
+)
+INSERT INTO tickets (id, booking_id, number)
+SELECT upper(hex(randomblob(8))), booking_id, number FROM numbers;
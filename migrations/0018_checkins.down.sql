@@ -0,0 +1,3 @@
+ALTER TABLE tickets DROP COLUMN checked_in_by;
+ALTER TABLE tickets DROP COLUMN gate;
+ALTER TABLE tickets DROP COLUMN checked_in_at;
@@ -0,0 +1,4 @@
+-- Door check-in: when and at which gate a ticket was scanned, and by whom. A ticket admits once.
+ALTER TABLE tickets ADD COLUMN checked_in_at DATETIME;
+ALTER TABLE tickets ADD COLUMN gate TEXT NOT NULL DEFAULT '';
+ALTER TABLE tickets ADD COLUMN checked_in_by INTEGER REFERENCES users(id);
@@ -0,0 +1,10 @@
+-- Cancellation refunds owed to customers, kept until the payment provider accepts them so a failed
+-- refund is retried rather than lost.
+CREATE TABLE pending_refunds (
+	booking_id INTEGER PRIMARY KEY REFERENCES bookings(id),
+	payment_intent_id TEXT NOT NULL,
+	amount INTEGER NOT NULL CHECK (amount > 0),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created DATETIME NOT NULL
+);
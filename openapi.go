@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// openAPIPath serves the generated OpenAPI document.
+const openAPIPath = "/api/openapi.json"
+
+// openAPIFile is the checked-in copy of the document, compared against the generated one by "openapi check".
+const openAPIFile = "openapi.json"
+
+// openAPIHandler serves the OpenAPI document for webRoutes and apiRoutes.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := renderOpenAPISpec()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "could not build the API description")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// renderOpenAPISpec returns the OpenAPI document as indented JSON.
+func renderOpenAPISpec() ([]byte, error) {
+	spec, err := json.MarshalIndent(buildOpenAPISpec(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(spec, '\n'), nil
+}
+
+// checkOpenAPISpec fails if the checked-in document at path differs from the generated one, or if
+// any documented operation is not served by the handler registered for it.
+func checkOpenAPISpec(path string) error {
+	spec, err := renderOpenAPISpec()
+	if err != nil {
+		return err
+	}
+	checkedIn, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(spec, checkedIn) {
+		return fmt.Errorf("%s is out of date; regenerate it with the openapi command", path)
+	}
+
+	mux := http.DefaultServeMux
+	registerWebRoutes()
+	registerAPIRoutes()
+	routes := append(append([]httpRoute{}, webRoutes...), apiRoutes...)
+	routes = append(routes, httpRoute{Method: "GET", Path: openAPIPath})
+	for _, route := range routes {
+		// Fill path wildcards with a sample value and ask the mux which pattern it would use.
+		samplePath := specPath(route.Path)
+		for _, name := range pathParams(route.Path) {
+			samplePath = strings.Replace(samplePath, "{"+name+"}", "1", 1)
+		}
+		_, pattern := mux.Handler(httptest.NewRequest(route.Method, samplePath, nil))
+		if want := route.Method + " " + route.Path; pattern != want {
+			return fmt.Errorf("%s is documented but served by %q", want, pattern)
+		}
+	}
+	return nil
+}
+
+// buildOpenAPISpec describes every route in webRoutes and apiRoutes as an OpenAPI 3 document.
+func buildOpenAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+
+	addRoutes := func(tag string, routes []httpRoute) {
+		names := operationNames(routes)
+		for i, route := range routes {
+			path := specPath(route.Path)
+			if paths[path] == nil {
+				paths[path] = map[string]interface{}{}
+			}
+			paths[path][strings.ToLower(route.Method)] = openAPIOperation(tag, names[i], route, schemas)
+		}
+	}
+	addRoutes("web", webRoutes)
+	addRoutes("api", apiRoutes)
+
+	paths[openAPIPath] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"tags":        []string{"api"},
+			"operationId": "openAPI",
+			"summary":     "This OpenAPI document",
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OK",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}},
+				},
+			},
+		},
+	}
+
+	// Every API error uses the same body.
+	schemaFor(reflect.TypeOf(APIError{}), schemas)
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Go Booking App",
+			"version":     "1.0.0",
+			"description": "Web pages and forms of the booking app, and the JSON API under /api/v1.",
+		},
+		"tags": []map[string]string{
+			{"name": "web", "description": "HTML pages, form posts and the browser checkout"},
+			{"name": "api", "description": "JSON API; errors are returned as APIError"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]string{"type": "apiKey", "in": "cookie", "name": "session_token"},
+				"csrf":    map[string]string{"type": "apiKey", "in": "header", "name": csrfHeaderName},
+				"apiKey":  map[string]string{"type": "http", "scheme": "bearer", "description": "API key from /account/api-keys"},
+			},
+		},
+	}
+}
+
+// openAPIOperation describes one route.
+func openAPIOperation(tag, name string, route httpRoute, schemas map[string]interface{}) map[string]interface{} {
+	isAPI := tag == "api"
+	op := map[string]interface{}{
+		"tags":        []string{tag},
+		"operationId": name,
+		"summary":     route.Summary,
+	}
+	var description []string
+	if route.Permission != "" {
+		description = append(description, "Requires the "+string(route.Permission)+" permission.")
+		op["x-permission"] = string(route.Permission)
+	}
+
+	var params []map[string]interface{}
+	for _, name := range pathParams(route.Path) {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": map[string]string{"type": "integer"},
+		})
+	}
+	for _, param := range route.Query {
+		params = append(params, map[string]interface{}{
+			"name": param.Name, "in": "query", "description": param.Description, "schema": map[string]string{"type": param.Type},
+		})
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	// API mutations by a signed-in user, and CSRF-protected web routes, need the token as well as the session.
+	needsCSRF := route.CSRF || (isAPI && route.Auth && route.Method != "GET")
+	var security []map[string][]string
+	switch {
+	case route.Auth && needsCSRF:
+		security = append(security, map[string][]string{"session": {}, "csrf": {}})
+	case route.Auth:
+		security = append(security, map[string][]string{"session": {}})
+	case needsCSRF:
+		security = append(security, map[string][]string{"csrf": {}})
+	}
+	// Routes with a scope also take an API key; public ones need no credentials at all.
+	if route.Scope != "" {
+		if !route.Auth {
+			security = append(security, map[string][]string{})
+		}
+		security = append(security, map[string][]string{"apiKey": {}})
+		op["x-scope"] = route.Scope
+		description = append(description, "API keys need the "+route.Scope+" scope.")
+	}
+	if security != nil {
+		op["security"] = security
+	}
+	if description != nil {
+		op["description"] = strings.Join(description, " ")
+	}
+
+	switch {
+	case route.Request != nil:
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(route.Request), schemas)}},
+		}
+	case route.Form != nil || route.CSRF:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for _, field := range route.Form {
+			properties[field] = map[string]string{"type": "string"}
+			required = append(required, field)
+		}
+		if route.CSRF {
+			properties[csrfFieldName] = map[string]string{"type": "string", "description": "CSRF token, unless sent in the " + csrfHeaderName + " header"}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema}},
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case route.Response != nil:
+		success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(route.Response), schemas)}}
+	case route.Produces != "":
+		success["content"] = map[string]interface{}{route.Produces: map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}}}
+	case status == http.StatusOK:
+		success["content"] = map[string]interface{}{"text/html": map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+	case status == http.StatusSeeOther:
+		success["description"] = "Redirect; the next page shows a message or error"
+	}
+	responses := map[string]interface{}{fmt.Sprint(status): success}
+	if isAPI {
+		responses["default"] = map[string]interface{}{
+			"description": "Error",
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/APIError"}}},
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// operationNames derives an operationId for each route from its handler's name, adding the method
+// when one handler serves several routes.
+func operationNames(routes []httpRoute) []string {
+	names := make([]string, len(routes))
+	uses := map[string]int{}
+	for i, route := range routes {
+		var handler interface{} = route.Handler
+		if route.wraps != nil {
+			handler = route.wraps
+		}
+		fullName := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+		names[i] = strings.TrimSuffix(fullName[strings.LastIndex(fullName, ".")+1:], "Handler")
+		uses[names[i]]++
+	}
+	for i, route := range routes {
+		if uses[names[i]] > 1 {
+			names[i] += strings.ToUpper(route.Method[:1]) + strings.ToLower(route.Method[1:])
+		}
+	}
+	return names
+}
+
+// specPath turns a ServeMux path into an OpenAPI one; "/{$}" matches only "/".
+func specPath(path string) string {
+	return strings.TrimSuffix(path, "{$}")
+}
+
+// pathParams returns the names of the {wildcards} in a route path.
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && segment != "{$}" {
+			names = append(names, strings.Trim(segment, "{}"))
+		}
+	}
+	return names
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema for values of type t as encoding/json writes them. Named structs
+// are added to schemas and referenced by name.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, done := schemas[t.Name()]; !done {
+			schemas[t.Name()] = nil // placeholder so recursive types terminate
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t, schemas)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema lists a struct's JSON fields. Fields are required unless they are pointers or omitempty.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, schemas)
+		if field.Type.Kind() != reflect.Pointer && !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// eventFormLayout is how the event form's datetime-local input writes dates, in server local time.
+const eventFormLayout = "2006-01-02T15:04"
+
+// organizerEventsHandler lists every event, published or not, with buttons to manage each one.
+func organizerEventsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Manage Events</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1000px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        form { display: inline; }
+        .error { color: red; }
+        .success { color: green; }
+        .draft { color: #999; }
+    </style>
+</head>
+<body>
+    <h1>Manage Events</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    <p><a href="/organizer/events/new">New event</a></p>
+    <table>
+        <tr>
+            <th>#</th>
+            <th>Name</th>
+            <th>Date</th>
+            <th>Location</th>
+            <th>Sold</th>
+            <th>Price</th>
+            <th>Status</th>
+            <th></th>
+        </tr>
+        {{range .Events}}
+        <tr {{if not .Active}}class="draft"{{end}}>
+            <td>{{.ID}}</td>
+            <td>{{.Name}}</td>
+            <td>{{.Date.Format "Jan 2, 2006 15:04"}}</td>
+            <td>{{.Location}}</td>
+            <td>{{sold .}} of {{.TotalTickets}}</td>
+            <td>{{printf "%.2f" .TicketPrice}} {{.Currency}}</td>
+            <td>{{if .Active}}published{{else}}unpublished{{end}}</td>
+            <td>
+                <a href="/organizer/events/{{.ID}}/edit">Edit</a>
+                <a href="/organizer/events/{{.ID}}/ticket-types">Ticket types</a>
+                <a href="/organizer/events/{{.ID}}/seats">Seats</a>
+                {{if .Active}}
+                <form method="POST" action="/organizer/events/{{.ID}}/unpublish">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Unpublish</button>
+                </form>
+                {{else}}
+                <form method="POST" action="/organizer/events/{{.ID}}/publish">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Publish</button>
+                </form>
+                {{end}}
+                <form method="POST" action="/organizer/events/{{.ID}}/delete" onsubmit="return confirm('Delete {{.Name}}?')">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Delete</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    <p><a href="/organizer/discounts">Discount codes</a></p>
+    <p><a href="/events">Back to Events</a></p>
+</body>
+</html>`
+
+	events, err := store.ListEvents()
+	if err != nil {
+		http.Error(w, "Failed to load events", http.StatusInternalServerError)
+		return
+	}
+
+	funcs := template.FuncMap{"sold": func(e Event) int { return e.TotalTickets - e.RemainingTickets }}
+	t, _ := template.New("organizer-events").Funcs(funcs).Parse(tmpl)
+	data := struct {
+		Events    []Event
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Events:    events,
+		Message:   r.URL.Query().Get("message"),
+		Error:     r.URL.Query().Get("error"),
+		CSRFToken: csrfToken(w, r),
+	}
+	t.Execute(w, data)
+}
+
+// eventFormTemplate creates a new event when .Event.ID is 0 and edits it otherwise.
+const eventFormTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{if .Event.ID}}Edit {{.Event.Name}}{{else}}New Event{{end}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px; }
+        .form-group { margin-bottom: 15px; }
+        label { display: block; margin-bottom: 5px; }
+        input, textarea { width: 100%; padding: 8px; }
+        input[type=checkbox] { width: auto; }
+        button { background-color: #4CAF50; color: white; padding: 10px 20px; border: none; cursor: pointer; }
+        .error { color: red; }
+    </style>
+</head>
+<body>
+    <h1>{{if .Event.ID}}Edit {{.Event.Name}}{{else}}New Event{{end}}</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+
+    <form method="POST" action="{{if .Event.ID}}/organizer/events/{{.Event.ID}}{{else}}/organizer/events{{end}}">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <div class="form-group">
+            <label>Name:</label>
+            <input type="text" name="name" value="{{.Event.Name}}" required maxlength="200">
+        </div>
+        <div class="form-group">
+            <label>Description:</label>
+            <textarea name="description" rows="4">{{.Event.Description}}</textarea>
+        </div>
+        <div class="form-group">
+            <label>Date:</label>
+            <input type="datetime-local" name="date" value="{{.Date}}" required>
+        </div>
+        <div class="form-group">
+            <label>Location:</label>
+            <input type="text" name="location" value="{{.Event.Location}}">
+        </div>
+        <div class="form-group">
+            <label>Total tickets:</label>
+            <input type="number" name="total_tickets" value="{{.Event.TotalTickets}}" min="{{.MinTickets}}" required>
+            {{if .Sold}}<small>{{.Sold}} already booked</small>{{end}}
+        </div>
+        <div class="form-group">
+            <label>Ticket price:</label>
+            <input type="number" name="ticket_price" value="{{printf "%.2f" .Event.TicketPrice}}" min="0" step="0.01" required>
+        </div>
+        <div class="form-group">
+            <label>Currency:</label>
+            <input type="text" name="currency" value="{{.Event.Currency}}" required minlength="3" maxlength="3">
+        </div>
+        <div class="form-group">
+            <label><input type="checkbox" name="active" {{if .Event.Active}}checked{{end}}> Published</label>
+        </div>
+        <button type="submit">Save</button>
+    </form>
+    {{if .Event.ID}}<p><a href="/organizer/events/{{.Event.ID}}/ticket-types">Ticket types</a> | <a href="/organizer/events/{{.Event.ID}}/seats">Seat map</a></p>{{end}}
+    <p><a href="/organizer/events">Back to Manage Events</a></p>
+</body>
+</html>`
+
+// renderEventForm shows the event form, with errMessage if the last attempt to save it failed.
+func renderEventForm(w http.ResponseWriter, r *http.Request, event Event, sold int, errMessage string) {
+	date := ""
+	if !event.Date.IsZero() {
+		date = event.Date.Local().Format(eventFormLayout)
+	}
+
+	t, _ := template.New("event-form").Parse(eventFormTemplate)
+	data := struct {
+		Event      Event
+		Date       string
+		Sold       int
+		MinTickets int
+		Error      string
+		CSRFToken  string
+	}{
+		Event:      event,
+		Date:       date,
+		Sold:       sold,
+		MinTickets: max(sold, 1),
+		Error:      errMessage,
+		CSRFToken:  csrfToken(w, r),
+	}
+	if errMessage != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	t.Execute(w, data)
+}
+
+// parseEventForm copies the submitted form onto event.
+func parseEventForm(r *http.Request, event *Event) error {
+	event.Name = r.FormValue("name")
+	event.Description = r.FormValue("description")
+	event.Location = r.FormValue("location")
+	event.Currency = r.FormValue("currency")
+	event.Active = r.FormValue("active") != ""
+
+	date, err := time.ParseInLocation(eventFormLayout, r.FormValue("date"), time.Local)
+	if err != nil {
+		return validationError("date is not valid")
+	}
+	event.Date = date
+	if event.TotalTickets, err = strconv.Atoi(r.FormValue("total_tickets")); err != nil {
+		return validationError("total tickets must be a whole number")
+	}
+	if event.TicketPrice, err = strconv.ParseFloat(r.FormValue("ticket_price"), 64); err != nil {
+		return validationError("ticket price must be a number")
+	}
+	return nil
+}
+
+// newEventHandler shows an empty event form.
+func newEventHandler(w http.ResponseWriter, r *http.Request) {
+	renderEventForm(w, r, Event{Currency: defaultCurrency, Active: true}, 0, "")
+}
+
+// createEventHandler handles the new event form.
+func createEventHandler(w http.ResponseWriter, r *http.Request) {
+	event := Event{}
+	if err := parseEventForm(r, &event); err != nil {
+		renderEventForm(w, r, event, 0, err.Error())
+		return
+	}
+	created, err := addEvent(event)
+	if err != nil {
+		renderEventForm(w, r, event, 0, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/organizer/events?message="+url.QueryEscape("Created "+created.Name), http.StatusSeeOther)
+}
+
+// editEventHandler shows the form for an existing event.
+func editEventHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := organizerEvent(w, r)
+	if !ok {
+		return
+	}
+	renderEventForm(w, r, event, event.TotalTickets-event.RemainingTickets, "")
+}
+
+// updateEventHandler handles the edit form.
+func updateEventHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := organizerEvent(w, r)
+	if !ok {
+		return
+	}
+	sold := event.TotalTickets - event.RemainingTickets
+
+	if err := parseEventForm(r, &event); err != nil {
+		renderEventForm(w, r, event, sold, err.Error())
+		return
+	}
+	saved, err := editEvent(event)
+	if err != nil {
+		renderEventForm(w, r, event, sold, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/organizer/events?message="+url.QueryEscape("Saved "+saved.Name), http.StatusSeeOther)
+}
+
+// publishEventHandler and unpublishEventHandler make an event bookable or hide it from customers.
+func publishEventHandler(w http.ResponseWriter, r *http.Request) {
+	changeEventActive(w, r, true)
+}
+
+func unpublishEventHandler(w http.ResponseWriter, r *http.Request) {
+	changeEventActive(w, r, false)
+}
+
+func changeEventActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	event, err := setEventActive(id, active)
+	if err != nil {
+		http.Redirect(w, r, "/organizer/events?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	state := "Unpublished "
+	if active {
+		state = "Published "
+	}
+	http.Redirect(w, r, "/organizer/events?message="+url.QueryEscape(state+event.Name), http.StatusSeeOther)
+}
+
+// deleteEventHandler removes an event that has never been booked.
+func deleteEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	if err := deleteEvent(id); err != nil {
+		http.Redirect(w, r, "/organizer/events?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/organizer/events?message="+url.QueryEscape("Event deleted"), http.StatusSeeOther)
+}
+
+// organizerEvent loads the {id} event, answering 404 if there is none.
+func organizerEvent(w http.ResponseWriter, r *http.Request) (Event, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return Event{}, false
+	}
+	event, err := store.GetEvent(id)
+	if errors.Is(err, errNotFound) {
+		http.NotFound(w, r)
+		return Event{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load event", http.StatusInternalServerError)
+		return Event{}, false
+	}
+	return event, true
+}
+
+// ticketTypesHandler lists an event's ticket tiers, each in its own edit form, with a form to add one.
+func ticketTypesHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := organizerEvent(w, r)
+	if !ok {
+		return
+	}
+	renderTicketTypes(w, r, event, TicketType{MinPerOrder: 1}, r.URL.Query().Get("error"))
+}
+
+// renderTicketTypes shows the ticket tiers page. draft fills the new tier form after a failed attempt
+// to add it.
+func renderTicketTypes(w http.ResponseWriter, r *http.Request, event Event, draft TicketType, errMessage string) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Ticket Types - {{.Event.Name}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1100px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        input[type=number] { width: 80px; }
+        .error { color: red; }
+        .success { color: green; }
+    </style>
+</head>
+<body>
+    <h1>Ticket Types - {{.Event.Name}}</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    <p>Every tier also counts against the event's {{.Event.TotalTickets}} tickets ({{.Event.RemainingTickets}} left).
+    Events without ticket types sell standard admission at {{printf "%.2f" .Event.TicketPrice}} {{.Event.Currency}}.</p>
+
+    <table>
+        <tr>
+            <th>Name</th>
+            <th>Price</th>
+            <th>Quota</th>
+            <th>Sold</th>
+            <th>Sales start</th>
+            <th>Sales end</th>
+            <th>Min / max per order</th>
+            <th></th>
+        </tr>
+        {{range .Tiers}}
+        {{$form := printf "tier-%d" .ID}}
+        <tr>
+            <td><input type="text" name="name" form="{{$form}}" value="{{.Name}}" required maxlength="100"></td>
+            <td><input type="number" name="price" form="{{$form}}" value="{{printf "%.2f" .Price}}" min="0" step="0.01" required></td>
+            <td><input type="number" name="quota" form="{{$form}}" value="{{.Quota}}" min="1" required></td>
+            <td>{{sold .}}</td>
+            <td><input type="datetime-local" name="sales_start" form="{{$form}}" value="{{formTime .SalesStart}}"></td>
+            <td><input type="datetime-local" name="sales_end" form="{{$form}}" value="{{formTime .SalesEnd}}"></td>
+            <td>
+                <input type="number" name="min_per_order" form="{{$form}}" value="{{.MinPerOrder}}" min="1" required>
+                <input type="number" name="max_per_order" form="{{$form}}" value="{{.MaxPerOrder}}" min="0" required>
+            </td>
+            <td>
+                <form id="{{$form}}" method="POST" action="/organizer/events/{{$.Event.ID}}/ticket-types/{{.ID}}">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Save</button>
+                </form>
+                <form method="POST" action="/organizer/events/{{$.Event.ID}}/ticket-types/{{.ID}}/delete" onsubmit="return confirm('Delete {{.Name}}?')">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Delete</button>
+                </form>
+            </td>
+        </tr>
+        {{else}}
+        <tr><td colspan="8">No ticket types yet.</td></tr>
+        {{end}}
+    </table>
+
+    <h2>New Ticket Type</h2>
+    <form method="POST" action="/organizer/events/{{.Event.ID}}/ticket-types">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p><label>Name: <input type="text" name="name" value="{{.Draft.Name}}" required maxlength="100"></label></p>
+        <p><label>Price: <input type="number" name="price" value="{{printf "%.2f" .Draft.Price}}" min="0" step="0.01" required></label></p>
+        <p><label>Quota: <input type="number" name="quota" value="{{.Draft.Quota}}" min="1" required></label></p>
+        <p><label>Sales start (optional): <input type="datetime-local" name="sales_start" value="{{formTime .Draft.SalesStart}}"></label></p>
+        <p><label>Sales end (optional): <input type="datetime-local" name="sales_end" value="{{formTime .Draft.SalesEnd}}"></label></p>
+        <p><label>Minimum per order: <input type="number" name="min_per_order" value="{{.Draft.MinPerOrder}}" min="1" required></label></p>
+        <p><label>Maximum per order (0 for no limit): <input type="number" name="max_per_order" value="{{.Draft.MaxPerOrder}}" min="0" required></label></p>
+        <button type="submit">Add ticket type</button>
+    </form>
+    <p><a href="/organizer/events/{{.Event.ID}}/edit">Edit {{.Event.Name}}</a> | <a href="/organizer/events">Back to Manage Events</a></p>
+</body>
+</html>`
+
+	tiers, err := store.ListTicketTypes(event.ID)
+	if err != nil {
+		http.Error(w, "Failed to load ticket types", http.StatusInternalServerError)
+		return
+	}
+
+	funcs := template.FuncMap{
+		"sold": func(t TicketType) int { return t.Quota - t.Remaining },
+		"formTime": func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.Local().Format(eventFormLayout)
+		},
+	}
+	t, _ := template.New("ticket-types").Funcs(funcs).Parse(tmpl)
+	data := struct {
+		Event     Event
+		Tiers     []TicketType
+		Draft     TicketType
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Event:     event,
+		Tiers:     tiers,
+		Draft:     draft,
+		Message:   r.URL.Query().Get("message"),
+		Error:     errMessage,
+		CSRFToken: csrfToken(w, r),
+	}
+	if errMessage != "" && r.Method == "POST" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	t.Execute(w, data)
+}
+
+// parseTicketTypeForm copies the submitted tier form onto t.
+func parseTicketTypeForm(r *http.Request, t *TicketType) error {
+	t.Name = r.FormValue("name")
+
+	var err error
+	if t.Price, err = strconv.ParseFloat(r.FormValue("price"), 64); err != nil {
+		return validationError("price must be a number")
+	}
+	if t.Quota, err = strconv.Atoi(r.FormValue("quota")); err != nil {
+		return validationError("quota must be a whole number")
+	}
+	if t.MinPerOrder, err = strconv.Atoi(r.FormValue("min_per_order")); err != nil {
+		return validationError("minimum per order must be a whole number")
+	}
+	if t.MaxPerOrder, err = strconv.Atoi(r.FormValue("max_per_order")); err != nil {
+		return validationError("maximum per order must be a whole number")
+	}
+	if t.SalesStart, err = parseOptionalFormTime(r.FormValue("sales_start")); err != nil {
+		return validationError("sales start is not valid")
+	}
+	if t.SalesEnd, err = parseOptionalFormTime(r.FormValue("sales_end")); err != nil {
+		return validationError("sales end is not valid")
+	}
+	return nil
+}
+
+// parseOptionalFormTime reads a datetime-local value, which may be left empty.
+func parseOptionalFormTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.ParseInLocation(eventFormLayout, value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// createTicketTypeHandler handles the new ticket type form.
+func createTicketTypeHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := organizerEvent(w, r)
+	if !ok {
+		return
+	}
+	draft := TicketType{EventID: event.ID}
+	err := parseTicketTypeForm(r, &draft)
+	if err == nil {
+		_, err = addTicketType(draft)
+	}
+	if err != nil {
+		renderTicketTypes(w, r, event, draft, err.Error())
+		return
+	}
+	redirectToTicketTypes(w, r, event.ID, "message", "Added "+draft.Name)
+}
+
+// updateTicketTypeHandler saves one row of the ticket types table.
+func updateTicketTypeHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := organizerTicketType(w, r)
+	if !ok {
+		return
+	}
+	err := parseTicketTypeForm(r, &t)
+	if err == nil {
+		t, err = editTicketType(t)
+	}
+	if err != nil {
+		redirectToTicketTypes(w, r, t.EventID, "error", err.Error())
+		return
+	}
+	redirectToTicketTypes(w, r, t.EventID, "message", "Saved "+t.Name)
+}
+
+// deleteTicketTypeHandler removes a tier that has never been booked.
+func deleteTicketTypeHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := organizerTicketType(w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteTicketType(t.ID); err != nil {
+		redirectToTicketTypes(w, r, t.EventID, "error", err.Error())
+		return
+	}
+	redirectToTicketTypes(w, r, t.EventID, "message", "Deleted "+t.Name)
+}
+
+func redirectToTicketTypes(w http.ResponseWriter, r *http.Request, eventID int, key, text string) {
+	http.Redirect(w, r, "/organizer/events/"+strconv.Itoa(eventID)+"/ticket-types?"+key+"="+url.QueryEscape(text), http.StatusSeeOther)
+}
+
+// organizerTicketType loads the {typeID} tier of the {id} event, answering 404 if there is none.
+func organizerTicketType(w http.ResponseWriter, r *http.Request) (TicketType, bool) {
+	eventID, errEvent := strconv.Atoi(r.PathValue("id"))
+	id, errType := strconv.Atoi(r.PathValue("typeID"))
+	if errEvent != nil || errType != nil {
+		http.NotFound(w, r)
+		return TicketType{}, false
+	}
+	t, err := eventTicketType(eventID, id)
+	if errors.Is(err, errNotFound) {
+		http.NotFound(w, r)
+		return TicketType{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load ticket type", http.StatusInternalServerError)
+		return TicketType{}, false
+	}
+	return t, true
+}
@@ -3,75 +3,300 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html/template"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
-
-	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/paymentintent"
+	"strings"
+	"time"
 )
 
-const ticketPrice = 5000 // $50.00 in cents
-
-func init() {
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY") // Set this in your environment
-}
+const maxTicketsPerOrder = 10
 
+// PaymentRequest is what the payment page sends; the amount is always computed server-side from the event.
 type PaymentRequest struct {
-	Amount   int64  `json:"amount"`
-	Currency string `json:"currency"`
-	Tickets  int    `json:"tickets"`
+	// BookingID pays for a booking already held, such as a paid booking from the events page; the
+	// other fields are then ignored.
+	BookingID int           `json:"booking_id,omitempty"`
+	Tickets   int           `json:"tickets"`
+	EventID   int           `json:"event_id"`
+	FirstName string        `json:"first_name"`
+	LastName  string        `json:"last_name"`
+	Email     string        `json:"email"`
+	Items     []TicketOrder `json:"items,omitempty"`    // tickets per tier; Tickets is used when empty
+	SeatIDs   []int         `json:"seat_ids,omitempty"` // chosen seats, one per ticket; best available when empty
+	// DiscountCode is checked when the tickets are held; the payment intent is for the discounted amount.
+	DiscountCode string `json:"discount_code,omitempty"`
 }
 
 type PaymentResponse struct {
-	ClientSecret string `json:"client_secret"`
-	Error        string `json:"error,omitempty"`
+	ClientSecret  string `json:"client_secret"`
+	PaymentIntent string `json:"payment_intent,omitempty"`
+	Amount        int64  `json:"amount,omitempty"`
+	Discount      int64  `json:"discount,omitempty"` // taken off by the discount code, in the smallest currency unit
+	Currency      string `json:"currency,omitempty"`
+	Error         string `json:"error,omitempty"`
 }
 
-func createPaymentIntent(tickets int) (*stripe.PaymentIntent, error) {
-	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(int64(tickets * ticketPrice)),
-		Currency: stripe.String(string(stripe.CurrencyUSD)),
-		Metadata: map[string]string{
-			"tickets": strconv.Itoa(tickets),
-		},
-	}
+// priceInCents converts an event's ticket price to the smallest currency unit.
+func priceInCents(event Event) int64 {
+	return int64(math.Round(event.TicketPrice * 100))
+}
 
-	return paymentintent.New(params)
+// createPaymentIntent charges for a held booking at its tiers' prices, in its event's currency.
+func createPaymentIntent(event Event, booking EventBooking) (PaymentIntent, error) {
+	metadata := map[string]string{
+		"tickets":    strconv.Itoa(booking.NumberOfTickets),
+		"event_id":   strconv.Itoa(event.ID),
+		"booking_id": strconv.Itoa(booking.ID),
+	}
+	return paymentProvider.CreateIntent(bookingAmountCents(event, booking), event.Currency, metadata)
 }
 
-func paymentHandler(w http.ResponseWriter, r *http.Request) {
+func paymentHandler(w http.ResponseWriter, r *http.Request, body jsonBody[PaymentRequest, PaymentResponse]) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req PaymentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	req, err := body.read(r)
+	if err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	pi, err := createPaymentIntent(req.Tickets)
+	user, _ := currentUser(r)
+	if awaitingVerification(user.ID) {
+		writePaymentError(w, errVerifyToPay.Error())
+		return
+	}
+	if req.BookingID != 0 {
+		hold, pi, err := holdPayment(req.BookingID, user.ID)
+		if err != nil {
+			writePaymentError(w, err.Error())
+			return
+		}
+		body.write(w, http.StatusOK, paymentResponse(hold, pi))
+		return
+	}
+
+	if req.EventID == 0 {
+		req.EventID = defaultEventID
+	}
+	event, err := store.GetEvent(req.EventID)
 	if err != nil {
-		response := PaymentResponse{Error: err.Error()}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writePaymentError(w, "event not found")
+		return
+	}
+	orders := req.Items
+	if len(orders) == 0 {
+		orders = ticketOrders(req.Tickets)
+	}
+	isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(req.FirstName, req.LastName, req.Email, uint(max(orderSize(orders), 0)), maxTicketsPerOrder)
+	if !isValidName || !isValidEmail || !isValidTicketNumber {
+		writePaymentError(w, "Invalid input data")
 		return
 	}
 
-	response := PaymentResponse{ClientSecret: pi.ClientSecret}
+	// Hold the tickets for the length of the checkout so the payer cannot be
+	// charged for seats that sold out in the meantime.
+	hold, err := placeHold(req.EventID, user.ID, req.FirstName, req.LastName, req.Email, orders, req.SeatIDs, req.DiscountCode)
+	if err != nil {
+		writePaymentError(w, err.Error())
+		return
+	}
+
+	if bookingAmountCents(event, *hold) == 0 {
+		store.ReleaseHold(hold.ID, "cancelled")
+		writePaymentError(w, "This order is free with your discount code; book it from the events page")
+		return
+	}
+
+	pi, err := startCheckout(event, *hold)
+	if err != nil {
+		store.ReleaseHold(hold.ID, "cancelled")
+		writePaymentError(w, err.Error())
+		return
+	}
+	body.write(w, http.StatusOK, paymentResponse(*hold, pi))
+}
+
+// startCheckout creates the payment intent for a hold and links the two, so the payment confirms it.
+func startCheckout(event Event, hold EventBooking) (PaymentIntent, error) {
+	pi, err := createPaymentIntent(event, hold)
+	if err != nil {
+		return PaymentIntent{}, err
+	}
+	if err := store.SetPaymentIntent(hold.ID, pi.ID); err != nil {
+		// Nothing could match a payment to the hold, so the intent may not be charged.
+		paymentProvider.CancelIntent(pi.ID)
+		return PaymentIntent{}, err
+	}
+	return pi, nil
+}
+
+// errHoldLapsed is returned for paying a hold that is no longer pending.
+var errHoldLapsed = validationError("this booking is no longer held; please book again")
+
+// holdPayment returns the payment intent for userID's held booking, starting a checkout if the hold
+// has none that can still be paid.
+func holdPayment(bookingID, userID int) (EventBooking, PaymentIntent, error) {
+	hold, err := store.GetBooking(bookingID)
+	if err != nil || hold.UserID != userID {
+		return EventBooking{}, PaymentIntent{}, fmt.Errorf("booking not found")
+	}
+	if !hold.AwaitsPayment() || (hold.HoldExpires != nil && hold.HoldExpires.Before(time.Now())) {
+		return EventBooking{}, PaymentIntent{}, errHoldLapsed
+	}
+	if hold.PaymentIntentID != "" {
+		if pi, err := paymentProvider.GetIntent(hold.PaymentIntentID); err == nil && pi.Status != "canceled" {
+			return hold, pi, nil
+		}
+	}
+	event, err := store.GetEvent(hold.EventID)
+	if err != nil {
+		return EventBooking{}, PaymentIntent{}, err
+	}
+	pi, err := startCheckout(event, hold)
+	if err != nil {
+		return EventBooking{}, PaymentIntent{}, err
+	}
+	hold.PaymentIntentID = pi.ID
+	return hold, pi, nil
+}
+
+// checkoutURL is the payment page for a held booking.
+func checkoutURL(hold EventBooking) string {
+	return "/payment?booking_id=" + strconv.Itoa(hold.ID)
+}
+
+// paymentResponse tells the client how to pay for a hold.
+func paymentResponse(hold EventBooking, pi PaymentIntent) PaymentResponse {
+	return PaymentResponse{ClientSecret: pi.ClientSecret, PaymentIntent: pi.ID, Amount: pi.Amount,
+		Discount: int64(math.Round(hold.DiscountAmount * 100)), Currency: pi.Currency}
+}
+
+func writePaymentError(w http.ResponseWriter, message string) {
+	response := PaymentResponse{Error: message}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// ConfirmPaymentRequest asks the server to charge a payment intent, for providers without a browser SDK.
+type ConfirmPaymentRequest struct {
+	PaymentIntent string `json:"payment_intent"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+// ConfirmPaymentResponse reports the payment intent's status after a confirm attempt.
+type ConfirmPaymentResponse struct {
+	PaymentIntent string `json:"payment_intent"`
+	Status        string `json:"status"`
+}
+
+// confirmPaymentHandler charges a payment intent server-side and applies the outcome to its hold.
+func confirmPaymentHandler(w http.ResponseWriter, r *http.Request, body jsonBody[ConfirmPaymentRequest, ConfirmPaymentResponse]) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := body.read(r)
+	if err != nil || req.PaymentIntent == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Only the customer whose booking an intent pays for may charge it.
+	intent, err := paymentProvider.GetIntent(req.PaymentIntent)
+	if err != nil {
+		writePaymentError(w, "payment not found")
+		return
+	}
+	booking, err := bookingForPaymentIntent(intent.ID, intent.Metadata)
+	user, _ := currentUser(r)
+	if err != nil || booking.UserID != user.ID {
+		writePaymentError(w, "payment not found")
+		return
+	}
+
+	pi, err := paymentProvider.ConfirmIntent(req.PaymentIntent, req.PaymentMethod)
+	if err != nil {
+		writePaymentError(w, err.Error())
+		return
+	}
+
+	event := PaymentEvent{Type: "payment_intent.payment_failed", PaymentIntentID: pi.ID, Metadata: pi.Metadata}
+	if pi.Status == "succeeded" {
+		event.Type = "payment_intent.succeeded"
+	}
+	if pi.Status != "processing" {
+		if err := handlePaymentEvent(event); err != nil {
+			writePaymentError(w, err.Error())
+			return
+		}
+	}
+
+	body.write(w, http.StatusOK, ConfirmPaymentResponse{PaymentIntent: pi.ID, Status: pi.Status})
+}
+
+// bookingSuccessHandler confirms the held booking once the provider reports the payment intent as succeeded.
+func bookingSuccessHandler(w http.ResponseWriter, r *http.Request) {
+	pi, err := paymentProvider.GetIntent(r.URL.Query().Get("payment_intent"))
+	if err != nil {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+	booking, err := bookingForPaymentIntent(pi.ID, pi.Metadata)
+	user, _ := currentUser(r)
+	if err != nil || booking.UserID != user.ID {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	message := "Your payment is still processing. Your tickets are held until it completes."
+	if pi.Status == "succeeded" {
+		if err := handlePaymentEvent(PaymentEvent{Type: "payment_intent.succeeded", PaymentIntentID: pi.ID, Metadata: pi.Metadata}); err != nil {
+			http.Error(w, "Could not confirm booking: "+err.Error(), http.StatusConflict)
+			return
+		}
+		if booking, err = store.GetBooking(booking.ID); err != nil {
+			http.Error(w, "Could not load booking", http.StatusInternalServerError)
+			return
+		}
+		switch booking.Status {
+		case "confirmed":
+			message = "Payment received. Your booking is confirmed!"
+		case "refunded":
+			message = "Your tickets were no longer available when your payment arrived, so it has been refunded in full."
+		default:
+			message = "Your booking is " + booking.Status + "."
+		}
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head><title>Booking</title></head>
+<body>
+    <h1>{{.}}</h1>
+    <p><a href="/events">Back to Events</a></p>
+</body>
+</html>`
+
+	t, _ := template.New("success").Parse(tmpl)
+	t.Execute(w, message)
+}
+
 func paymentPageHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := `
 <!DOCTYPE html>
 <html>
 <head>
-    <title>Payment - {{.EventName}}</title>
-    <script src="https://js.stripe.com/v3/"></script>
+    <title>Payment - {{.Event.Name}}</title>
+    {{if eq .Provider "stripe"}}<script src="https://js.stripe.com/v3/"></script>{{end}}
     <style>
         body { font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px; }
         .form-group { margin-bottom: 15px; }
@@ -81,18 +306,53 @@ func paymentPageHandler(w http.ResponseWriter, r *http.Request) {
     </style>
 </head>
 <body>
-    <h1>Payment for {{.EventName}}</h1>
+    <h1>Payment for {{.Event.Name}}</h1>
     <div id="payment-form">
+        {{with .Hold}}
+        <div class="form-group">
+            <p>Booking #{{.ID}}: {{.NumberOfTickets}} ticket(s) for {{.FirstName}} {{.LastName}}{{range .Seats}}, {{.Label}}{{end}}</p>
+            <p>Total: <span id="total">{{printf "%.2f" .TotalAmount}} {{$.Currency}}</span>{{if .DiscountCode}} ({{.DiscountCode}} applied){{end}}</p>
+            {{with .HoldExpires}}<p>Your tickets are held until {{.Local.Format "15:04"}}.</p>{{end}}
+        </div>
+        {{else}}
+        <div class="form-group">
+            <label>First Name:</label>
+            <input type="text" id="first-name" required>
+            <label>Last Name:</label>
+            <input type="text" id="last-name" required>
+            <label>Email:</label>
+            <input type="email" id="email" required>
+        </div>
+
         <div class="form-group">
+            {{if .Tiers}}
+            {{range .Tiers}}
+            <label>{{.Name}} ({{printf "%.2f" .Price}} {{$.Currency}}, {{.Remaining}} left):</label>
+            <input type="number" class="tier" data-id="{{.ID}}" data-cents="{{cents .Price}}" min="0"
+                   max="{{if .MaxPerOrder}}{{.MaxPerOrder}}{{else}}{{$.MaxTickets}}{{end}}" value="0">
+            {{end}}
+            <p>Total: <span id="total">0.00 {{.Currency}}</span></p>
+            {{else}}
             <label>Number of Tickets:</label>
-            <input type="number" id="tickets" min="1" max="10" value="1">
-            <p>Price per ticket: $50.00</p>
-            <p>Total: $<span id="total">50.00</span></p>
+            <input type="number" id="tickets" min="1" max="{{.MaxTickets}}" value="1">
+            <p>Price per ticket: {{.PriceLabel}}</p>
+            <p>Total: <span id="total">{{.PriceLabel}}</span></p>
+            {{end}}
+            <label>Discount code:</label>
+            <input type="text" id="discount-code">
         </div>
+        {{end}}
         
         <div class="form-group">
             <label>Card Details:</label>
+            {{if eq .Provider "stripe"}}
             <div id="card-element"></div>
+            {{else}}
+            <select id="test-card">
+                <option value="pm_card_visa">Test card (succeeds)</option>
+                <option value="pm_card_declined">Test card (declined)</option>
+            </select>
+            {{end}}
             <div id="card-errors" class="error"></div>
         </div>
         
@@ -100,40 +360,94 @@ func paymentPageHandler(w http.ResponseWriter, r *http.Request) {
     </div>
 
     <script>
-        const stripe = Stripe('pk_test_your_publishable_key_here'); // Replace with your publishable key
-        const elements = stripe.elements();
-        const cardElement = elements.create('card');
-        cardElement.mount('#card-element');
+        const provider = {{.Provider}};
+        let stripe, cardElement;
+        if (provider === 'stripe') {
+            stripe = Stripe({{.PublishableKey}});
+            cardElement = stripe.elements().create('card');
+            cardElement.mount('#card-element');
+        }
 
+        const csrfToken = {{.CSRFToken}};
+        const eventID = {{.Event.ID}};
+        const holdID = {{if .Hold}}{{.Hold.ID}}{{else}}0{{end}};
+        const priceCents = {{.PriceCents}};
+        const currency = {{.Event.Currency}};
         const ticketsInput = document.getElementById('tickets');
+        const tierInputs = document.querySelectorAll('.tier');
         const totalSpan = document.getElementById('total');
+
+        function formatAmount(cents) {
+            return new Intl.NumberFormat(undefined, { style: 'currency', currency: currency }).format(cents / 100);
+        }
         
-        ticketsInput.addEventListener('input', function() {
-            const tickets = parseInt(this.value) || 1;
-            const total = (tickets * 50).toFixed(2);
-            totalSpan.textContent = total;
-        });
+        // Events with ticket tiers order a quantity of each; the others a number of tickets.
+        function order() {
+            if (!ticketsInput) {
+                return Array.from(tierInputs).map(input => ({
+                    ticket_type_id: parseInt(input.dataset.id),
+                    quantity: parseInt(input.value) || 0
+                })).filter(item => item.quantity > 0);
+            }
+            return [];
+        }
+
+        function updateTotal() {
+            if (ticketsInput) {
+                totalSpan.textContent = formatAmount((parseInt(ticketsInput.value) || 1) * priceCents);
+                return;
+            }
+            let cents = 0;
+            tierInputs.forEach(input => { cents += (parseInt(input.value) || 0) * parseInt(input.dataset.cents); });
+            totalSpan.textContent = formatAmount(cents);
+        }
+        (ticketsInput ? [ticketsInput] : tierInputs).forEach(input => input.addEventListener('input', updateTotal));
 
         document.getElementById('submit-payment').addEventListener('click', async function() {
-            const tickets = parseInt(ticketsInput.value) || 1;
-            
-            // Create payment intent
+            // Create payment intent; the server prices it from the event, or from the held booking
+            const request = holdID ? { booking_id: holdID } : {
+                event_id: eventID,
+                tickets: ticketsInput ? (parseInt(ticketsInput.value) || 1) : 0,
+                items: order(),
+                first_name: document.getElementById('first-name').value,
+                last_name: document.getElementById('last-name').value,
+                email: document.getElementById('email').value,
+                discount_code: document.getElementById('discount-code').value
+            };
             const response = await fetch('/create-payment-intent', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({
-                    amount: tickets * 5000,
-                    currency: 'usd',
-                    tickets: tickets
-                })
+                headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+                body: JSON.stringify(request)
             });
             
-            const { client_secret, error } = await response.json();
+            const { client_secret, payment_intent, amount, discount, error } = await response.json();
             
             if (error) {
                 document.getElementById('card-errors').textContent = error;
                 return;
             }
+            if (discount && !holdID) {
+                totalSpan.textContent = formatAmount(amount) + ' (' + formatAmount(discount) + ' off)';
+            }
+
+            if (provider !== 'stripe') {
+                // Providers without a browser SDK are charged by the server
+                const confirm = await fetch('/confirm-payment', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken },
+                    body: JSON.stringify({
+                        payment_intent: payment_intent,
+                        payment_method: document.getElementById('test-card').value
+                    })
+                });
+                const outcome = await confirm.json();
+                if (outcome.error || outcome.status !== 'succeeded') {
+                    document.getElementById('card-errors').textContent = outcome.error || 'Your card was declined.';
+                    return;
+                }
+                window.location.href = '/booking-success?payment_intent=' + outcome.payment_intent;
+                return;
+            }
             
             // Confirm payment
             const result = await stripe.confirmCardPayment(client_secret, {
@@ -153,6 +467,77 @@ func paymentPageHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
+	// A booking_id pays for a booking already held rather than choosing tickets here.
+	var hold *EventBooking
+	eventID := defaultEventID
+	if id := r.URL.Query().Get("booking_id"); id != "" {
+		bookingID, _ := strconv.Atoi(id)
+		booking, err := store.GetBooking(bookingID)
+		user, _ := currentUser(r)
+		if err != nil || booking.UserID != user.ID {
+			http.Error(w, "Booking not found", http.StatusNotFound)
+			return
+		}
+		if !booking.AwaitsPayment() {
+			http.Redirect(w, r, "/my-bookings?error="+url.QueryEscape(errHoldLapsed.Error()), http.StatusSeeOther)
+			return
+		}
+		hold = &booking
+		eventID = booking.EventID
+	} else if id := r.URL.Query().Get("event_id"); id != "" {
+		parsed, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "Invalid event", http.StatusBadRequest)
+			return
+		}
+		eventID = parsed
+	}
+
+	event, err := store.GetEvent(eventID)
+	if err != nil || !event.Active {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	var tiers []TicketType
+	if hold == nil {
+		tiers, err = onSaleTicketTypes(event.ID)
+		if err != nil {
+			http.Error(w, "Failed to load ticket types", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	funcs := template.FuncMap{"cents": func(price float64) int64 { return int64(math.Round(price * 100)) }}
+	t, err := template.New("payment").Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Event          Event
+		Hold           *EventBooking
+		PriceCents     int64
+		PriceLabel     string
+		Currency       string
+		Tiers          []TicketType
+		MaxTickets     int
+		PublishableKey string
+		Provider       string
+		CSRFToken      string
+	}{
+		Event:          event,
+		Hold:           hold,
+		PriceCents:     priceInCents(event),
+		PriceLabel:     fmt.Sprintf("%.2f %s", event.TicketPrice, strings.ToUpper(event.Currency)),
+		Currency:       strings.ToUpper(event.Currency),
+		Tiers:          tiers,
+		MaxTickets:     min(maxTicketsPerOrder, event.RemainingTickets),
+		PublishableKey: os.Getenv("STRIPE_PUBLISHABLE_KEY"),
+		Provider:       paymentProvider.Name(),
+		CSRFToken:      csrfToken(w, r),
+	}
+
 	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(tmpl))
+	t.Execute(w, data)
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// useFakePayments makes a fresh fake provider the active one, taking webhooks signed with testWebhookSecret.
+func useFakePayments(t *testing.T) *fakePaymentProvider {
+	t.Helper()
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", testWebhookSecret)
+	provider := newFakePaymentProvider()
+	previous := paymentProvider
+	paymentProvider = provider
+	t.Cleanup(func() { paymentProvider = previous })
+	return provider
+}
+
+// paymentIntentEvent is the Stripe event the provider sends when a payment intent changes.
+func paymentIntentEvent(t *testing.T, id, eventType string, pi PaymentIntent) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"id":     id,
+		"object": "event",
+		"type":   eventType,
+		"data": map[string]any{"object": map[string]any{
+			"id":       pi.ID,
+			"object":   "payment_intent",
+			"amount":   pi.Amount,
+			"currency": pi.Currency,
+			"status":   pi.Status,
+			"metadata": pi.Metadata,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// deliverWebhook posts payload to the webhook handler with the given Stripe-Signature header.
+func deliverWebhook(payload []byte, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/stripe/webhook", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signature)
+	rec := httptest.NewRecorder()
+	stripeWebhookHandler(rec, req)
+	return rec
+}
+
+// deliverSignedWebhook posts payload signed with testWebhookSecret.
+func deliverSignedWebhook(t *testing.T, payload []byte) {
+	t.Helper()
+	if rec := deliverWebhook(payload, signWebhookPayload(payload, testWebhookSecret)); rec.Code != http.StatusOK {
+		t.Fatalf("webhook: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestFakeCheckout takes a booking through checkout with the fake provider: the tickets are held, a
+// payment intent is created and charged, and the webhook reporting the payment confirms the booking.
+func TestFakeCheckout(t *testing.T) {
+	useStore(t, newTestSQLiteStore(t))
+	useFakePayments(t)
+	event := createTestEvent(t, 10, 25)
+
+	hold, err := placeHold(event.ID, 0, "Ada", "Lovelace", "ada@example.com", ticketOrders(2), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold.Status != "pending" || eventTicketsLeft(t, event.ID) != 8 {
+		t.Fatalf("hold is %s with %d tickets left, want pending with 8", hold.Status, eventTicketsLeft(t, event.ID))
+	}
+
+	pi, err := startCheckout(event, *hold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Amount != 5000 || pi.Metadata["booking_id"] != "1" {
+		t.Fatalf("intent for %d cents of booking %q, want 5000 of booking 1", pi.Amount, pi.Metadata["booking_id"])
+	}
+	if booking, _ := store.GetBooking(hold.ID); booking.PaymentIntentID != pi.ID {
+		t.Fatalf("hold is linked to %q, want %q", booking.PaymentIntentID, pi.ID)
+	}
+
+	pi, err = paymentProvider.ConfirmIntent(pi.ID, "pm_card_visa")
+	if err != nil || pi.Status != "succeeded" {
+		t.Fatalf("confirm: %v, status %q", err, pi.Status)
+	}
+	if status := bookingStatus(t, hold.ID); status != "pending" {
+		t.Fatalf("booking is %s before the webhook, want pending", status)
+	}
+
+	deliverSignedWebhook(t, paymentIntentEvent(t, "evt_paid", "payment_intent.succeeded", pi))
+	if status := bookingStatus(t, hold.ID); status != "confirmed" {
+		t.Fatalf("booking is %s after the webhook, want confirmed", status)
+	}
+	if left := eventTicketsLeft(t, event.ID); left != 8 {
+		t.Fatalf("%d tickets left, want 8", left)
+	}
+}
+
+// TestFakeCheckoutDeclined gives the tickets back when the card is declined.
+func TestFakeCheckoutDeclined(t *testing.T) {
+	useStore(t, newTestSQLiteStore(t))
+	useFakePayments(t)
+	event := createTestEvent(t, 10, 25)
+
+	hold, err := placeHold(event.ID, 0, "Ada", "Lovelace", "ada@example.com", ticketOrders(2), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, err := startCheckout(event, *hold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi, err = paymentProvider.ConfirmIntent(pi.ID, fakeDeclinedPaymentMethod); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverSignedWebhook(t, paymentIntentEvent(t, "evt_declined", "payment_intent.payment_failed", pi))
+	if status := bookingStatus(t, hold.ID); status != "failed" {
+		t.Fatalf("booking is %s, want failed", status)
+	}
+	if left := eventTicketsLeft(t, event.ID); left != 10 {
+		t.Fatalf("%d tickets left, want 10", left)
+	}
+}
+
+// TestPaymentAfterSellOutIsRefunded refunds a payment that arrives after its hold lapsed and the
+// tickets went to someone else.
+func TestPaymentAfterSellOutIsRefunded(t *testing.T) {
+	useStore(t, newTestSQLiteStore(t))
+	provider := useFakePayments(t)
+	event := createTestEvent(t, 2, 25)
+
+	hold, err := placeHold(event.ID, 0, "Ada", "Lovelace", "ada@example.com", ticketOrders(2), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, err := startCheckout(event, *hold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ReleaseHold(hold.ID, "expired"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := placeHold(event.ID, 0, "Grace", "Hopper", "grace@example.com", ticketOrders(2), nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if pi, err = paymentProvider.ConfirmIntent(pi.ID, "pm_card_visa"); err != nil {
+		t.Fatal(err)
+	}
+	deliverSignedWebhook(t, paymentIntentEvent(t, "evt_late", "payment_intent.succeeded", pi))
+	// However often the payment is reported, it is refunded once.
+	if err := handlePaymentEvent(PaymentEvent{Type: "payment_intent.succeeded", PaymentIntentID: pi.ID, Metadata: pi.Metadata}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := bookingStatus(t, hold.ID); status != "refunded" {
+		t.Fatalf("booking is %s, want refunded", status)
+	}
+	if refunded := provider.refunded[pi.ID]; refunded != pi.Amount {
+		t.Fatalf("refunded %d, want %d", refunded, pi.Amount)
+	}
+}
+
+// failingRefunds is a fake provider whose refunds fail while failing is set.
+type failingRefunds struct {
+	*fakePaymentProvider
+	failing bool
+}
+
+func (p *failingRefunds) Refund(paymentIntentID string, amount int64) error {
+	if p.failing {
+		return errors.New("provider unavailable")
+	}
+	return p.fakePaymentProvider.Refund(paymentIntentID, amount)
+}
+
+// TestFailedRefundIsRetried keeps a cancellation refund the provider turned down queued until the
+// sweeper's retry goes through.
+func TestFailedRefundIsRetried(t *testing.T) {
+	useStore(t, newTestSQLiteStore(t))
+	fake := useFakePayments(t)
+	provider := &failingRefunds{fakePaymentProvider: fake, failing: true}
+	paymentProvider = provider
+	event := createTestEvent(t, 10, 25)
+
+	hold, err := placeHold(event.ID, 0, "Ada", "Lovelace", "ada@example.com", ticketOrders(2), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, err := startCheckout(event, *hold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi, err = paymentProvider.ConfirmIntent(pi.ID, "pm_card_visa"); err != nil {
+		t.Fatal(err)
+	}
+	deliverSignedWebhook(t, paymentIntentEvent(t, "evt_paid", "payment_intent.succeeded", pi))
+
+	refunded, err := cancelBookingForUser(hold.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded != 50 || bookingStatus(t, hold.ID) != "cancelled" || fake.refunded[pi.ID] != 0 {
+		t.Fatalf("refunded %.2f of a %s booking, %d at the provider; want 50 owed on a cancelled booking, none paid",
+			refunded, bookingStatus(t, hold.ID), fake.refunded[pi.ID])
+	}
+	queued, err := store.PendingRefunds(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].Amount != pi.Amount || queued[0].Attempts != 1 {
+		t.Fatalf("queued refunds %+v, want one of %d after one attempt", queued, pi.Amount)
+	}
+
+	previousDelay := refundRetryDelay
+	refundRetryDelay = -time.Hour
+	t.Cleanup(func() { refundRetryDelay = previousDelay })
+	if n, err := retryPendingRefunds(); err != nil || n != 0 {
+		t.Fatalf("retry while failing: %d issued, %v; want none", n, err)
+	}
+	provider.failing = false
+	if n, err := retryPendingRefunds(); err != nil || n != 1 {
+		t.Fatalf("retry: %d issued, %v; want 1", n, err)
+	}
+	if fake.refunded[pi.ID] != pi.Amount {
+		t.Fatalf("refunded %d, want %d", fake.refunded[pi.ID], pi.Amount)
+	}
+	if queued, _ := store.PendingRefunds(time.Now().Add(time.Hour)); len(queued) != 0 {
+		t.Fatalf("%d refunds still queued, want none", len(queued))
+	}
+}
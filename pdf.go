@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+)
+
+// pdfDocument builds a PDF of A4 pages. It writes text in the standard Helvetica and Courier fonts,
+// which every PDF reader has built in, so no font files need embedding.
+type pdfDocument struct {
+	title string
+	pages []*pdfPage
+}
+
+// pdfPage is the content stream of one page. Coordinates are in points from the bottom-left corner.
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+// A4 in points.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+// pdfFont is one of the standard fonts, named /F1, /F2, ... in each page's resources.
+type pdfFont int
+
+const (
+	fontRegular pdfFont = iota // Helvetica
+	fontBold                   // Helvetica-Bold
+	fontMono                   // Courier
+)
+
+var pdfFontNames = []string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+func newPDF(title string) *pdfDocument {
+	return &pdfDocument{title: title}
+}
+
+// AddPage starts a new page at the end of the document.
+func (d *pdfDocument) AddPage() *pdfPage {
+	page := &pdfPage{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Text writes text with its baseline starting at (x, y).
+func (p *pdfPage) Text(x, y float64, font pdfFont, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font+1, size, x, y, pdfString(text))
+}
+
+// TextRight writes text ending at right, for columns of amounts.
+func (p *pdfPage) TextRight(right, y float64, font pdfFont, size float64, text string) {
+	p.Text(right-textWidth(font, size, text), y, font, size, text)
+}
+
+// TextCenter writes text centered on x.
+func (p *pdfPage) TextCenter(x, y float64, font pdfFont, size float64, text string) {
+	p.Text(x-textWidth(font, size, text)/2, y, font, size, text)
+}
+
+// Line draws a thin line from (x1, y1) to (x2, y2).
+func (p *pdfPage) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// Box draws the outline of a rectangle whose bottom-left corner is (x, y).
+func (p *pdfPage) Box(x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "1 w %.2f %.2f %.2f %.2f re S\n", x, y, width, height)
+}
+
+// QRCode draws a QR code as vector squares, size points wide, with its bottom-left corner at (x, y).
+// Leave a quiet zone of four modules around it.
+func (p *pdfPage) QRCode(x, y, size float64, qr *qrCode) {
+	module := size / float64(qr.size)
+	for row := range qr.modules {
+		top := y + size - float64(row+1)*module
+		// One rectangle per run of dark modules keeps the page small.
+		for col := 0; col < qr.size; col++ {
+			if !qr.modules[row][col] {
+				continue
+			}
+			start := col
+			for col < qr.size && qr.modules[row][col] {
+				col++
+			}
+			fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re\n", x+float64(start)*module, top, float64(col-start)*module, module)
+		}
+	}
+	p.content.WriteString("f\n")
+}
+
+// Bytes writes out the document.
+func (d *pdfDocument) Bytes() ([]byte, error) {
+	var objects []string
+	add := func(object string) int {
+		objects = append(objects, object)
+		return len(objects)
+	}
+
+	add("") // the catalog, once the pages object is known
+	add("") // the pages, once their kids are known
+	fonts := make([]string, len(pdfFontNames))
+	for i, name := range pdfFontNames {
+		ref := add(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts[i] = fmt.Sprintf("/F%d %d 0 R", i+1, ref)
+	}
+	resources := "<< /Font << " + strings.Join(fonts, " ") + " >> >>"
+
+	var kids []string
+	for _, page := range d.pages {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(page.content.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		contents := add(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.String()))
+		ref := add(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, resources, contents))
+		kids = append(kids, fmt.Sprintf("%d 0 R", ref))
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+	info := add(fmt.Sprintf("<< /Title (%s) /Producer (Go Booking App) >>", pdfString(d.title)))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, info, xref)
+	return buf.Bytes(), nil
+}
+
+// winAnsi maps the characters outside Latin-1 that WinAnsiEncoding has.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99, 'Š': 0x8A, 'š': 0x9A, 'Œ': 0x8C, 'œ': 0x9C,
+	'Ž': 0x8E, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfString encodes text for a PDF string literal in WinAnsiEncoding. Characters the encoding
+// lacks become question marks.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		case winAnsi[r] != 0:
+			b.WriteByte(winAnsi[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Widths of the printable ASCII characters, from space to tilde, in thousandths of the font size.
+var (
+	helveticaWidths = []int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = []int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// textWidth measures text in points. Characters outside ASCII count as the width of a digit,
+// which is close enough for accented letters.
+func textWidth(font pdfFont, size float64, text string) float64 {
+	widths := helveticaWidths
+	switch font {
+	case fontBold:
+		widths = helveticaBoldWidths
+	case fontMono:
+		return float64(len([]rune(text))) * 600 * size / 1000
+	}
+	total := 0
+	for _, r := range text {
+		if r >= ' ' && r <= '~' {
+			total += widths[r-' ']
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// wrapText breaks text into lines no wider than width.
+func wrapText(font pdfFont, size, width float64, text string) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && textWidth(font, size, line+" "+word) > width {
+			lines = append(lines, line)
+			line = word
+		} else if line != "" {
+			line += " " + word
+		} else {
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// qrCode is a QR code symbol (ISO/IEC 18004) encoding bytes at error correction level M, which
+// survives about 15% of the symbol being smudged or torn. Versions 1 to 10 are supported, enough
+// for up to 213 bytes.
+type qrCode struct {
+	size    int
+	modules [][]bool // [row][column], true is dark
+}
+
+// errQRTooLong is returned for data that does not fit in the largest supported version.
+var errQRTooLong = errors.New("qr code: data too long")
+
+// qrBlocks describes the error correction blocks of a version at level M.
+type qrBlocks struct {
+	ecPerBlock int
+	groups     [][2]int // {number of blocks, data codewords per block}
+}
+
+// qrVersionsM lists the block structure of versions 1 to 10 at level M.
+var qrVersionsM = []qrBlocks{
+	{10, [][2]int{{1, 16}}},
+	{16, [][2]int{{1, 28}}},
+	{26, [][2]int{{1, 44}}},
+	{18, [][2]int{{2, 32}}},
+	{24, [][2]int{{2, 43}}},
+	{16, [][2]int{{4, 27}}},
+	{18, [][2]int{{4, 31}}},
+	{22, [][2]int{{2, 38}, {2, 39}}},
+	{22, [][2]int{{3, 36}, {2, 37}}},
+	{26, [][2]int{{4, 43}, {1, 44}}},
+}
+
+// qrAlignment lists the alignment pattern centres of versions 2 to 10.
+var qrAlignment = [][]int{
+	nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34}, {6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	n := 0
+	for _, g := range b.groups {
+		n += g[0] * g[1]
+	}
+	return n
+}
+
+// newQRCode encodes data in the smallest version that holds it, with the mask that scores best.
+func newQRCode(data []byte) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= len(qrVersionsM); v++ {
+		if 4+qrCountBits(v)+8*len(data) <= qrVersionsM[v-1].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+	codewords := qrAddErrorCorrection(qrDataCodewords(data, version), qrVersionsM[version-1])
+
+	var best *qrCode
+	bestPenalty := 0
+	for mask := 0; mask < 8; mask++ {
+		q, function := newQRGrid(version)
+		q.placeData(codewords, function)
+		q.applyMask(mask, function)
+		q.drawFormat(mask)
+		if penalty := q.penalty(); best == nil || penalty < bestPenalty {
+			best, bestPenalty = q, penalty
+		}
+	}
+	return best, nil
+}
+
+// qrCountBits is the width of the byte mode character count.
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrDataCodewords puts data in byte mode and pads it to the version's data capacity.
+func qrDataCodewords(data []byte, version int) []byte {
+	capacity := qrVersionsM[version-1].dataCodewords()
+	var bits []bool
+	appendBits := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>i&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), qrCountBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	appendBits(0, min(4, capacity*8-len(bits)))
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// qrAddErrorCorrection splits data into blocks, adds each block's Reed-Solomon codewords and
+// interleaves the result.
+func qrAddErrorCorrection(data []byte, blocks qrBlocks) []byte {
+	var dataBlocks, ecBlocks [][]byte
+	for _, g := range blocks.groups {
+		for i := 0; i < g[0]; i++ {
+			block := data[:g[1]]
+			data = data[g[1]:]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, reedSolomon(block, blocks.ecPerBlock))
+		}
+	}
+
+	var result []byte
+	longest := dataBlocks[len(dataBlocks)-1]
+	for i := range longest {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(a, b byte) byte {
+	var product byte
+	for i := 7; i >= 0; i-- {
+		carry := product&0x80 != 0
+		product <<= 1
+		if carry {
+			product ^= 0x1D
+		}
+		if b>>i&1 == 1 {
+			product ^= a
+		}
+	}
+	return product
+}
+
+// reedSolomon returns the n error correction codewords of data.
+func reedSolomon(data []byte, n int) []byte {
+	// The generator polynomial (x - a^0)(x - a^1)...(x - a^(n-1)), highest coefficient dropped.
+	generator := make([]byte, n)
+	generator[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range generator {
+			generator[j] = gfMul(generator[j], root)
+			if j+1 < n {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+
+	remainder := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[n-1] = 0
+		for j := range remainder {
+			remainder[j] ^= gfMul(generator[j], factor)
+		}
+	}
+	return remainder
+}
+
+// newQRGrid draws the function patterns of a version, returning the symbol and which of its
+// modules are function modules.
+func newQRGrid(version int) (*qrCode, [][]bool) {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size)}
+	function := make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		function[i] = make([]bool, size)
+	}
+	set := func(row, col int, dark bool) {
+		q.modules[row][col] = dark
+		function[row][col] = true
+	}
+
+	for i := 0; i < size; i++ {
+		set(6, i, i%2 == 0)
+		set(i, 6, i%2 == 0)
+	}
+	for _, corner := range [][2]int{{3, 3}, {3, size - 4}, {size - 4, 3}} {
+		// A finder pattern with its light separator.
+		for dr := -4; dr <= 4; dr++ {
+			for dc := -4; dc <= 4; dc++ {
+				row, col := corner[0]+dr, corner[1]+dc
+				if row < 0 || row >= size || col < 0 || col >= size {
+					continue
+				}
+				dist := max(abs(dr), abs(dc))
+				set(row, col, dist != 2 && dist != 4)
+			}
+		}
+	}
+	centres := qrAlignment[version-1]
+	for i, row := range centres {
+		for j, col := range centres {
+			if (i == 0 && j == 0) || (i == 0 && j == len(centres)-1) || (i == len(centres)-1 && j == 0) {
+				continue // overlaps a finder pattern
+			}
+			for dr := -2; dr <= 2; dr++ {
+				for dc := -2; dc <= 2; dc++ {
+					set(row+dr, col+dc, max(abs(dr), abs(dc)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas; drawFormat fills them in once the mask is chosen.
+	for i := 0; i < 9; i++ {
+		if i != 6 { // the timing patterns cross here
+			set(8, i, false)
+			set(i, 8, false)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		set(8, size-1-i, false)
+		set(size-1-i, 8, false)
+	}
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := size-11+i%3, i/3
+			set(b, a, dark)
+			set(a, b, dark)
+		}
+	}
+	return q, function
+}
+
+// placeData lays the codewords out in the two-module wide zigzag from the bottom right corner.
+func (q *qrCode) placeData(codewords []byte, function [][]bool) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for col := right; col > right-2; col-- {
+				if function[row][col] {
+					continue
+				}
+				// Modules left over after the last codeword are remainder bits, always light.
+				if i < len(codewords)*8 {
+					q.modules[row][col] = codewords[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// qrMasks are the eight data mask patterns; a module is flipped where its mask is true.
+var qrMasks = []func(row, col int) bool{
+	func(r, c int) bool { return (r+c)%2 == 0 },
+	func(r, c int) bool { return r%2 == 0 },
+	func(r, c int) bool { return c%3 == 0 },
+	func(r, c int) bool { return (r+c)%3 == 0 },
+	func(r, c int) bool { return (r/2+c/3)%2 == 0 },
+	func(r, c int) bool { return r*c%2+r*c%3 == 0 },
+	func(r, c int) bool { return (r*c%2+r*c%3)%2 == 0 },
+	func(r, c int) bool { return ((r+c)%2+r*c%3)%2 == 0 },
+}
+
+func (q *qrCode) applyMask(mask int, function [][]bool) {
+	for row := range q.modules {
+		for col := range q.modules[row] {
+			if !function[row][col] && qrMasks[mask](row, col) {
+				q.modules[row][col] = !q.modules[row][col]
+			}
+		}
+	}
+}
+
+// drawFormat writes both copies of the format information: level M and the mask.
+func (q *qrCode) drawFormat(mask int) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.modules[i][8] = bit(i)
+	}
+	q.modules[7][8] = bit(6)
+	q.modules[8][8] = bit(7)
+	q.modules[8][7] = bit(8)
+	for i := 9; i < 15; i++ {
+		q.modules[8][14-i] = bit(i)
+	}
+	for i := 0; i < 8; i++ {
+		q.modules[8][q.size-1-i] = bit(i)
+	}
+	for i := 8; i < 15; i++ {
+		q.modules[q.size-15+i][8] = bit(i)
+	}
+	q.modules[q.size-8][8] = true // the dark module
+}
+
+// penalty scores how hard the symbol is to scan; newQRCode keeps the mask with the lowest score.
+func (q *qrCode) penalty() int {
+	score := 0
+	dark := 0
+	finderLike := func(line []bool, i int) bool {
+		// 1:1:3:1:1 dark:light:dark:light:dark with four light modules on one side.
+		pattern := []bool{true, false, true, true, true, false, true}
+		for j, want := range pattern {
+			if line[i+j] != want {
+				return false
+			}
+		}
+		lightRun := func(from, to int) bool {
+			for k := from; k < to; k++ {
+				if k >= 0 && k < len(line) && line[k] {
+					return false
+				}
+			}
+			return true
+		}
+		return lightRun(i-4, i) || lightRun(i+7, i+11)
+	}
+
+	for pass := 0; pass < 2; pass++ {
+		for a := 0; a < q.size; a++ {
+			line := make([]bool, q.size)
+			for b := 0; b < q.size; b++ {
+				if pass == 0 {
+					line[b] = q.modules[a][b]
+				} else {
+					line[b] = q.modules[b][a]
+				}
+			}
+			run := 1
+			for b := 1; b <= q.size; b++ {
+				if b < q.size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			for b := 0; b+7 <= q.size; b++ {
+				if finderLike(line, b) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.modules[row][col] {
+				dark++
+			}
+			if row+1 < q.size && col+1 < q.size {
+				c := q.modules[row][col]
+				if q.modules[row][col+1] == c && q.modules[row+1][col] == c && q.modules[row+1][col+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	score += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+	return score
+}
+
+// PNG renders the symbol with scale pixels per module and the standard four-module quiet zone.
+func (q *qrCode) PNG(scale int) ([]byte, error) {
+	const quietZone = 4
+	width := (q.size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for row := range q.modules {
+		for col, isDark := range q.modules[row] {
+			if !isDark {
+				continue
+			}
+			for y := 0; y < scale; y++ {
+				for x := 0; x < scale; x++ {
+					img.SetGray((col+quietZone)*scale+x, (row+quietZone)*scale+y, color.Gray{})
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
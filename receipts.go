@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReceiptIssuer is the business named on receipts, set with BUSINESS_NAME, BUSINESS_ADDRESS (lines
+// separated by commas), VAT_NUMBER and VAT_RATE (a percentage, included in ticket prices).
+type ReceiptIssuer struct {
+	Name      string
+	Address   string
+	VATNumber string
+	VATRate   float64
+}
+
+func getReceiptIssuer() ReceiptIssuer {
+	issuer := ReceiptIssuer{
+		Name:      os.Getenv("BUSINESS_NAME"),
+		Address:   os.Getenv("BUSINESS_ADDRESS"),
+		VATNumber: os.Getenv("VAT_NUMBER"),
+	}
+	if issuer.Name == "" {
+		issuer.Name = "Go Booking App"
+	}
+	if rate, err := strconv.ParseFloat(os.Getenv("VAT_RATE"), 64); err == nil && rate > 0 && rate < 100 {
+		issuer.VATRate = rate
+	}
+	return issuer
+}
+
+// includedVAT is the VAT contained in a price that includes it.
+func includedVAT(amount, rate float64) float64 {
+	return math.Round(amount*rate/(100+rate)*100) / 100
+}
+
+// receiptLine is one line of a receipt's table of charges.
+type receiptLine struct {
+	Description string
+	Quantity    int
+	UnitPrice   float64
+	Amount      float64
+}
+
+// receiptLines lists what a booking charged for: a line per ticket tier, or one for standard admission.
+func receiptLines(booking EventBooking) []receiptLine {
+	if len(booking.Items) == 0 {
+		unit := 0.0
+		if booking.NumberOfTickets > 0 {
+			unit = booking.OriginalAmount / float64(booking.NumberOfTickets)
+		}
+		return []receiptLine{{"Admission", booking.NumberOfTickets, unit, booking.OriginalAmount}}
+	}
+	lines := make([]receiptLine, 0, len(booking.Items))
+	for _, item := range booking.Items {
+		lines = append(lines, receiptLine{item.Name, item.Quantity, item.UnitPrice, float64(item.Quantity) * item.UnitPrice})
+	}
+	return lines
+}
+
+// bookingPDFFilename is the file name a booking's PDF is downloaded and attached as.
+func bookingPDFFilename(booking EventBooking) string {
+	return fmt.Sprintf("booking-%d.pdf", booking.ID)
+}
+
+// bookingPDF writes a confirmed booking's receipt, or its summary if nothing was paid, followed by a
+// printable page for each of its tickets.
+func bookingPDF(booking EventBooking, event Event) ([]byte, error) {
+	doc := newPDF(fmt.Sprintf("Booking #%d - %s", booking.ID, event.Name))
+	writeReceiptPage(doc.AddPage(), booking, event, getReceiptIssuer())
+	for _, ticket := range booking.Tickets {
+		qr, err := newQRCode([]byte(ticketPayload(event.ID, ticket)))
+		if err != nil {
+			return nil, err
+		}
+		writeTicketPage(doc.AddPage(), booking, event, ticket, qr)
+	}
+	return doc.Bytes()
+}
+
+const (
+	pdfMargin = 50.0
+	pdfRight  = pdfPageWidth - pdfMargin
+)
+
+// writeReceiptPage lays out the VAT receipt of a paid booking. Bookings nothing was paid for, such as
+// free ones and those sold at the desk, get the same summary without a VAT receipt's totals.
+func writeReceiptPage(page *pdfPage, booking EventBooking, event Event, issuer ReceiptIssuer) {
+	currency := strings.ToUpper(event.Currency)
+	money := func(amount float64) string { return fmt.Sprintf("%.2f %s", amount, currency) }
+	paid := booking.PaymentIntentID != "" && booking.TotalAmount > 0
+
+	y := pdfPageHeight - 70
+	title := "Receipt"
+	if !paid {
+		title = "Booking confirmation"
+	}
+	page.Text(pdfMargin, y, fontBold, 24, title)
+	page.TextRight(pdfRight, y+5, fontBold, 12, issuer.Name)
+	for _, line := range strings.Split(issuer.Address, ",") {
+		if line = strings.TrimSpace(line); line != "" {
+			y -= 14
+			page.TextRight(pdfRight, y+5, fontRegular, 10, line)
+		}
+	}
+	if issuer.VATNumber != "" {
+		y -= 14
+		page.TextRight(pdfRight, y+5, fontRegular, 10, "VAT number: "+issuer.VATNumber)
+	}
+
+	y -= 40
+	payment := [2]string{"Payment reference", booking.PaymentIntentID}
+	if !paid {
+		payment = [2]string{"Payment", "none taken"}
+	}
+	for _, field := range [][2]string{
+		{"Booking", "#" + strconv.Itoa(booking.ID)},
+		{"Date", booking.BookingDate.Local().Format("Jan 2, 2006")},
+		payment,
+	} {
+		page.Text(pdfMargin, y, fontBold, 11, field[0])
+		page.Text(pdfMargin+120, y, fontRegular, 11, field[1])
+		y -= 16
+	}
+
+	y -= 20
+	customer := "Billed to"
+	if !paid {
+		customer = "Booked by"
+	}
+	page.Text(pdfMargin, y, fontBold, 11, customer)
+	page.Text(pdfMargin+120, y, fontRegular, 11, booking.FirstName+" "+booking.LastName)
+	page.Text(pdfMargin+120, y-16, fontRegular, 11, booking.Email)
+
+	y -= 52
+	page.Text(pdfMargin, y, fontBold, 11, "Event")
+	page.Text(pdfMargin+120, y, fontRegular, 11, event.Name)
+	page.Text(pdfMargin+120, y-16, fontRegular, 11, event.Date.Format("Monday, Jan 2, 2006 15:04"))
+	page.Text(pdfMargin+120, y-32, fontRegular, 11, event.Location)
+	y -= 48
+	description := wrapText(fontRegular, 9, pdfRight-pdfMargin-120, event.Description)
+	for i, line := range description {
+		if i == 4 {
+			break
+		}
+		page.Text(pdfMargin+120, y, fontRegular, 9, line)
+		y -= 12
+	}
+
+	y -= 30
+	columns := []float64{pdfRight - 220, pdfRight - 110, pdfRight}
+	page.Text(pdfMargin, y, fontBold, 10, "Description")
+	for i, heading := range []string{"Quantity", "Unit price", "Amount"} {
+		page.TextRight(columns[i], y, fontBold, 10, heading)
+	}
+	page.Line(pdfMargin, y-6, pdfRight, y-6)
+	y -= 22
+	for _, line := range receiptLines(booking) {
+		page.Text(pdfMargin, y, fontRegular, 10, line.Description)
+		page.TextRight(columns[0], y, fontRegular, 10, strconv.Itoa(line.Quantity))
+		page.TextRight(columns[1], y, fontRegular, 10, money(line.UnitPrice))
+		page.TextRight(columns[2], y, fontRegular, 10, money(line.Amount))
+		y -= 16
+	}
+	if booking.DiscountAmount > 0 {
+		page.Text(pdfMargin, y, fontRegular, 10, "Discount ("+booking.DiscountCode+")")
+		page.TextRight(columns[2], y, fontRegular, 10, money(-booking.DiscountAmount))
+		y -= 16
+	}
+	page.Line(pdfMargin, y+10, pdfRight, y+10)
+
+	if !paid {
+		page.TextRight(columns[1], y-10, fontBold, 12, "Total")
+		page.TextRight(columns[2], y-10, fontBold, 12, money(booking.TotalAmount))
+		page.Text(pdfMargin, pdfMargin, fontRegular, 9, "No payment was taken for this booking; this is not a VAT receipt. Your tickets follow.")
+		return
+	}
+	vat := includedVAT(booking.TotalAmount, issuer.VATRate)
+	y -= 8
+	for _, total := range []struct {
+		label  string
+		amount float64
+	}{
+		{"Total excluding VAT", booking.TotalAmount - vat},
+		{fmt.Sprintf("VAT %s%%", strconv.FormatFloat(issuer.VATRate, 'f', -1, 64)), vat},
+	} {
+		page.TextRight(columns[1], y, fontRegular, 10, total.label)
+		page.TextRight(columns[2], y, fontRegular, 10, money(total.amount))
+		y -= 16
+	}
+	page.TextRight(columns[1], y-2, fontBold, 12, "Total paid")
+	page.TextRight(columns[2], y-2, fontBold, 12, money(booking.TotalAmount))
+
+	page.Text(pdfMargin, pdfMargin, fontRegular, 9, "Prices include VAT. Your tickets follow on the next pages.")
+}
+
+// writeTicketPage lays out one ticket to print and bring to the door.
+func writeTicketPage(page *pdfPage, booking EventBooking, event Event, ticket Ticket, qr *qrCode) {
+	const width = 380.0
+	left := (pdfPageWidth - width) / 2
+	center := pdfPageWidth / 2
+	top := pdfPageHeight - 60.0
+
+	y := top - 50
+	for _, line := range wrapText(fontBold, 22, width-40, event.Name) {
+		page.TextCenter(center, y, fontBold, 22, line)
+		y -= 26
+	}
+	page.TextCenter(center, y-4, fontRegular, 12, event.Date.Format("Monday, Jan 2, 2006 15:04"))
+	page.TextCenter(center, y-20, fontRegular, 12, event.Location)
+
+	y -= 60
+	page.TextCenter(center, y, fontBold, 16, booking.FirstName+" "+booking.LastName)
+	page.TextCenter(center, y-20, fontRegular, 12, ticketDescription(booking, ticket))
+	page.TextCenter(center, y-38, fontRegular, 10,
+		fmt.Sprintf("Ticket %d of %d - booking #%d", ticket.Number, len(booking.Tickets), booking.ID))
+
+	const qrSize = 220.0
+	y -= 70 + qrSize
+	page.QRCode(center-qrSize/2, y, qrSize, qr)
+	page.TextCenter(center, y-30, fontMono, 16, ticket.ID)
+
+	bottom := y - 60
+	page.Box(left, bottom, width, top-bottom)
+	page.TextCenter(center, bottom-20, fontRegular, 9, "Show this ticket at the door, printed or on your phone. Each ticket admits one person once.")
+}
+
+// viewableBookingPDF writes the PDF of the {id} booking if the signed-in user may see it and it is
+// confirmed; ok is false if there is none to give.
+func viewableBookingPDF(r *http.Request) (pdf []byte, booking EventBooking, ok bool, err error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, EventBooking{}, false, nil
+	}
+	booking, err = store.GetBooking(id)
+	user, _ := currentUser(r)
+	if err != nil || !canSeeBooking(user, booking) || booking.Status != "confirmed" {
+		return nil, EventBooking{}, false, nil
+	}
+	event, err := store.GetEvent(booking.EventID)
+	if err != nil {
+		return nil, EventBooking{}, false, err
+	}
+	pdf, err = bookingPDF(booking, event)
+	if err != nil {
+		return nil, EventBooking{}, false, err
+	}
+	return pdf, booking, true, nil
+}
+
+// writePDF sends a PDF as a download.
+func writePDF(w http.ResponseWriter, filename string, pdf []byte) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(pdf)
+}
+
+// bookingPDFHandler downloads a confirmed booking's receipt and tickets as a PDF.
+func bookingPDFHandler(w http.ResponseWriter, r *http.Request) {
+	pdf, booking, ok, err := viewableBookingPDF(r)
+	if err != nil {
+		http.Error(w, "Failed to write PDF", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writePDF(w, bookingPDFFilename(booking), pdf)
+}
+
+func apiBookingPDFHandler(w http.ResponseWriter, r *http.Request) {
+	pdf, booking, ok, err := viewableBookingPDF(r)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to write PDF")
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "not_found", "no confirmed booking with this id")
+		return
+	}
+	writePDF(w, bookingPDFFilename(booking), pdf)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// User roles, from least to most privileged.
+const (
+	roleCustomer  = "customer"
+	roleStaff     = "staff" // door staff, who check tickets in
+	roleOrganizer = "organizer"
+	roleAdmin     = "admin"
+)
+
+// Permission names an action a route can require.
+type Permission string
+
+const (
+	permViewAllBookings Permission = "bookings:view-all"
+	permManageEvents    Permission = "events:manage"
+	permManageUsers     Permission = "users:manage"
+	permCheckIn         Permission = "tickets:check-in"
+)
+
+// rolePermissions lists what each role may do beyond managing its own bookings.
+var rolePermissions = map[string][]Permission{
+	roleCustomer:  {},
+	roleStaff:     {permCheckIn},
+	roleOrganizer: {permViewAllBookings, permManageEvents, permCheckIn},
+	roleAdmin:     {permViewAllBookings, permManageEvents, permManageUsers, permCheckIn},
+}
+
+// validRole reports whether role is one of the known roles.
+func validRole(role string) bool {
+	_, exists := rolePermissions[role]
+	return exists
+}
+
+// Can reports whether the user's role grants permission.
+func (u User) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[u.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermissionMiddleware lets signed-in users whose role grants permission through, and
+// answers 403 Forbidden for everyone else.
+func requirePermissionMiddleware(permission Permission, next http.HandlerFunc) http.HandlerFunc {
+	return requireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		if !user.Can(permission) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// createAdmin bootstraps an administrator, registering the account if needed or promoting an existing one.
+func createAdmin(username, email, password string) error {
+	user, err := store.GetUserByUsername(username)
+	if errors.Is(err, errNotFound) {
+		if err := registerUser(username, email, password); err != nil {
+			return err
+		}
+		user, err = store.GetUserByUsername(username)
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.SetEmailVerified(user.ID); err != nil {
+		return err
+	}
+	return store.SetUserRole(user.ID, roleAdmin)
+}
+
+// adminUsersHandler lists every account and lets an admin change their roles.
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	admin, _ := currentUser(r)
+
+	if r.Method == "POST" {
+		userID, err := strconv.Atoi(r.FormValue("user_id"))
+		role := r.FormValue("role")
+		switch {
+		case err != nil || !validRole(role):
+			http.Redirect(w, r, "/admin/users?error=Invalid role change", http.StatusSeeOther)
+			return
+		case userID == admin.ID:
+			http.Redirect(w, r, "/admin/users?error=You cannot change your own role", http.StatusSeeOther)
+			return
+		}
+		if err := store.SetUserRole(userID, role); err != nil {
+			http.Redirect(w, r, "/admin/users?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+		message := fmt.Sprintf("User %d is now %s", userID, role)
+		http.Redirect(w, r, "/admin/users?message="+url.QueryEscape(message), http.StatusSeeOther)
+		return
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Users</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        .error { color: red; }
+        .success { color: green; }
+    </style>
+</head>
+<body>
+    <h1>Users</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    <table>
+        <tr>
+            <th>#</th>
+            <th>Username</th>
+            <th>Email</th>
+            <th>Role</th>
+        </tr>
+        {{range .Users}}
+        <tr>
+            <td>{{.ID}}</td>
+            <td>{{.Username}}</td>
+            <td>{{.Email}}</td>
+            <td>
+                <form method="POST" action="/admin/users">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="user_id" value="{{.ID}}">
+                    <select name="role">
+                        {{$role := .Role}}
+                        {{range $.Roles}}<option value="{{.}}" {{if eq . $role}}selected{{end}}>{{.}}</option>{{end}}
+                    </select>
+                    <button type="submit">Save</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    <p><a href="/">Back to Booking</a></p>
+</body>
+</html>`
+
+	users, err := store.ListUsers()
+	if err != nil {
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+
+	t, _ := template.New("admin-users").Parse(tmpl)
+	data := struct {
+		Users     []User
+		Roles     []string
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Users:     users,
+		Roles:     []string{roleCustomer, roleStaff, roleOrganizer, roleAdmin},
+		Message:   r.URL.Query().Get("message"),
+		Error:     r.URL.Query().Get("error"),
+		CSRFToken: csrfToken(w, r),
+	}
+	t.Execute(w, data)
+}
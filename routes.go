@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// httpRoute is one method and path served by the web app. The route tables both register the
+// handlers and generate the OpenAPI document, so the two cannot disagree.
+type httpRoute struct {
+	Method     string
+	Path       string // http.ServeMux pattern path, e.g. /api/v1/events/{id}
+	Summary    string
+	Auth       bool       // requires a signed-in user
+	Permission Permission // required on top of Auth, if set
+	CSRF       bool       // web routes: the request must carry a CSRF token
+	Scope      string     // API routes: scope an API key needs; routes needing a user but no scope are session-only
+	Query      []routeParam
+	Form       []string    // form-encoded body fields
+	Request    interface{} // JSON body, as a value of its Go type; set by jsonRoute
+	Response   interface{} // JSON response, as a value of its Go type; set by jsonRoute, nil for HTML pages and redirects
+	Produces   string      // media type of a file response, such as image/png
+	Status     int         // success status; 0 means 200 OK
+	Handler    http.HandlerFunc
+	wraps      interface{} // the function Handler calls, set by jsonRoute; names the operation
+}
+
+// routeParam is a query string parameter.
+type routeParam struct {
+	Name        string
+	Type        string // OpenAPI type: string or integer
+	Description string
+}
+
+// noBody is the request type of a JSON route that takes no body.
+type noBody struct{}
+
+// jsonBody reads a handler's JSON request as a Req and writes its response as a Resp. Routes built with
+// jsonRoute document the Req and Resp of their handler's jsonBody, so the OpenAPI document describes the
+// types each handler really decodes and encodes.
+type jsonBody[Req, Resp any] struct{}
+
+// decode reads the request body into a Req, answering 400 if it is not one.
+func (jsonBody[Req, Resp]) decode(w http.ResponseWriter, r *http.Request) (Req, bool) {
+	var req Req
+	return req, decodeJSON(w, r, &req)
+}
+
+// read reads the request body into a Req, for handlers that report a bad body their own way.
+func (jsonBody[Req, Resp]) read(r *http.Request) (Req, error) {
+	var req Req
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// write answers with resp.
+func (jsonBody[Req, Resp]) write(w http.ResponseWriter, status int, resp Resp) {
+	writeJSON(w, status, resp)
+}
+
+// jsonRoute completes route with a handler that reads and writes JSON through its jsonBody, taking the
+// route's Request and Response from the handler's signature.
+func jsonRoute[Req, Resp any](route httpRoute, handler func(http.ResponseWriter, *http.Request, jsonBody[Req, Resp])) httpRoute {
+	var req Req
+	var resp Resp
+	if _, none := any(req).(noBody); !none {
+		route.Request = req
+	}
+	route.Response = resp
+	route.Handler = func(w http.ResponseWriter, r *http.Request) { handler(w, r, jsonBody[Req, Resp]{}) }
+	route.wraps = handler
+	return route
+}
+
+// eventFormFields are the fields of the organizer's event form.
+var eventFormFields = []string{"name", "description", "date", "location", "total_tickets", "ticket_price", "currency", "active"}
+
+// ticketTypeFormFields are the fields of the organizer's ticket type forms.
+var ticketTypeFormFields = []string{"name", "price", "quota", "sales_start", "sales_end", "min_per_order", "max_per_order"}
+
+// waitlistFormFields are the fields of the form to join an event's waitlist.
+var waitlistFormFields = []string{"firstName", "lastName", "email", "ticket_type_id", "tickets"}
+
+// discountFormFields are the fields of the organizer's new discount code form.
+var discountFormFields = []string{"code", "kind", "value", "event_ids", "ticket_type_ids", "max_uses", "max_uses_per_user", "valid_from", "valid_until"}
+
+// webRoutes are the HTML pages, form posts and browser JSON endpoints.
+var webRoutes = []httpRoute{
+	{Method: "GET", Path: "/{$}", Summary: "Home page for the default event", Handler: homeHandler},
+	{Method: "POST", Path: "/book", Summary: "Book tickets for the default event", Auth: true, CSRF: true,
+		Form: []string{"firstName", "lastName", "email", "tickets"}, Status: http.StatusSeeOther, Handler: bookHandler},
+	{Method: "GET", Path: "/bookings", Summary: "Bookings for the default event", Auth: true, Handler: bookingsHandler},
+	{Method: "GET", Path: "/events", Summary: "List of events", Handler: eventsListHandler},
+	{Method: "POST", Path: "/book-event/{id}", Summary: "Book tickets for an event", Auth: true, CSRF: true,
+		Form: []string{"firstName", "lastName", "email", "tickets", "seat_ids", "discount_code"}, Status: http.StatusSeeOther, Handler: bookEventHandler},
+	{Method: "GET", Path: "/payment", Summary: "Card payment page", Auth: true,
+		Query: []routeParam{{Name: "event_id", Type: "integer", Description: "Event to pay for"},
+			{Name: "booking_id", Type: "integer", Description: "Held booking to pay for, instead of choosing tickets"}}, Handler: paymentPageHandler},
+	jsonRoute(httpRoute{Method: "POST", Path: "/create-payment-intent", Summary: "Hold tickets and start a payment", Auth: true, CSRF: true},
+		paymentHandler),
+	jsonRoute(httpRoute{Method: "POST", Path: "/confirm-payment", Summary: "Charge a payment intent server-side", Auth: true, CSRF: true},
+		confirmPaymentHandler),
+	{Method: "GET", Path: "/booking-success", Summary: "Confirm a booking after payment", Auth: true,
+		Query: []routeParam{{Name: "payment_intent", Type: "string", Description: "Payment intent ID"}}, Handler: bookingSuccessHandler},
+	{Method: "POST", Path: "/stripe/webhook", Summary: "Payment provider webhook, verified by the Stripe-Signature header",
+		Handler: stripeWebhookHandler},
+	{Method: "GET", Path: "/my-bookings", Summary: "The signed-in user's bookings", Auth: true, Handler: myBookingsHandler},
+	{Method: "GET", Path: "/checkin", Summary: "Events to check tickets in for", Auth: true, Permission: permCheckIn, Handler: checkInEventsHandler},
+	{Method: "GET", Path: "/checkin/{id}", Summary: "Ticket scanning page for an event", Auth: true, Permission: permCheckIn,
+		Handler: checkInHandler},
+	{Method: "POST", Path: "/checkin/{id}", Summary: "Check in a scanned ticket", Auth: true, Permission: permCheckIn, CSRF: true,
+		Form: []string{"code", "gate"}, Status: http.StatusSeeOther, Handler: checkInHandler},
+	{Method: "GET", Path: "/tickets/{id}", Summary: "A ticket with its QR code", Auth: true, Handler: ticketHandler},
+	{Method: "GET", Path: "/tickets/{id}/qr.png", Summary: "The QR code of a confirmed ticket", Auth: true, Produces: "image/png",
+		Handler: ticketQRHandler},
+	{Method: "GET", Path: "/bookings/{id}/pdf", Summary: "A confirmed booking's receipt and tickets as a PDF", Auth: true,
+		Produces: "application/pdf", Handler: bookingPDFHandler},
+	{Method: "POST", Path: "/cancel-booking/{id}", Summary: "Cancel a booking; send Accept: application/json for a JSON reply",
+		Auth: true, CSRF: true, Status: http.StatusSeeOther, Handler: cancelBookingHandler},
+	{Method: "GET", Path: "/waitlist", Summary: "The signed-in user's waitlists and offers", Auth: true, Handler: waitlistHandler},
+	{Method: "GET", Path: "/events/{id}/waitlist", Summary: "Form to join an event's waitlist", Auth: true, Handler: joinWaitlistHandler},
+	{Method: "POST", Path: "/events/{id}/waitlist", Summary: "Join an event's waitlist", Auth: true, CSRF: true,
+		Form: waitlistFormFields, Status: http.StatusSeeOther, Handler: joinWaitlistHandler},
+	{Method: "POST", Path: "/waitlist/{id}/claim", Summary: "Book the tickets held by a waitlist offer", Auth: true, CSRF: true,
+		Status: http.StatusSeeOther, Handler: claimWaitlistHandler},
+	{Method: "POST", Path: "/waitlist/{id}/leave", Summary: "Leave a waitlist, declining any offer", Auth: true, CSRF: true,
+		Status: http.StatusSeeOther, Handler: leaveWaitlistHandler},
+
+	{Method: "GET", Path: "/account/api-keys", Summary: "The signed-in user's API keys", Auth: true, Handler: apiKeysHandler},
+	{Method: "POST", Path: "/account/api-keys", Summary: "Create an API key; the page shows it once", Auth: true, CSRF: true,
+		Form: []string{"name", "scopes"}, Handler: apiKeysHandler},
+	{Method: "POST", Path: "/account/api-keys/{id}/revoke", Summary: "Revoke an API key", Auth: true, CSRF: true,
+		Status: http.StatusSeeOther, Handler: revokeAPIKeyHandler},
+
+	{Method: "GET", Path: "/login", Summary: "Login form", Handler: authLoginHandler},
+	{Method: "POST", Path: "/login", Summary: "Sign in", CSRF: true,
+		Form: []string{"username", "password"}, Status: http.StatusSeeOther, Handler: authLoginHandler},
+	{Method: "GET", Path: "/register", Summary: "Registration form", Handler: authRegisterHandler},
+	{Method: "POST", Path: "/register", Summary: "Create an account", CSRF: true,
+		Form: []string{"username", "email", "password"}, Status: http.StatusSeeOther, Handler: authRegisterHandler},
+	{Method: "POST", Path: "/logout", Summary: "Sign out", CSRF: true, Status: http.StatusSeeOther, Handler: logoutHandler},
+	{Method: "POST", Path: "/logout-all", Summary: "Sign out everywhere", Auth: true, CSRF: true,
+		Status: http.StatusSeeOther, Handler: logoutAllHandler},
+	{Method: "GET", Path: "/verify", Summary: "Verify an email address",
+		Query:  []routeParam{{Name: "token", Type: "string", Description: "Token from the verification email"}},
+		Status: http.StatusSeeOther, Handler: verifyHandler},
+	{Method: "POST", Path: "/resend-verification", Summary: "Send a new verification email", Auth: true, CSRF: true,
+		Status: http.StatusSeeOther, Handler: resendVerificationHandler},
+	{Method: "GET", Path: "/forgot-password", Summary: "Forgotten password form", Handler: forgotPasswordHandler},
+	{Method: "POST", Path: "/forgot-password", Summary: "Email a password reset link", CSRF: true,
+		Form: []string{"username"}, Status: http.StatusSeeOther, Handler: forgotPasswordHandler},
+	{Method: "GET", Path: "/reset-password", Summary: "New password form",
+		Query: []routeParam{{Name: "token", Type: "string", Description: "Token from the reset email"}}, Handler: resetPasswordHandler},
+	{Method: "POST", Path: "/reset-password", Summary: "Set a new password", CSRF: true,
+		Form: []string{"token", "password"}, Status: http.StatusSeeOther, Handler: resetPasswordHandler},
+
+	{Method: "GET", Path: "/organizer/events", Summary: "All events, published or not, for organizers", Auth: true, Permission: permManageEvents,
+		Handler: organizerEventsHandler},
+	{Method: "GET", Path: "/organizer/events/new", Summary: "New event form", Auth: true, Permission: permManageEvents,
+		Handler: newEventHandler},
+	{Method: "POST", Path: "/organizer/events", Summary: "Create an event", Auth: true, Permission: permManageEvents, CSRF: true,
+		Form: eventFormFields, Status: http.StatusSeeOther, Handler: createEventHandler},
+	{Method: "GET", Path: "/organizer/events/{id}/edit", Summary: "Edit event form", Auth: true, Permission: permManageEvents,
+		Handler: editEventHandler},
+	{Method: "POST", Path: "/organizer/events/{id}", Summary: "Save changes to an event", Auth: true, Permission: permManageEvents, CSRF: true,
+		Form: eventFormFields, Status: http.StatusSeeOther, Handler: updateEventHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/publish", Summary: "Make an event bookable", Auth: true, Permission: permManageEvents, CSRF: true,
+		Status: http.StatusSeeOther, Handler: publishEventHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/unpublish", Summary: "Hide an event from customers", Auth: true, Permission: permManageEvents, CSRF: true,
+		Status: http.StatusSeeOther, Handler: unpublishEventHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/delete", Summary: "Delete an event that has no bookings", Auth: true, Permission: permManageEvents, CSRF: true,
+		Status: http.StatusSeeOther, Handler: deleteEventHandler},
+	{Method: "GET", Path: "/organizer/events/{id}/ticket-types", Summary: "An event's ticket tiers", Auth: true, Permission: permManageEvents,
+		Handler: ticketTypesHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/ticket-types", Summary: "Add a ticket tier", Auth: true, Permission: permManageEvents, CSRF: true,
+		Form: ticketTypeFormFields, Status: http.StatusSeeOther, Handler: createTicketTypeHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/ticket-types/{typeID}", Summary: "Save changes to a ticket tier", Auth: true, Permission: permManageEvents, CSRF: true,
+		Form: ticketTypeFormFields, Status: http.StatusSeeOther, Handler: updateTicketTypeHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/ticket-types/{typeID}/delete", Summary: "Delete a ticket tier that has no bookings", Auth: true, Permission: permManageEvents, CSRF: true,
+		Status: http.StatusSeeOther, Handler: deleteTicketTypeHandler},
+	{Method: "GET", Path: "/organizer/events/{id}/seats", Summary: "An event's seat map", Auth: true, Permission: permManageEvents,
+		Handler: seatMapHandler},
+	{Method: "POST", Path: "/organizer/events/{id}/seats", Summary: "Replace an event's seat map", Auth: true, Permission: permManageEvents, CSRF: true,
+		Form: []string{"layout"}, Status: http.StatusSeeOther, Handler: seatMapHandler},
+	{Method: "GET", Path: "/organizer/discounts", Summary: "Discount codes and their uses", Auth: true, Permission: permManageEvents,
+		Handler: discountCodesHandler},
+	{Method: "POST", Path: "/organizer/discounts", Summary: "Create a discount code", Auth: true, Permission: permManageEvents, CSRF: true,
+		Form: discountFormFields, Status: http.StatusSeeOther, Handler: discountCodesHandler},
+	{Method: "POST", Path: "/organizer/discounts/{id}/enable", Summary: "Put a discount code back in use", Auth: true, Permission: permManageEvents, CSRF: true,
+		Status: http.StatusSeeOther, Handler: enableDiscountCodeHandler},
+	{Method: "POST", Path: "/organizer/discounts/{id}/disable", Summary: "Withdraw a discount code", Auth: true, Permission: permManageEvents, CSRF: true,
+		Status: http.StatusSeeOther, Handler: disableDiscountCodeHandler},
+
+	{Method: "GET", Path: "/admin/users", Summary: "User list", Auth: true, Permission: permManageUsers, Handler: adminUsersHandler},
+	{Method: "POST", Path: "/admin/users", Summary: "Change a user's role", Auth: true, Permission: permManageUsers, CSRF: true,
+		Form: []string{"user_id", "role"}, Status: http.StatusSeeOther, Handler: adminUsersHandler},
+	{Method: "GET", Path: "/admin/login-attempts", Summary: "Login audit log", Auth: true, Permission: permManageUsers,
+		Handler: adminLoginAttemptsHandler},
+}
+
+// registerWebRoutes mounts webRoutes on the default mux, wrapped in the middleware each one asks for.
+func registerWebRoutes() {
+	for _, route := range webRoutes {
+		handler := route.Handler
+		switch {
+		case route.Permission != "":
+			handler = requirePermissionMiddleware(route.Permission, handler)
+		case route.Auth:
+			handler = requireAuthMiddleware(handler)
+		}
+		if route.CSRF {
+			handler = csrfMiddleware(handler)
+		}
+		http.HandleFunc(route.Method+" "+route.Path, handler)
+	}
+}
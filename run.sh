@@ -4,6 +4,9 @@
 
 export PATH=$PATH:/usr/local/go/bin
 
+# Files shared by every mode; each mode adds its own entry point
+SHARED_FILES=$(ls *.go | grep -v -E '^(app|main|main_enhanced|main_single|main_simple_web)\.go$' | grep -v '_test\.go$')
+
 echo "🎫 Go Booking App Runner"
 echo "========================"
 echo ""
@@ -21,22 +24,22 @@ case $choice in
         echo "📍 Open your browser to: http://localhost:8080"
         echo "⏹️  Press Ctrl+C to stop the server"
         echo ""
-        go run app.go simple-web
+        go run app.go $SHARED_FILES simple-web
         ;;
     2)
         echo ""
         echo "🚀 Starting CLI Mode..."
         echo ""
-        go run app.go
+        go run app.go $SHARED_FILES
         ;;
     3)
         echo ""
         echo "🚀 Starting Enhanced Web Mode..."
         echo "📍 Open your browser to: http://localhost:8080"
         echo "⏹️  Press Ctrl+C to stop the server"
-        echo "⚠️  Note: This requires database and other dependencies"
+        echo "⚠️  Note: This requires email and Stripe configuration"
         echo ""
-        go run *.go -web
+        go run main_enhanced.go $SHARED_FILES -web
         ;;
     *)
         echo "Invalid choice. Please run the script again."
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Seat is a numbered seat at an event with assigned seating. Events with a seat map sell exactly one
+// seat per ticket, and their capacity is the number of seats.
+type Seat struct {
+	ID         int    `json:"id"`
+	EventID    int    `json:"event_id"`
+	Section    string `json:"section"`
+	Row        string `json:"row"`
+	Number     int    `json:"number"`
+	Accessible bool   `json:"accessible"` // wheelchair space or step-free access
+	Taken      bool   `json:"taken"`
+}
+
+// Label names the seat for tickets and messages.
+func (s Seat) Label() string {
+	return fmt.Sprintf("%s, row %s, seat %d", s.Section, s.Row, s.Number)
+}
+
+// SeatRow lays out one row of a seat map, with seats numbered from 1.
+type SeatRow struct {
+	Section    string `json:"section"`
+	Row        string `json:"row"`
+	Seats      int    `json:"seats"`
+	Accessible []int  `json:"accessible,omitempty"` // numbers of the row's accessible seats
+}
+
+// Seat map limits.
+const (
+	maxSeatsPerRow   = 200
+	maxSeatsPerEvent = 10000
+)
+
+// errSeatMapLocked is returned when changing the seat map of an event that has been booked.
+var errSeatMapLocked = errors.New("the seat map cannot change once the event has bookings")
+
+// layoutSeats turns the rows of a seat map into the event's seats, in the order they are listed.
+func layoutSeats(eventID int, rows []SeatRow) ([]Seat, error) {
+	var seats []Seat
+	seen := map[string]bool{}
+	for _, row := range rows {
+		row.Section = strings.TrimSpace(row.Section)
+		row.Row = strings.TrimSpace(row.Row)
+		key := row.Section + "\x00" + row.Row
+		switch {
+		case row.Section == "" || len(row.Section) > 50:
+			return nil, validationError("section must be between 1 and 50 characters")
+		case row.Row == "" || len(row.Row) > 10:
+			return nil, validationError("row must be between 1 and 10 characters")
+		case row.Seats < 1 || row.Seats > maxSeatsPerRow:
+			return nil, validationError(fmt.Sprintf("a row must have between 1 and %d seats", maxSeatsPerRow))
+		case seen[key]:
+			return nil, validationError(fmt.Sprintf("%s row %s is listed twice", row.Section, row.Row))
+		}
+		seen[key] = true
+
+		accessible := map[int]bool{}
+		for _, number := range row.Accessible {
+			if number < 1 || number > row.Seats {
+				return nil, validationError(fmt.Sprintf("%s row %s has no seat %d", row.Section, row.Row, number))
+			}
+			accessible[number] = true
+		}
+		for number := 1; number <= row.Seats; number++ {
+			seats = append(seats, Seat{EventID: eventID, Section: row.Section, Row: row.Row, Number: number, Accessible: accessible[number]})
+		}
+	}
+	if len(seats) > maxSeatsPerEvent {
+		return nil, validationError(fmt.Sprintf("an event can have at most %d seats", maxSeatsPerEvent))
+	}
+	return seats, nil
+}
+
+// setSeatMap replaces an event's seats and makes its capacity the number of seats. An empty map
+// turns assigned seating off and keeps the capacity.
+func setSeatMap(eventID int, rows []SeatRow) (Event, error) {
+	seats, err := layoutSeats(eventID, rows)
+	if err != nil {
+		return Event{}, err
+	}
+	event, err := store.SetSeatMap(eventID, seats)
+	if err != nil {
+		return Event{}, err
+	}
+	reloadDefaultEvent(eventID)
+	offerFreedTickets(eventID)
+	return event, nil
+}
+
+// seatRows describes an event's seats as the rows they were laid out from.
+func seatRows(seats []Seat) []SeatRow {
+	var rows []SeatRow
+	for _, seat := range seats {
+		if len(rows) == 0 || rows[len(rows)-1].Section != seat.Section || rows[len(rows)-1].Row != seat.Row {
+			rows = append(rows, SeatRow{Section: seat.Section, Row: seat.Row})
+		}
+		row := &rows[len(rows)-1]
+		row.Seats = max(row.Seats, seat.Number)
+		if seat.Accessible {
+			row.Accessible = append(row.Accessible, seat.Number)
+		}
+	}
+	return rows
+}
+
+// parseSeatRows reads the organizer's seat map form: one row per line as
+// "section, row, seats[, accessible seat numbers separated by spaces]".
+func parseSeatRows(text string) ([]SeatRow, error) {
+	var rows []SeatRow
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, validationError(fmt.Sprintf("line %d: expected section, row, seats[, accessible seats]", i+1))
+		}
+		seats, err := strconv.Atoi(strings.TrimSpace(fields[2]))
+		if err != nil {
+			return nil, validationError(fmt.Sprintf("line %d: seats must be a number", i+1))
+		}
+		row := SeatRow{Section: strings.TrimSpace(fields[0]), Row: strings.TrimSpace(fields[1]), Seats: seats}
+		if len(fields) == 4 {
+			for _, field := range strings.Fields(fields[3]) {
+				number, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, validationError(fmt.Sprintf("line %d: accessible seats must be numbers", i+1))
+				}
+				row.Accessible = append(row.Accessible, number)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// formatSeatRows writes rows in the format parseSeatRows reads.
+func formatSeatRows(rows []SeatRow) string {
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%s, %s, %d", row.Section, row.Row, row.Seats)
+		if len(row.Accessible) > 0 {
+			numbers := make([]string, len(row.Accessible))
+			for i, number := range row.Accessible {
+				numbers[i] = strconv.Itoa(number)
+			}
+			fmt.Fprintf(&b, ", %s", strings.Join(numbers, " "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// chooseSeats puts the seats the customer picked on a priced booking. Without picks, the store gives
+// seated events the best seats available when it reserves the tickets.
+func chooseSeats(event Event, booking *EventBooking, seatIDs []int) error {
+	if len(seatIDs) == 0 {
+		return nil
+	}
+	seats, err := store.ListSeats(event.ID)
+	if err != nil {
+		return err
+	}
+	if len(seats) == 0 {
+		return validationError("this event does not have assigned seating")
+	}
+	if len(seatIDs) != booking.NumberOfTickets {
+		return validationError(fmt.Sprintf("choose %d seats, one for each ticket", booking.NumberOfTickets))
+	}
+
+	byID := make(map[int]Seat, len(seats))
+	for _, seat := range seats {
+		byID[seat.ID] = seat
+	}
+	booking.Seats = make([]Seat, 0, len(seatIDs))
+	for _, id := range seatIDs {
+		seat, exists := byID[id]
+		switch {
+		case !exists:
+			return validationError(fmt.Sprintf("seat %d is not at this event", id))
+		case containsID(seatIDs[:len(booking.Seats)], id):
+			return validationError("each seat can only be chosen once")
+		case seat.Taken:
+			return fmt.Errorf("%w: %s is taken", errSoldOut, seat.Label())
+		}
+		booking.Seats = append(booking.Seats, seat)
+	}
+	return nil
+}
+
+// formSeatIDs reads the seats ticked in the booking form's seat picker.
+func formSeatIDs(r *http.Request) ([]int, error) {
+	r.ParseForm()
+	var ids []int
+	for _, value := range r.Form["seat_ids"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// seatSection is a section of the seat picker, with its seats by row.
+type seatSection struct {
+	Name string
+	Rows []seatPickerRow
+}
+
+type seatPickerRow struct {
+	Name  string
+	Seats []Seat
+}
+
+// seatSections groups seats by section and row, in layout order, for the seat picker.
+func seatSections(seats []Seat) []seatSection {
+	var sections []seatSection
+	for _, seat := range seats {
+		if len(sections) == 0 || sections[len(sections)-1].Name != seat.Section {
+			sections = append(sections, seatSection{Name: seat.Section})
+		}
+		section := &sections[len(sections)-1]
+		if len(section.Rows) == 0 || section.Rows[len(section.Rows)-1].Name != seat.Row {
+			section.Rows = append(section.Rows, seatPickerRow{Name: seat.Row})
+		}
+		row := &section.Rows[len(section.Rows)-1]
+		row.Seats = append(row.Seats, seat)
+	}
+	return sections
+}
+
+// seatMapHandler shows an event's seat map to organizers and saves changes to it.
+func seatMapHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	event, err := store.GetEvent(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var layout string
+	var errMessage string
+	if r.Method == "POST" {
+		layout = r.FormValue("layout")
+		rows, err := parseSeatRows(layout)
+		if err == nil {
+			event, err = setSeatMap(event.ID, rows)
+		}
+		if err == nil {
+			message := fmt.Sprintf("Saved the seat map: %d seats", event.TotalTickets)
+			if len(rows) == 0 {
+				message = "Assigned seating is off"
+			}
+			http.Redirect(w, r, "/organizer/events/"+strconv.Itoa(event.ID)+"/seats?message="+url.QueryEscape(message), http.StatusSeeOther)
+			return
+		}
+		errMessage = err.Error()
+	}
+
+	seats, err := store.ListSeats(event.ID)
+	if err != nil {
+		http.Error(w, "Failed to load seats", http.StatusInternalServerError)
+		return
+	}
+	if r.Method != "POST" {
+		layout = formatSeatRows(seatRows(seats))
+	}
+	taken, accessible := 0, 0
+	for _, seat := range seats {
+		if seat.Taken {
+			taken++
+		}
+		if seat.Accessible {
+			accessible++
+		}
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Seat Map - {{.Event.Name}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 900px; margin: 0 auto; padding: 20px; }
+        textarea { width: 100%; font-family: monospace; }
+        .error { color: red; }
+        .success { color: green; }
+        .stage { text-align: center; background: #eee; padding: 4px; }
+        .seat { display: inline-block; width: 26px; margin: 1px; text-align: center; font-size: 11px; background: #cfc; }
+        .seat.taken { background: #ccc; }
+        .seat.accessible { outline: 2px solid #06c; }
+        .row-label { display: inline-block; width: 30px; }
+    </style>
+</head>
+<body>
+    <h1>Seat Map: {{.Event.Name}}</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    {{if .Sections}}
+    <p>{{len .Seats}} seats, {{.Accessible}} accessible, {{.Taken}} taken.</p>
+    <p class="stage">Stage</p>
+    {{range .Sections}}
+    <h3>{{.Name}}</h3>
+    {{range .Rows}}
+    <div><span class="row-label">{{.Name}}</span>{{range .Seats}}<span class="seat{{if .Taken}} taken{{end}}{{if .Accessible}} accessible{{end}}" title="{{.Label}}">{{.Number}}</span>{{end}}</div>
+    {{end}}
+    {{end}}
+    {{else}}
+    <p>This event sells unnumbered tickets. Add rows below to give it assigned seating.</p>
+    {{end}}
+
+    <h2>Layout</h2>
+    <form method="POST" action="/organizer/events/{{.Event.ID}}/seats">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p>One row per line: <code>section, row, seats, accessible seat numbers</code>, for example
+           <code>Stalls, A, 20, 1 2</code>. Saving sets the event's capacity to the number of seats;
+           clear the layout to turn assigned seating off. The map cannot change once the event has bookings.</p>
+        <textarea name="layout" rows="15">{{.Layout}}</textarea>
+        <button type="submit">Save seat map</button>
+    </form>
+    <p><a href="/organizer/events/{{.Event.ID}}/edit">Back to {{.Event.Name}}</a> | <a href="/organizer/events">Manage events</a></p>
+</body>
+</html>`
+
+	t, _ := template.New("seat-map").Parse(tmpl)
+	data := struct {
+		Event      Event
+		Seats      []Seat
+		Sections   []seatSection
+		Taken      int
+		Accessible int
+		Layout     string
+		Message    string
+		Error      string
+		CSRFToken  string
+	}{
+		Event:      event,
+		Seats:      seats,
+		Sections:   seatSections(seats),
+		Taken:      taken,
+		Accessible: accessible,
+		Layout:     layout,
+		Message:    r.URL.Query().Get("message"),
+		Error:      errMessage,
+		CSRFToken:  csrfToken(w, r),
+	}
+	if errMessage != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	t.Execute(w, data)
+}
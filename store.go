@@ -0,0 +1,1332 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BookingStore persists events, bookings, users and sessions.
+type BookingStore interface {
+	CreateEvent(event Event) (Event, error)
+	GetEvent(id int) (Event, error)
+	ListEvents() ([]Event, error)
+	UpdateEvent(event Event) error
+	// ReviseEvent saves an event's details and capacity, shifting its remaining tickets by the change
+	// in capacity so bookings made meanwhile are kept. It fails with errCapacityBelowSold if the new
+	// capacity is less than the tickets already taken.
+	ReviseEvent(event Event) (Event, error)
+	// DeleteEvent removes an event that has never been booked; otherwise it fails with errEventHasBookings.
+	DeleteEvent(id int) error
+
+	CreateTicketType(t TicketType) (TicketType, error)
+	GetTicketType(id int) (TicketType, error)
+	// ListTicketTypes returns an event's tiers, oldest first.
+	ListTicketTypes(eventID int) ([]TicketType, error)
+	// ReviseTicketType saves a tier, shifting its remaining tickets by the change in quota. It fails
+	// with errQuotaBelowSold if the new quota is less than the tickets already taken.
+	ReviseTicketType(t TicketType) (TicketType, error)
+	// DeleteTicketType removes a tier that has never been booked; otherwise it fails with errTicketTypeHasBookings.
+	DeleteTicketType(id int) error
+
+	// CreateDiscountCode stores a new code; it fails with errDiscountCodeTaken if the code exists.
+	CreateDiscountCode(d DiscountCode) (DiscountCode, error)
+	GetDiscountCode(id int) (DiscountCode, error)
+	GetDiscountCodeByCode(code string) (DiscountCode, error)
+	ListDiscountCodes() ([]DiscountCode, error)
+	// UpdateDiscountCode saves everything about a code except the code itself.
+	UpdateDiscountCode(d DiscountCode) error
+
+	// SetSeatMap replaces an event's seats and sets its capacity to their number, keeping the capacity
+	// when seats is empty. It fails with errSeatMapLocked once the event has bookings.
+	SetSeatMap(eventID int, seats []Seat) (Event, error)
+	// ListSeats returns an event's seats in layout order, marking the ones held by bookings as taken.
+	ListSeats(eventID int) ([]Seat, error)
+
+	// CreateWaitlistEntry adds a customer to an event's waitlist; it fails with errAlreadyWaitlisted if
+	// they are already waiting for, or holding an offer of, tickets to the event.
+	CreateWaitlistEntry(entry WaitlistEntry) (WaitlistEntry, error)
+	GetWaitlistEntry(id int) (WaitlistEntry, error)
+	// ListWaitlist returns an event's waitlist in the order customers joined, or every event's for eventID 0.
+	ListWaitlist(eventID int) ([]WaitlistEntry, error)
+	ListUserWaitlist(userID int) ([]WaitlistEntry, error)
+	// UpdateWaitlistEntry saves an entry's status and offer.
+	UpdateWaitlistEntry(entry WaitlistEntry) error
+
+	// ReserveTickets atomically takes the booking's tickets off its event, off the tier of each of its
+	// items and, at seated events, its seats, and records the booking with newly issued tickets. A
+	// booking without seats at a seated event is given the best seats left, keeping accessible seats
+	// for last. It fails with errDiscountUsedUp or errDiscountUserLimit if the booking's discount code
+	// has no uses left.
+	ReserveTickets(booking EventBooking) (EventBooking, error)
+
+	CreateBooking(booking EventBooking) (EventBooking, error)
+	GetBooking(id int) (EventBooking, error)
+	// GetTicket finds a ticket by its code.
+	GetTicket(id string) (Ticket, error)
+	// CheckInTicket marks a ticket as used at gate by staffID. A ticket admits once: checking it in
+	// again fails with errAlreadyCheckedIn and returns the ticket as it was first checked in.
+	CheckInTicket(id string, at time.Time, gate string, staffID int) (Ticket, error)
+	// CheckInStats counts the tickets of an event's confirmed bookings and how many were checked in.
+	CheckInStats(eventID int) (CheckInStats, error)
+	ListBookings() ([]EventBooking, error)
+	CancelBooking(id int) error
+
+	// SetPaymentIntent links a booking to the payment intent that will pay for it.
+	SetPaymentIntent(bookingID int, paymentIntentID string) error
+	// ConfirmBooking moves a pending booking to confirmed, re-reserving its tickets if its hold expired.
+	// Re-reserving fails with errSoldOut, or errDiscountUsedUp or errDiscountUserLimit if its discount
+	// code has no uses left.
+	ConfirmBooking(id int) error
+	// ReleaseHold gives a pending booking's tickets back and moves it to the given status.
+	ReleaseHold(id int, status string) error
+	// ExpiredHolds lists pending bookings whose hold ran out before now.
+	ExpiredHolds(now time.Time) ([]EventBooking, error)
+	// GetBookingByPaymentIntent finds the booking paid for by a payment intent.
+	GetBookingByPaymentIntent(paymentIntentID string) (EventBooking, error)
+	// ReleaseBooking moves a booking to the given status, returning its tickets if it held any, and
+	// returns the booking as it was. It fails with errAlreadyCancelled if the booking is already in that
+	// status or refunded, so only one caller can act on a release.
+	ReleaseBooking(id int, status string) (EventBooking, error)
+
+	// RecordWebhookEvent remembers a webhook event ID, reporting false if it was already recorded.
+	RecordWebhookEvent(eventID, eventType string) (bool, error)
+	// ForgetWebhookEvent drops a recorded webhook event so a redelivery is processed again.
+	ForgetWebhookEvent(eventID string) error
+
+	// QueueRefund records a refund owed for a cancelled booking before the payment provider is asked for it.
+	QueueRefund(refund PendingRefund) error
+	// PendingRefunds lists the refunds queued before before and not yet accepted, oldest first.
+	PendingRefunds(before time.Time) ([]PendingRefund, error)
+	// RecordRefundAttempt counts a failed attempt at a queued refund and keeps its error.
+	RecordRefundAttempt(bookingID int, lastError string) error
+	// DeletePendingRefund drops a refund once the payment provider has accepted it.
+	DeletePendingRefund(bookingID int) error
+
+	CreateUser(user User) (User, error)
+	GetUser(id int) (User, error)
+	GetUserByUsername(username string) (User, error)
+	ListUsers() ([]User, error)
+	SetUserRole(id int, role string) error
+	SetEmailVerified(id int) error
+	// UpdateUserPassword replaces a user's stored password hash.
+	UpdateUserPassword(id int, passwordHash string) error
+
+	// RecordLoginAttempt appends a sign-in attempt to the audit log.
+	RecordLoginAttempt(attempt LoginAttempt) error
+	// AccountLoginFailures counts failed sign-ins for username since since and since its last successful
+	// sign-in, returning the time of the latest failure.
+	AccountLoginFailures(username string, since time.Time) (int, time.Time, error)
+	// IPLoginFailures counts failed sign-ins from ip since since, returning the time of the latest failure.
+	IPLoginFailures(ip string, since time.Time) (int, time.Time, error)
+	// ListLoginAttempts returns the most recent sign-in attempts, newest first.
+	ListLoginAttempts(limit int) ([]LoginAttempt, error)
+
+	CreateSession(session Session) error
+	GetSession(token string) (Session, error)
+	DeleteSession(token string) error
+	// TouchSession moves a session's expiry, for sliding renewal.
+	TouchSession(token string, expires time.Time) error
+	// DeleteUserSessions revokes every session of a user, returning how many were removed.
+	DeleteUserSessions(userID int) (int, error)
+	// DeleteExpiredSessions removes sessions that expired before now.
+	DeleteExpiredSessions(now time.Time) (int, error)
+
+	CreateAPIKey(key APIKey) (APIKey, error)
+	// GetAPIKeyByHash finds a key, revoked or not, by the hash of its secret.
+	GetAPIKeyByHash(hash string) (APIKey, error)
+	// ListAPIKeys returns a user's keys, oldest first.
+	ListAPIKeys(userID int) ([]APIKey, error)
+	// RevokeAPIKey revokes one of userID's keys; it fails with errNotFound if the key is not theirs or already revoked.
+	RevokeAPIKey(userID, id int, at time.Time) error
+	// TouchAPIKey records when a key was last used.
+	TouchAPIKey(id int, at time.Time) error
+}
+
+// errNotFound is returned by a BookingStore when the requested record does not exist.
+var errNotFound = errors.New("not found")
+
+// errAlreadyCancelled is returned when cancelling a booking that is already cancelled.
+var errAlreadyCancelled = errors.New("booking already cancelled")
+
+// errNotPending is returned when a hold operation targets a booking that is no longer pending.
+var errNotPending = errors.New("booking is not pending")
+
+// store is the active BookingStore, set up in main before any handler runs.
+var store BookingStore = newMemoryStore()
+
+// saveBooking records a booking of the default event made at the console or in the simple web mode,
+// which take no card payments: tickets are paid for at the door, so the booking is confirmed at once.
+func saveBooking(userData UserData) error {
+	_, booking, err := draftBooking(defaultEventID, 0, userData.firstName, userData.lastName, userData.email, ticketOrders(int(userData.numberOfTickets)), nil, "")
+	if err != nil {
+		return err
+	}
+	booking.Status = "confirmed"
+	booking, err = store.ReserveTickets(booking)
+	if err != nil {
+		return err
+	}
+	sendInBackground(func() { sendTicketConfirmation(bookingConfirmation(booking)) })
+	return nil
+}
+
+// loadBookings restores the single-event bookings and remaining tickets from the store.
+func loadBookings() error {
+	event, err := store.GetEvent(defaultEventID)
+	if err != nil {
+		return fmt.Errorf("loading default event: %w", err)
+	}
+
+	allBookings, err := store.ListBookings()
+	if err != nil {
+		return err
+	}
+
+	loaded := make([]UserData, 0)
+	for _, booking := range allBookings {
+		if booking.EventID != defaultEventID || !booking.HoldsTickets() {
+			continue
+		}
+		loaded = append(loaded, UserData{
+			firstName:       booking.FirstName,
+			lastName:        booking.LastName,
+			email:           booking.Email,
+			numberOfTickets: uint(booking.NumberOfTickets),
+		})
+	}
+
+	ticketsMutex.Lock()
+	defer ticketsMutex.Unlock()
+	bookings = loaded
+	remainingTickets = uint(event.RemainingTickets)
+	return nil
+}
+
+// reloadDefaultEvent refreshes the single-event mirrors after a change to eventID, if it is the default
+// event. The change is already saved, so a failure is only logged.
+func reloadDefaultEvent(eventID int) {
+	if eventID != defaultEventID {
+		return
+	}
+	if err := loadBookings(); err != nil {
+		fmt.Printf("Error reloading bookings: %v\n", err)
+	}
+}
+
+// memoryStore is a BookingStore kept entirely in memory, used for tests and throwaway runs.
+type memoryStore struct {
+	mu            sync.Mutex
+	events        map[int]Event
+	bookings      map[int]EventBooking
+	users         map[int]User
+	sessions      map[string]Session
+	webhookEvents map[string]bool
+	refunds       map[int]PendingRefund
+	loginAttempts []LoginAttempt
+	apiKeys       []APIKey
+	ticketTypes   map[int]TicketType
+	discountCodes map[int]DiscountCode
+	waitlist      []WaitlistEntry
+	seats         map[int]Seat
+	seatHolders   map[int]int // seat ID to the booking holding it
+	nextEventID   int
+	nextBookingID int
+	nextUserID    int
+	nextTierID    int
+	nextCodeID    int
+	nextWaitID    int
+	nextSeatID    int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		events:        make(map[int]Event),
+		bookings:      make(map[int]EventBooking),
+		users:         make(map[int]User),
+		sessions:      make(map[string]Session),
+		webhookEvents: make(map[string]bool),
+		refunds:       make(map[int]PendingRefund),
+		ticketTypes:   make(map[int]TicketType),
+		discountCodes: make(map[int]DiscountCode),
+		seats:         make(map[int]Seat),
+		seatHolders:   make(map[int]int),
+		nextEventID:   1,
+		nextBookingID: 1,
+		nextUserID:    1,
+		nextTierID:    1,
+		nextCodeID:    1,
+		nextWaitID:    1,
+		nextSeatID:    1,
+	}
+}
+
+func (s *memoryStore) CreateEvent(event Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = s.nextEventID
+	s.events[event.ID] = event
+	s.nextEventID++
+	return event, nil
+}
+
+func (s *memoryStore) GetEvent(id int) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, exists := s.events[id]
+	if !exists {
+		return Event{}, errNotFound
+	}
+	return event, nil
+}
+
+func (s *memoryStore) ListEvents() ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Event, 0, len(s.events))
+	for _, event := range s.events {
+		list = append(list, event)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) UpdateEvent(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.events[event.ID]; !exists {
+		return errNotFound
+	}
+	s.events[event.ID] = event
+	return nil
+}
+
+func (s *memoryStore) ReviseEvent(event Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.events[event.ID]
+	if !exists {
+		return Event{}, errNotFound
+	}
+	sold := current.TotalTickets - current.RemainingTickets
+	if event.TotalTickets < sold {
+		return Event{}, errCapacityBelowSold
+	}
+	event.RemainingTickets = event.TotalTickets - sold
+	s.events[event.ID] = event
+	return event, nil
+}
+
+func (s *memoryStore) DeleteEvent(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.events[id]; !exists {
+		return errNotFound
+	}
+	for _, booking := range s.bookings {
+		if booking.EventID == id {
+			return errEventHasBookings
+		}
+	}
+	delete(s.events, id)
+	for tierID, tier := range s.ticketTypes {
+		if tier.EventID == id {
+			delete(s.ticketTypes, tierID)
+		}
+	}
+	waitlist := s.waitlist[:0]
+	for _, entry := range s.waitlist {
+		if entry.EventID != id {
+			waitlist = append(waitlist, entry)
+		}
+	}
+	s.waitlist = waitlist
+	for seatID, seat := range s.seats {
+		if seat.EventID == id {
+			delete(s.seats, seatID)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateTicketType(t TicketType) (TicketType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.events[t.EventID]; !exists {
+		return TicketType{}, errNotFound
+	}
+	t.ID = s.nextTierID
+	s.ticketTypes[t.ID] = t
+	s.nextTierID++
+	return t, nil
+}
+
+func (s *memoryStore) GetTicketType(id int) (TicketType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, exists := s.ticketTypes[id]
+	if !exists {
+		return TicketType{}, errNotFound
+	}
+	return t, nil
+}
+
+func (s *memoryStore) ListTicketTypes(eventID int) ([]TicketType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]TicketType, 0)
+	for _, t := range s.ticketTypes {
+		if t.EventID == eventID {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) ReviseTicketType(t TicketType) (TicketType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.ticketTypes[t.ID]
+	if !exists {
+		return TicketType{}, errNotFound
+	}
+	sold := current.Quota - current.Remaining
+	if t.Quota < sold {
+		return TicketType{}, errQuotaBelowSold
+	}
+	t.EventID = current.EventID
+	t.Remaining = t.Quota - sold
+	s.ticketTypes[t.ID] = t
+	return t, nil
+}
+
+func (s *memoryStore) DeleteTicketType(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.ticketTypes[id]; !exists {
+		return errNotFound
+	}
+	for _, booking := range s.bookings {
+		for _, item := range booking.Items {
+			if item.TicketTypeID == id {
+				return errTicketTypeHasBookings
+			}
+		}
+	}
+	delete(s.ticketTypes, id)
+	return nil
+}
+
+func (s *memoryStore) CreateDiscountCode(d DiscountCode) (DiscountCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.discountCodes {
+		if existing.Code == d.Code {
+			return DiscountCode{}, errDiscountCodeTaken
+		}
+	}
+	d.ID = s.nextCodeID
+	s.discountCodes[d.ID] = d
+	s.nextCodeID++
+	return d, nil
+}
+
+func (s *memoryStore) GetDiscountCode(id int) (DiscountCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, exists := s.discountCodes[id]
+	if !exists {
+		return DiscountCode{}, errNotFound
+	}
+	d.Uses, _ = s.discountUses(id, 0)
+	return d, nil
+}
+
+func (s *memoryStore) GetDiscountCodeByCode(code string) (DiscountCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.discountCodes {
+		if d.Code == code {
+			d.Uses, _ = s.discountUses(d.ID, 0)
+			return d, nil
+		}
+	}
+	return DiscountCode{}, errNotFound
+}
+
+func (s *memoryStore) ListDiscountCodes() ([]DiscountCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]DiscountCode, 0, len(s.discountCodes))
+	for _, d := range s.discountCodes {
+		d.Uses, _ = s.discountUses(d.ID, 0)
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) UpdateDiscountCode(d DiscountCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, exists := s.discountCodes[d.ID]
+	if !exists {
+		return errNotFound
+	}
+	d.Code = current.Code
+	d.Created = current.Created
+	s.discountCodes[d.ID] = d
+	return nil
+}
+
+// discountUses counts the bookings holding tickets with a code, overall and by userID. The caller holds s.mu.
+func (s *memoryStore) discountUses(codeID, userID int) (int, int) {
+	uses, userUses := 0, 0
+	for _, booking := range s.bookings {
+		if booking.DiscountCodeID == codeID && booking.HoldsTickets() {
+			uses++
+			if booking.UserID == userID {
+				userUses++
+			}
+		}
+	}
+	return uses, userUses
+}
+
+// checkDiscountLimits fails if the booking's discount code has no uses left. The caller holds s.mu.
+func (s *memoryStore) checkDiscountLimits(booking EventBooking) error {
+	d, exists := s.discountCodes[booking.DiscountCodeID]
+	if !exists {
+		return nil
+	}
+	uses, userUses := s.discountUses(d.ID, booking.UserID)
+	switch {
+	case d.MaxUses > 0 && uses >= d.MaxUses:
+		return errDiscountUsedUp
+	case d.MaxUsesPerUser > 0 && userUses >= d.MaxUsesPerUser:
+		return errDiscountUserLimit
+	}
+	return nil
+}
+
+func (s *memoryStore) SetSeatMap(eventID int, seats []Seat) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, exists := s.events[eventID]
+	if !exists {
+		return Event{}, errNotFound
+	}
+	for _, booking := range s.bookings {
+		if booking.EventID == eventID {
+			return Event{}, errSeatMapLocked
+		}
+	}
+	for id, seat := range s.seats {
+		if seat.EventID == eventID {
+			delete(s.seats, id)
+		}
+	}
+	for _, seat := range seats {
+		seat.ID = s.nextSeatID
+		s.seats[seat.ID] = seat
+		s.nextSeatID++
+	}
+	if len(seats) > 0 {
+		event.TotalTickets = len(seats)
+		event.RemainingTickets = len(seats)
+		s.events[eventID] = event
+	}
+	return event, nil
+}
+
+func (s *memoryStore) ListSeats(eventID int) ([]Seat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Seat, 0)
+	for _, seat := range s.seats {
+		if seat.EventID == eventID {
+			_, seat.Taken = s.seatHolders[seat.ID]
+			list = append(list, seat)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) CreateWaitlistEntry(entry WaitlistEntry) (WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.waitlist {
+		if existing.EventID == entry.EventID && existing.UserID == entry.UserID && existing.Open() {
+			return WaitlistEntry{}, errAlreadyWaitlisted
+		}
+	}
+	entry.ID = s.nextWaitID
+	s.waitlist = append(s.waitlist, entry)
+	s.nextWaitID++
+	return entry, nil
+}
+
+func (s *memoryStore) GetWaitlistEntry(id int) (WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.waitlist {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return WaitlistEntry{}, errNotFound
+}
+
+func (s *memoryStore) ListWaitlist(eventID int) ([]WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]WaitlistEntry, 0)
+	for _, entry := range s.waitlist {
+		if eventID == 0 || entry.EventID == eventID {
+			list = append(list, entry)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) ListUserWaitlist(userID int) ([]WaitlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]WaitlistEntry, 0)
+	for _, entry := range s.waitlist {
+		if entry.UserID == userID {
+			list = append(list, entry)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) UpdateWaitlistEntry(entry WaitlistEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.waitlist {
+		if existing.ID == entry.ID {
+			s.waitlist[i].Status = entry.Status
+			s.waitlist[i].BookingID = entry.BookingID
+			s.waitlist[i].OfferExpires = entry.OfferExpires
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+// takeTickets checks that the booking's event, tiers and seats have its tickets left and takes them,
+// choosing seats for it at seated events if it has none. The caller holds s.mu.
+func (s *memoryStore) takeTickets(booking *EventBooking) error {
+	event := s.events[booking.EventID]
+	if booking.NumberOfTickets > event.RemainingTickets {
+		return errSoldOut
+	}
+	for _, item := range booking.Items {
+		if item.Quantity > s.ticketTypes[item.TicketTypeID].Remaining {
+			return fmt.Errorf("%w for %s", errSoldOut, item.Name)
+		}
+	}
+	seats, err := s.bookingSeats(*booking)
+	if err != nil {
+		return err
+	}
+
+	event.RemainingTickets -= booking.NumberOfTickets
+	s.events[event.ID] = event
+	for _, item := range booking.Items {
+		tier := s.ticketTypes[item.TicketTypeID]
+		tier.Remaining -= item.Quantity
+		s.ticketTypes[tier.ID] = tier
+	}
+	for _, seat := range seats {
+		s.seatHolders[seat.ID] = booking.ID
+	}
+	booking.Seats = seats
+	return nil
+}
+
+// bookingSeats checks that the booking's seats are free, or picks free seats for a booking without
+// any at a seated event. The caller holds s.mu.
+func (s *memoryStore) bookingSeats(booking EventBooking) ([]Seat, error) {
+	if len(booking.Seats) > 0 {
+		seats := make([]Seat, len(booking.Seats))
+		for i, seat := range booking.Seats {
+			if _, taken := s.seatHolders[seat.ID]; taken || s.seats[seat.ID].EventID != booking.EventID {
+				return nil, fmt.Errorf("%w: %s is taken", errSoldOut, seat.Label())
+			}
+			seats[i] = seat
+			seats[i].Taken = true
+		}
+		return seats, nil
+	}
+
+	var free []Seat
+	seated := false
+	for _, seat := range s.seats {
+		if seat.EventID != booking.EventID {
+			continue
+		}
+		seated = true
+		if _, taken := s.seatHolders[seat.ID]; !taken {
+			free = append(free, seat)
+		}
+	}
+	if !seated {
+		return nil, nil
+	}
+	if len(free) < booking.NumberOfTickets {
+		return nil, errSoldOut
+	}
+	sort.Slice(free, func(i, j int) bool {
+		if free[i].Accessible != free[j].Accessible {
+			return !free[i].Accessible
+		}
+		return free[i].ID < free[j].ID
+	})
+	seats := free[:booking.NumberOfTickets]
+	for i := range seats {
+		seats[i].Taken = true
+	}
+	return seats, nil
+}
+
+// returnTickets gives the booking's tickets back to its event and tiers, and frees its seats. The caller holds s.mu.
+func (s *memoryStore) returnTickets(booking EventBooking) {
+	event := s.events[booking.EventID]
+	event.RemainingTickets = min(event.TotalTickets, event.RemainingTickets+booking.NumberOfTickets)
+	s.events[event.ID] = event
+	for _, item := range booking.Items {
+		if tier, exists := s.ticketTypes[item.TicketTypeID]; exists {
+			tier.Remaining = min(tier.Quota, tier.Remaining+item.Quantity)
+			s.ticketTypes[tier.ID] = tier
+		}
+	}
+	for _, seat := range booking.Seats {
+		if s.seatHolders[seat.ID] == booking.ID {
+			delete(s.seatHolders, seat.ID)
+		}
+	}
+}
+
+func (s *memoryStore) ReserveTickets(booking EventBooking) (EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, exists := s.events[booking.EventID]
+	if !exists {
+		return EventBooking{}, errNotFound
+	}
+	if !event.Active {
+		return EventBooking{}, errEventInactive
+	}
+	if err := s.checkDiscountLimits(booking); err != nil {
+		return EventBooking{}, err
+	}
+	booking.ID = s.nextBookingID
+	if err := s.takeTickets(&booking); err != nil {
+		return EventBooking{}, err
+	}
+	booking.Tickets = newTickets(booking)
+	s.bookings[booking.ID] = booking
+	s.nextBookingID++
+	return booking, nil
+}
+
+func (s *memoryStore) CreateBooking(booking EventBooking) (EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking.ID = s.nextBookingID
+	s.bookings[booking.ID] = booking
+	s.nextBookingID++
+	return booking, nil
+}
+
+func (s *memoryStore) GetBooking(id int) (EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, exists := s.bookings[id]
+	if !exists {
+		return EventBooking{}, errNotFound
+	}
+	return booking, nil
+}
+
+func (s *memoryStore) GetTicket(id string) (Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, booking := range s.bookings {
+		for _, ticket := range booking.Tickets {
+			if ticket.ID == id {
+				return ticket, nil
+			}
+		}
+	}
+	return Ticket{}, errNotFound
+}
+
+func (s *memoryStore) CheckInTicket(id string, at time.Time, gate string, staffID int) (Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, booking := range s.bookings {
+		for i, ticket := range booking.Tickets {
+			if ticket.ID != id {
+				continue
+			}
+			if ticket.CheckedInAt != nil {
+				return ticket, errAlreadyCheckedIn
+			}
+			at = at.UTC()
+			ticket.CheckedInAt = &at
+			ticket.Gate = gate
+			ticket.CheckedInBy = staffID
+			// Copy the tickets, which earlier readers of the booking still share.
+			booking.Tickets = append([]Ticket(nil), booking.Tickets...)
+			booking.Tickets[i] = ticket
+			s.bookings[booking.ID] = booking
+			return ticket, nil
+		}
+	}
+	return Ticket{}, errNotFound
+}
+
+func (s *memoryStore) CheckInStats(eventID int) (CheckInStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := newCheckInStats(eventID)
+	for _, booking := range s.bookings {
+		if booking.EventID != eventID || booking.Status != "confirmed" {
+			continue
+		}
+		for _, ticket := range booking.Tickets {
+			stats.count(ticket)
+		}
+	}
+	stats.sortGates()
+	return stats, nil
+}
+
+func (s *memoryStore) ListBookings() ([]EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]EventBooking, 0, len(s.bookings))
+	for _, booking := range s.bookings {
+		list = append(list, booking)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) CancelBooking(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, exists := s.bookings[id]
+	if !exists {
+		return errNotFound
+	}
+	if booking.Status == "cancelled" {
+		return errAlreadyCancelled
+	}
+	booking.Status = "cancelled"
+	s.bookings[id] = booking
+	return nil
+}
+
+func (s *memoryStore) SetPaymentIntent(bookingID int, paymentIntentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, exists := s.bookings[bookingID]
+	if !exists {
+		return errNotFound
+	}
+	booking.PaymentIntentID = paymentIntentID
+	s.bookings[bookingID] = booking
+	return nil
+}
+
+func (s *memoryStore) ConfirmBooking(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, exists := s.bookings[id]
+	if !exists {
+		return errNotFound
+	}
+
+	switch booking.Status {
+	case "confirmed":
+		return nil
+	case "pending":
+	case "expired", "failed":
+		if err := s.checkDiscountLimits(booking); err != nil {
+			return err
+		}
+		if err := s.takeTickets(&booking); err != nil {
+			return err
+		}
+	default:
+		return errNotPending
+	}
+
+	booking.Status = "confirmed"
+	booking.HoldExpires = nil
+	s.bookings[id] = booking
+	return nil
+}
+
+func (s *memoryStore) ReleaseHold(id int, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, exists := s.bookings[id]
+	if !exists {
+		return errNotFound
+	}
+	if booking.Status != "pending" {
+		return errNotPending
+	}
+	s.returnTickets(booking)
+
+	booking.Status = status
+	booking.HoldExpires = nil
+	s.bookings[id] = booking
+	return nil
+}
+
+func (s *memoryStore) ExpiredHolds(now time.Time) ([]EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]EventBooking, 0)
+	for _, booking := range s.bookings {
+		if booking.Status == "pending" && booking.HoldExpires != nil && booking.HoldExpires.Before(now) {
+			list = append(list, booking)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) GetBookingByPaymentIntent(paymentIntentID string) (EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, booking := range s.bookings {
+		if paymentIntentID != "" && booking.PaymentIntentID == paymentIntentID {
+			return booking, nil
+		}
+	}
+	return EventBooking{}, errNotFound
+}
+
+func (s *memoryStore) ReleaseBooking(id int, status string) (EventBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, exists := s.bookings[id]
+	if !exists {
+		return EventBooking{}, errNotFound
+	}
+	if booking.Status == status || booking.Status == "refunded" {
+		return EventBooking{}, errAlreadyCancelled
+	}
+
+	if booking.HoldsTickets() {
+		s.returnTickets(booking)
+	}
+
+	released := booking
+	booking.Status = status
+	booking.HoldExpires = nil
+	s.bookings[id] = booking
+	return released, nil
+}
+
+func (s *memoryStore) RecordWebhookEvent(eventID, eventType string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.webhookEvents[eventID] {
+		return false, nil
+	}
+	s.webhookEvents[eventID] = true
+	return true, nil
+}
+
+func (s *memoryStore) ForgetWebhookEvent(eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.webhookEvents, eventID)
+	return nil
+}
+
+func (s *memoryStore) QueueRefund(refund PendingRefund) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refunds[refund.BookingID]; ok {
+		return fmt.Errorf("refund for booking %d is already queued", refund.BookingID)
+	}
+	s.refunds[refund.BookingID] = refund
+	return nil
+}
+
+func (s *memoryStore) PendingRefunds(before time.Time) ([]PendingRefund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]PendingRefund, 0)
+	for _, refund := range s.refunds {
+		if refund.Created.Before(before) {
+			list = append(list, refund)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list, nil
+}
+
+func (s *memoryStore) RecordRefundAttempt(bookingID int, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refund, ok := s.refunds[bookingID]
+	if !ok {
+		return errNotFound
+	}
+	refund.Attempts++
+	refund.LastError = lastError
+	s.refunds[bookingID] = refund
+	return nil
+}
+
+func (s *memoryStore) DeletePendingRefund(bookingID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refunds[bookingID]; !ok {
+		return errNotFound
+	}
+	delete(s.refunds, bookingID)
+	return nil
+}
+
+func (s *memoryStore) CreateUser(user User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return User{}, fmt.Errorf("user already exists")
+		}
+	}
+	user.ID = s.nextUserID
+	s.users[user.ID] = user
+	s.nextUserID++
+	return user, nil
+}
+
+func (s *memoryStore) GetUser(id int) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[id]
+	if !exists {
+		return User{}, errNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryStore) GetUserByUsername(username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, errNotFound
+}
+
+func (s *memoryStore) ListUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryStore) SetEmailVerified(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[id]
+	if !exists {
+		return errNotFound
+	}
+	user.EmailVerified = true
+	s.users[id] = user
+	return nil
+}
+
+func (s *memoryStore) SetUserRole(id int, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[id]
+	if !exists {
+		return errNotFound
+	}
+	user.Role = role
+	s.users[id] = user
+	return nil
+}
+
+func (s *memoryStore) UpdateUserPassword(id int, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[id]
+	if !exists {
+		return errNotFound
+	}
+	user.Password = passwordHash
+	s.users[id] = user
+	return nil
+}
+
+func (s *memoryStore) RecordLoginAttempt(attempt LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt.ID = len(s.loginAttempts) + 1
+	s.loginAttempts = append(s.loginAttempts, attempt)
+	return nil
+}
+
+func (s *memoryStore) AccountLoginFailures(username string, since time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, latest := 0, time.Time{}
+	for i := len(s.loginAttempts) - 1; i >= 0; i-- {
+		attempt := s.loginAttempts[i]
+		if attempt.Username != username {
+			continue
+		}
+		if attempt.Success || !attempt.AttemptedAt.After(since) {
+			break
+		}
+		if attempt.countsAsFailure() {
+			if count == 0 {
+				latest = attempt.AttemptedAt
+			}
+			count++
+		}
+	}
+	return count, latest, nil
+}
+
+func (s *memoryStore) IPLoginFailures(ip string, since time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, latest := 0, time.Time{}
+	for i := len(s.loginAttempts) - 1; i >= 0; i-- {
+		attempt := s.loginAttempts[i]
+		if !attempt.AttemptedAt.After(since) {
+			break
+		}
+		if attempt.IP == ip && attempt.countsAsFailure() {
+			if count == 0 {
+				latest = attempt.AttemptedAt
+			}
+			count++
+		}
+	}
+	return count, latest, nil
+}
+
+func (s *memoryStore) ListLoginAttempts(limit int) ([]LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]LoginAttempt, 0, limit)
+	for i := len(s.loginAttempts) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, s.loginAttempts[i])
+	}
+	return list, nil
+}
+
+func (s *memoryStore) CreateSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.Token] = session
+	return nil
+}
+
+func (s *memoryStore) GetSession(token string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[token]
+	if !exists {
+		return Session{}, errNotFound
+	}
+	return session, nil
+}
+
+func (s *memoryStore) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *memoryStore) TouchSession(token string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[token]
+	if !exists {
+		return errNotFound
+	}
+	session.Expires = expires
+	s.sessions[token] = session
+	return nil
+}
+
+func (s *memoryStore) DeleteUserSessions(userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for token, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) DeleteExpiredSessions(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for token, session := range s.sessions {
+		if session.Expires.Before(now) {
+			delete(s.sessions, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) CreateAPIKey(key APIKey) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.apiKeys {
+		if existing.Hash == key.Hash {
+			return APIKey{}, fmt.Errorf("api key already exists")
+		}
+	}
+	key.ID = len(s.apiKeys) + 1
+	s.apiKeys = append(s.apiKeys, key)
+	return key, nil
+}
+
+func (s *memoryStore) GetAPIKeyByHash(hash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.apiKeys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, errNotFound
+}
+
+func (s *memoryStore) ListAPIKeys(userID int) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []APIKey
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			list = append(list, key)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) RevokeAPIKey(userID, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, key := range s.apiKeys {
+		if key.ID == id && key.UserID == userID && key.Revoked == nil {
+			s.apiKeys[i].Revoked = &at
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (s *memoryStore) TouchAPIKey(id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, key := range s.apiKeys {
+		if key.ID == id {
+			s.apiKeys[i].LastUsed = &at
+			return nil
+		}
+	}
+	return errNotFound
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore opens a migrated SQLite database in the test's temporary directory.
+func newTestSQLiteStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	db := openDBAt(filepath.Join(t.TempDir(), "bookings.db"))
+	t.Cleanup(func() { db.Close() })
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return newSQLiteStore(db)
+}
+
+// useStore makes s the active store until the test ends and the emails it sent have read from it.
+func useStore(t *testing.T, s BookingStore) {
+	t.Helper()
+	previous := store
+	store = s
+	t.Cleanup(func() {
+		emailsInFlight.Wait()
+		store = previous
+	})
+}
+
+// bookingStatus reads a booking's status.
+func bookingStatus(t *testing.T, bookingID int) string {
+	t.Helper()
+	booking, err := store.GetBooking(bookingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return booking.Status
+}
+
+// forEachStore runs test once against a fresh memoryStore and once against a fresh sqliteStore, each
+// made the active store, so both implementations keep the same promises.
+func forEachStore(t *testing.T, test func(t *testing.T)) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) BookingStore
+	}{
+		{"memory", func(*testing.T) BookingStore { return newMemoryStore() }},
+		{"sqlite", func(t *testing.T) BookingStore { return newTestSQLiteStore(t) }},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			useStore(t, s.open(t))
+			test(t)
+		})
+	}
+}
+
+// createTestEvent adds an active event a month from now.
+func createTestEvent(t *testing.T, tickets int, price float64) Event {
+	t.Helper()
+	event, err := createEvent("Test Event", "An event for tests.", "Test Hall", time.Now().AddDate(0, 1, 0), tickets, price)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// eventTicketsLeft reads how many tickets an event has left.
+func eventTicketsLeft(t *testing.T, eventID int) int {
+	t.Helper()
+	event, err := store.GetEvent(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event.RemainingTickets
+}
+
+// draftTestBooking drafts a booking of tickets to an event, in the given seats if any, without reserving it.
+func draftTestBooking(t *testing.T, eventID, tickets int, status string, seatIDs ...int) EventBooking {
+	t.Helper()
+	_, booking, err := draftBooking(eventID, 0, "Ada", "Lovelace", "ada@example.com", ticketOrders(tickets), seatIDs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	booking.Status = status
+	return booking
+}
+
+// reserveConcurrently reserves each booking in its own goroutine, all at once, and returns what each reservation returned.
+func reserveConcurrently(drafts []EventBooking) ([]EventBooking, []error) {
+	booked := make([]EventBooking, len(drafts))
+	errs := make([]error, len(drafts))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range drafts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			booked[i], errs[i] = store.ReserveTickets(drafts[i])
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return booked, errs
+}
+
+// TestStoreBookings books an event, reads the booking back and cancels it once.
+func TestStoreBookings(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		event := createTestEvent(t, 10, 25)
+		booking, err := bookEventTicket(event.ID, 0, "Ada", "Lovelace", "ada@example.com", ticketOrders(2), nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 8 {
+			t.Fatalf("%d tickets left, want 8", left)
+		}
+
+		stored, err := store.GetBooking(booking.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.EventID != event.ID || stored.Email != "ada@example.com" || stored.NumberOfTickets != 2 || stored.TotalAmount != 50 {
+			t.Fatalf("stored booking %+v does not match what was booked", stored)
+		}
+		list, err := store.ListBookings()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].ID != booking.ID {
+			t.Fatalf("listed %d bookings, want only booking %d", len(list), booking.ID)
+		}
+
+		if err := store.CancelBooking(booking.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CancelBooking(booking.ID); !errors.Is(err, errAlreadyCancelled) {
+			t.Fatalf("cancelling twice: %v, want errAlreadyCancelled", err)
+		}
+		if _, err := store.GetBooking(booking.ID + 1); !errors.Is(err, errNotFound) {
+			t.Fatalf("reading a missing booking: %v, want errNotFound", err)
+		}
+	})
+}
+
+// TestStoreUsersAndSessions finds users by ID and username and ends their sessions.
+func TestStoreUsersAndSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		user, err := store.CreateUser(User{Username: "ada", Email: "ada@example.com", Password: "hash", Created: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.CreateUser(User{Username: "ada", Email: "other@example.com", Password: "hash", Created: time.Now()}); err == nil {
+			t.Fatal("created a second user named ada")
+		}
+		if found, err := store.GetUserByUsername("ada"); err != nil || found.ID != user.ID {
+			t.Fatalf("by username: %v, user %d, want user %d", err, found.ID, user.ID)
+		}
+		if _, err := store.GetUser(user.ID + 1); !errors.Is(err, errNotFound) {
+			t.Fatalf("reading a missing user: %v, want errNotFound", err)
+		}
+
+		session := Session{Token: "token", UserID: user.ID, Expires: time.Now().Add(time.Hour)}
+		if err := store.CreateSession(session); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := store.GetSession("token"); err != nil || found.UserID != user.ID {
+			t.Fatalf("session: %v, user %d, want user %d", err, found.UserID, user.ID)
+		}
+		if err := store.DeleteSession("token"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetSession("token"); !errors.Is(err, errNotFound) {
+			t.Fatalf("reading a deleted session: %v, want errNotFound", err)
+		}
+	})
+}
+
+// TestReserveTicketsNeverOversells races more bookings than there are tickets: the event sells out
+// exactly and every other booking fails with errSoldOut.
+func TestReserveTicketsNeverOversells(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		event := createTestEvent(t, 5, 0)
+		drafts := make([]EventBooking, 20)
+		for i := range drafts {
+			drafts[i] = draftTestBooking(t, event.ID, 1, "confirmed")
+		}
+
+		_, errs := reserveConcurrently(drafts)
+		sold := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				sold++
+			case !errors.Is(err, errSoldOut):
+				t.Fatal(err)
+			}
+		}
+		if sold != 5 {
+			t.Fatalf("sold %d tickets, want 5", sold)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 0 {
+			t.Fatalf("%d tickets left, want 0", left)
+		}
+	})
+}
+
+// TestSeatIsBookedOnce has two customers pick the same free seat at the same time: only one of them gets it.
+func TestSeatIsBookedOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		event := createTestEvent(t, 4, 0)
+		seats, err := layoutSeats(event.ID, []SeatRow{{Section: "Stalls", Row: "A", Seats: 4}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SetSeatMap(event.ID, seats); err != nil {
+			t.Fatal(err)
+		}
+		if seats, err = store.ListSeats(event.ID); err != nil {
+			t.Fatal(err)
+		}
+		seat := seats[1]
+
+		// Both customers saw the seat free when they chose it.
+		drafts := []EventBooking{
+			draftTestBooking(t, event.ID, 1, "confirmed", seat.ID),
+			draftTestBooking(t, event.ID, 1, "confirmed", seat.ID),
+		}
+		booked, errs := reserveConcurrently(drafts)
+
+		var winner EventBooking
+		for i, err := range errs {
+			switch {
+			case err == nil && winner.ID == 0:
+				winner = booked[i]
+			case err == nil:
+				t.Fatalf("%s was booked twice, by bookings %d and %d", seat.Label(), winner.ID, booked[i].ID)
+			case !errors.Is(err, errSoldOut):
+				t.Fatal(err)
+			}
+		}
+		if winner.ID == 0 {
+			t.Fatalf("%s was not booked by either customer: %v", seat.Label(), errs)
+		}
+
+		booking, err := store.GetBooking(winner.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(booking.Seats) != 1 || booking.Seats[0].ID != seat.ID {
+			t.Fatalf("booking %d has seats %v, want only %s", booking.ID, booking.Seats, seat.Label())
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 3 {
+			t.Fatalf("%d tickets left, want 3", left)
+		}
+	})
+}
+
+// TestHoldLifecycle releases a hold, confirms it again once payment arrives while the tickets are still
+// there, and refuses to confirm a lapsed hold whose tickets have gone to someone else.
+func TestHoldLifecycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		event := createTestEvent(t, 2, 25)
+		hold, err := store.ReserveTickets(draftTestBooking(t, event.ID, 2, "pending"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.ReleaseHold(hold.ID, "expired"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.ReleaseHold(hold.ID, "expired"); !errors.Is(err, errNotPending) {
+			t.Fatalf("releasing twice: %v, want errNotPending", err)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 2 {
+			t.Fatalf("%d tickets left after the hold lapsed, want 2", left)
+		}
+
+		if err := store.ConfirmBooking(hold.ID); err != nil {
+			t.Fatal(err)
+		}
+		if status := bookingStatus(t, hold.ID); status != "confirmed" {
+			t.Fatalf("booking is %s, want confirmed", status)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 0 {
+			t.Fatalf("%d tickets left after confirming, want 0", left)
+		}
+
+		resold := createTestEvent(t, 2, 25)
+		lapsed, err := store.ReserveTickets(draftTestBooking(t, resold.ID, 2, "pending"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.ReleaseHold(lapsed.ID, "expired"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.ReserveTickets(draftTestBooking(t, resold.ID, 2, "confirmed")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.ConfirmBooking(lapsed.ID); !errors.Is(err, errSoldOut) {
+			t.Fatalf("confirming a lapsed hold of sold tickets: %v, want errSoldOut", err)
+		}
+		if status := bookingStatus(t, lapsed.ID); status != "expired" {
+			t.Fatalf("lapsed hold is %s, want expired", status)
+		}
+	})
+}
+
+// TestLapsedHoldKeepsDiscountLimits refuses to confirm a lapsed hold whose discount code's last use has
+// gone to another booking meanwhile.
+func TestLapsedHoldKeepsDiscountLimits(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		event := createTestEvent(t, 10, 25)
+		code, err := store.CreateDiscountCode(DiscountCode{Code: "ONCE", Kind: discountPercent, Value: 10, MaxUses: 1,
+			Active: true, Created: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		withCode := func(booking EventBooking) EventBooking {
+			booking.DiscountCodeID, booking.DiscountCode = code.ID, code.Code
+			return booking
+		}
+
+		lapsed, err := store.ReserveTickets(withCode(draftTestBooking(t, event.ID, 2, "pending")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.ReleaseHold(lapsed.ID, "expired"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.ReserveTickets(withCode(draftTestBooking(t, event.ID, 2, "confirmed"))); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.ConfirmBooking(lapsed.ID); !errors.Is(err, errDiscountUsedUp) {
+			t.Fatalf("confirming a lapsed hold of a used-up code: %v, want errDiscountUsedUp", err)
+		}
+		if status := bookingStatus(t, lapsed.ID); status != "expired" {
+			t.Fatalf("lapsed hold is %s, want expired", status)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 8 {
+			t.Fatalf("%d tickets left, want 8", left)
+		}
+	})
+}
+
+// TestReleaseBookingOnce cancels one booking from many goroutines at once: one caller claims it and
+// its tickets come back once, and everyone else is told it is already cancelled.
+func TestReleaseBookingOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		event := createTestEvent(t, 5, 0)
+		booking, err := store.ReserveTickets(draftTestBooking(t, event.ID, 2, "confirmed"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errs := make([]error, 10)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = store.ReleaseBooking(booking.ID, "cancelled")
+			}(i)
+		}
+		wg.Wait()
+
+		claimed := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				claimed++
+			case !errors.Is(err, errAlreadyCancelled):
+				t.Fatal(err)
+			}
+		}
+		if claimed != 1 {
+			t.Fatalf("%d callers cancelled the booking, want 1", claimed)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 5 {
+			t.Fatalf("%d tickets left, want 5", left)
+		}
+		if _, err := store.ReleaseBooking(booking.ID, "refunded"); err != nil {
+			t.Fatalf("refunding the cancelled booking: %v", err)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 5 {
+			t.Fatalf("%d tickets left after the refund, want 5", left)
+		}
+	})
+}
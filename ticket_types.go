@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TicketType is one tier of an event's tickets, such as General, VIP or Student, with its own price
+// and quota. Tickets of every tier also count against the event's TotalTickets, which caps the venue.
+// Events without ticket types sell standard admission at the event's TicketPrice.
+type TicketType struct {
+	ID          int        `json:"id"`
+	EventID     int        `json:"event_id"`
+	Name        string     `json:"name"`
+	Price       float64    `json:"price"`
+	Quota       int        `json:"quota"`
+	Remaining   int        `json:"remaining"`
+	SalesStart  *time.Time `json:"sales_start,omitempty"` // nil: on sale while the event is published
+	SalesEnd    *time.Time `json:"sales_end,omitempty"`
+	MinPerOrder int        `json:"min_per_order"`
+	MaxPerOrder int        `json:"max_per_order"` // 0: only the overall order limit applies
+}
+
+// OnSale reports whether the tier's sale window is open at now.
+func (t TicketType) OnSale(now time.Time) bool {
+	if t.SalesStart != nil && now.Before(*t.SalesStart) {
+		return false
+	}
+	return t.SalesEnd == nil || now.Before(*t.SalesEnd)
+}
+
+// BookingItem is the tickets of one tier in a booking, at the price they were sold for.
+type BookingItem struct {
+	TicketTypeID int     `json:"ticket_type_id"`
+	Name         string  `json:"name"`
+	Quantity     int     `json:"quantity"`
+	UnitPrice    float64 `json:"unit_price"`
+}
+
+// TicketOrder asks for Quantity tickets of one tier. TicketTypeID 0 asks for standard admission on
+// events without tiers, or for the only tier on sale.
+type TicketOrder struct {
+	TicketTypeID int `json:"ticket_type_id"`
+	Quantity     int `json:"quantity"`
+}
+
+// ticketOrders is an order for n tickets, for callers that only know how many tickets they want.
+func ticketOrders(n int) []TicketOrder {
+	return []TicketOrder{{Quantity: n}}
+}
+
+// orderSize counts the tickets in an order.
+func orderSize(orders []TicketOrder) int {
+	n := 0
+	for _, order := range orders {
+		n += order.Quantity
+	}
+	return n
+}
+
+// onSaleTicketTypes lists the tiers of an event that can be bought now.
+func onSaleTicketTypes(eventID int) ([]TicketType, error) {
+	tiers, err := store.ListTicketTypes(eventID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	onSale := make([]TicketType, 0, len(tiers))
+	for _, tier := range tiers {
+		if tier.OnSale(now) {
+			onSale = append(onSale, tier)
+		}
+	}
+	return onSale, nil
+}
+
+// errQuotaBelowSold is returned when a tier's quota is cut below the tickets already taken.
+var errQuotaBelowSold = errors.New("quota cannot be less than tickets already booked")
+
+// errTicketTypeHasBookings is returned when deleting a tier that has been booked; end its sale instead.
+var errTicketTypeHasBookings = errors.New("ticket types with bookings cannot be deleted, end their sale instead")
+
+// validateTicketType checks a tier before it is saved.
+func validateTicketType(t TicketType) error {
+	switch {
+	case t.Name == "" || len(t.Name) > 100:
+		return validationError("name must be between 1 and 100 characters")
+	case t.Price < 0:
+		return validationError("price cannot be negative")
+	case t.Quota <= 0:
+		return validationError("quota must be at least 1")
+	case t.MinPerOrder < 1:
+		return validationError("minimum per order must be at least 1")
+	case t.MaxPerOrder != 0 && t.MaxPerOrder < t.MinPerOrder:
+		return validationError("maximum per order cannot be less than the minimum")
+	case t.SalesStart != nil && t.SalesEnd != nil && !t.SalesEnd.After(*t.SalesStart):
+		return validationError("sales must end after they start")
+	}
+	return nil
+}
+
+// addTicketType validates and stores a new tier with its whole quota available.
+func addTicketType(t TicketType) (TicketType, error) {
+	if _, err := store.GetEvent(t.EventID); err != nil {
+		return TicketType{}, err
+	}
+	t.Name = strings.TrimSpace(t.Name)
+	if err := validateTicketType(t); err != nil {
+		return TicketType{}, err
+	}
+	t.Remaining = t.Quota
+	return store.CreateTicketType(t)
+}
+
+// editTicketType validates and saves changes to a tier, keeping the tickets already booked.
+func editTicketType(t TicketType) (TicketType, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if err := validateTicketType(t); err != nil {
+		return TicketType{}, err
+	}
+	updated, err := store.ReviseTicketType(t)
+	if err == nil {
+		offerFreedTickets(updated.EventID)
+	}
+	return updated, err
+}
+
+// eventTicketType loads tier id, answering errNotFound if it belongs to another event.
+func eventTicketType(eventID, id int) (TicketType, error) {
+	t, err := store.GetTicketType(id)
+	if err != nil {
+		return TicketType{}, err
+	}
+	if t.EventID != eventID {
+		return TicketType{}, errNotFound
+	}
+	return t, nil
+}
+
+// priceOrder turns an order into booking items, checking that each tier is on sale and within its
+// per-order limits, and returns them with the number of tickets and the total price.
+func priceOrder(event Event, orders []TicketOrder) ([]BookingItem, int, float64, error) {
+	tiers, err := store.ListTicketTypes(event.ID)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	now := time.Now()
+
+	if len(tiers) == 0 {
+		if len(orders) != 1 || orders[0].TicketTypeID != 0 || orders[0].Quantity <= 0 {
+			return nil, 0, 0, validationError("this event has no ticket types; order a number of tickets")
+		}
+		n := orders[0].Quantity
+		return nil, n, float64(n) * event.TicketPrice, nil
+	}
+
+	if len(orders) == 1 && orders[0].TicketTypeID == 0 {
+		var onSale []TicketType
+		for _, tier := range tiers {
+			if tier.OnSale(now) {
+				onSale = append(onSale, tier)
+			}
+		}
+		if len(onSale) != 1 {
+			return nil, 0, 0, validationError("choose a ticket type")
+		}
+		orders = []TicketOrder{{TicketTypeID: onSale[0].ID, Quantity: orders[0].Quantity}}
+	}
+
+	byID := make(map[int]TicketType, len(tiers))
+	for _, tier := range tiers {
+		byID[tier.ID] = tier
+	}
+	var items []BookingItem
+	count, amount := 0, 0.0
+	seen := map[int]bool{}
+	for _, order := range orders {
+		// Forms submit a quantity for every tier, including the ones not wanted.
+		if order.Quantity == 0 {
+			continue
+		}
+		tier, exists := byID[order.TicketTypeID]
+		switch {
+		case !exists:
+			return nil, 0, 0, validationError(fmt.Sprintf("ticket type %d is not sold for this event", order.TicketTypeID))
+		case seen[tier.ID]:
+			return nil, 0, 0, validationError("each ticket type can only appear once in an order")
+		case order.Quantity < 0:
+			return nil, 0, 0, validationError("quantities cannot be negative")
+		case !tier.OnSale(now):
+			return nil, 0, 0, validationError(tier.Name + " tickets are not on sale")
+		case order.Quantity < tier.MinPerOrder:
+			return nil, 0, 0, validationError(fmt.Sprintf("order at least %d %s tickets", tier.MinPerOrder, tier.Name))
+		case tier.MaxPerOrder > 0 && order.Quantity > tier.MaxPerOrder:
+			return nil, 0, 0, validationError(fmt.Sprintf("order at most %d %s tickets", tier.MaxPerOrder, tier.Name))
+		}
+		seen[tier.ID] = true
+		items = append(items, BookingItem{TicketTypeID: tier.ID, Name: tier.Name, Quantity: order.Quantity, UnitPrice: tier.Price})
+		count += order.Quantity
+		amount += float64(order.Quantity) * tier.Price
+	}
+	if count == 0 {
+		return nil, 0, 0, validationError("choose at least one ticket")
+	}
+	return items, count, amount, nil
+}
+
+// bookingAmountCents is what a booking costs in the smallest currency unit, priced per tier and less its discount.
+func bookingAmountCents(event Event, booking EventBooking) int64 {
+	cents := priceInCents(event) * int64(booking.NumberOfTickets)
+	if len(booking.Items) > 0 {
+		cents = 0
+		for _, item := range booking.Items {
+			cents += int64(math.Round(item.UnitPrice*100)) * int64(item.Quantity)
+		}
+	}
+	return max(cents-int64(math.Round(booking.DiscountAmount*100)), 0)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Ticket admits one person to a booking's event. A booking has one ticket per ticket bought,
+// numbered from 1; ticket n is for the booking's nth seat at seated events.
+type Ticket struct {
+	ID          string     `json:"id"` // the random code printed on the ticket
+	BookingID   int        `json:"booking_id"`
+	Number      int        `json:"number"`
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"` // set once the ticket has admitted someone
+	Gate        string     `json:"gate,omitempty"`
+	CheckedInBy int        `json:"checked_in_by,omitempty"` // the staff member who scanned it
+}
+
+// ticketSecret signs the payloads of ticket QR codes. Set TICKET_SECRET so tickets already issued
+// still scan after a restart.
+var ticketSecret = getTicketSecret()
+
+func getTicketSecret() []byte {
+	if secret := os.Getenv("TICKET_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("tickets: cannot generate secret: " + err.Error())
+	}
+	return secret
+}
+
+// newTickets issues the tickets of a new booking, each with a fresh random code.
+func newTickets(booking EventBooking) []Ticket {
+	tickets := make([]Ticket, booking.NumberOfTickets)
+	for i := range tickets {
+		code := make([]byte, 8)
+		rand.Read(code) // never fails since Go 1.24
+		tickets[i] = Ticket{ID: strings.ToUpper(hex.EncodeToString(code)), BookingID: booking.ID, Number: i + 1}
+	}
+	return tickets
+}
+
+// ticketPayload is what a ticket's QR code holds: "TKT1.eventID.code.signature". The signature
+// keeps anyone from making up codes for an event.
+func ticketPayload(eventID int, ticket Ticket) string {
+	payload := fmt.Sprintf("TKT1.%d.%s", eventID, ticket.ID)
+	return payload + "." + ticketMAC(payload)
+}
+
+// ticketMAC is the first 16 bytes of the payload's HMAC, which keeps the QR code small.
+func ticketMAC(payload string) string {
+	mac := hmac.New(sha256.New, ticketSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// ticketQRCode renders a ticket's QR code as a PNG image.
+func ticketQRCode(eventID int, ticket Ticket) ([]byte, error) {
+	qr, err := newQRCode([]byte(ticketPayload(eventID, ticket)))
+	if err != nil {
+		return nil, err
+	}
+	return qr.PNG(8)
+}
+
+// ticketDescription names what a ticket is for: its tier, if any, and its seat at seated events.
+func ticketDescription(booking EventBooking, ticket Ticket) string {
+	var parts []string
+	n := ticket.Number
+	for _, item := range booking.Items {
+		if n <= item.Quantity {
+			parts = append(parts, item.Name)
+			break
+		}
+		n -= item.Quantity
+	}
+	if ticket.Number <= len(booking.Seats) {
+		parts = append(parts, booking.Seats[ticket.Number-1].Label())
+	}
+	if len(parts) == 0 {
+		return "General admission"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// bookingConfirmation is the confirmation email of a booking, sent with its tickets.
+func bookingConfirmation(booking EventBooking) TicketConfirmationParams {
+	return TicketConfirmationParams{
+		UserTickets: uint(booking.NumberOfTickets),
+		FirstName:   booking.FirstName,
+		LastName:    booking.LastName,
+		Email:       booking.Email,
+		BookingID:   booking.ID,
+	}
+}
+
+// bookingTicketsTemplate is the email template for a confirmed booking's tickets.
+const bookingTicketsTemplate = `Dear %s %s,
+
+Your booking #%d for %s is confirmed.
+
+- When: %s
+- Where: %s
+
+Your tickets are attached as QR codes, and with your receipt as a PDF. Bring one per person,
+printed or on your phone:
+%s
+Best regards,
+Booking Team
+`
+
+// bookingTicketsEmail writes the confirmation email of a booking, with a QR code attached for each of its
+// tickets and the booking's PDF.
+func bookingTicketsEmail(bookingID int) (string, string, []EmailAttachment, error) {
+	booking, err := store.GetBooking(bookingID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	event, err := store.GetEvent(booking.EventID)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var lines strings.Builder
+	attachments := make([]EmailAttachment, 0, len(booking.Tickets)+1)
+	for _, ticket := range booking.Tickets {
+		png, err := ticketQRCode(event.ID, ticket)
+		if err != nil {
+			return "", "", nil, err
+		}
+		attachments = append(attachments, EmailAttachment{Filename: "ticket-" + ticket.ID + ".png", ContentType: "image/png", Data: png})
+		fmt.Fprintf(&lines, "- Ticket %d of %d, %s, code %s: %s/tickets/%s\n", ticket.Number, len(booking.Tickets),
+			ticketDescription(booking, ticket), ticket.ID, getAppBaseURL(), ticket.ID)
+	}
+
+	pdf, err := bookingPDF(booking, event)
+	if err != nil {
+		return "", "", nil, err
+	}
+	attachments = append(attachments, EmailAttachment{Filename: bookingPDFFilename(booking), ContentType: "application/pdf", Data: pdf})
+
+	subject := "Your tickets - " + event.Name
+	body := fmt.Sprintf(bookingTicketsTemplate, booking.FirstName, booking.LastName, booking.ID, event.Name,
+		event.Date.Format("Jan 2, 2006 15:04"), event.Location, lines.String())
+	return subject, body, attachments, nil
+}
+
+// viewableTicket loads the {id} ticket with its booking and event, answering 404 unless the
+// signed-in user may see the booking.
+func viewableTicket(w http.ResponseWriter, r *http.Request) (Ticket, EventBooking, Event, bool) {
+	ticket, err := store.GetTicket(strings.ToUpper(r.PathValue("id")))
+	if err != nil {
+		http.NotFound(w, r)
+		return Ticket{}, EventBooking{}, Event{}, false
+	}
+	booking, err := store.GetBooking(ticket.BookingID)
+	user, _ := currentUser(r)
+	if err != nil || !canSeeBooking(user, booking) {
+		http.NotFound(w, r)
+		return Ticket{}, EventBooking{}, Event{}, false
+	}
+	event, err := store.GetEvent(booking.EventID)
+	if err != nil {
+		http.Error(w, "Failed to load event", http.StatusInternalServerError)
+		return Ticket{}, EventBooking{}, Event{}, false
+	}
+	return ticket, booking, event, true
+}
+
+// ticketHandler shows a ticket with its QR code, ready to print or show at the door.
+func ticketHandler(w http.ResponseWriter, r *http.Request) {
+	ticket, booking, event, ok := viewableTicket(w, r)
+	if !ok {
+		return
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Ticket {{.Ticket.ID}} - {{.Event.Name}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 500px; margin: 0 auto; padding: 20px; }
+        .ticket { border: 2px dashed #333; padding: 20px; text-align: center; }
+        .code { font-family: monospace; font-size: 20px; letter-spacing: 2px; }
+        .error { color: red; }
+        @media print { .no-print { display: none; } }
+    </style>
+</head>
+<body>
+    <div class="ticket">
+        <h1>{{.Event.Name}}</h1>
+        <p>{{.Event.Date.Format "Monday, Jan 2, 2006 15:04"}}<br>{{.Event.Location}}</p>
+        <p><strong>{{.Booking.FirstName}} {{.Booking.LastName}}</strong><br>{{.Description}}</p>
+        <p>Ticket {{.Ticket.Number}} of {{len .Booking.Tickets}} - booking #{{.Booking.ID}}</p>
+        {{if eq .Booking.Status "confirmed"}}
+        <img src="/tickets/{{.Ticket.ID}}/qr.png" alt="QR code for ticket {{.Ticket.ID}}" width="296" height="296">
+        {{else if eq .Booking.Status "pending"}}
+        <p class="error">This booking is not confirmed yet. The QR code appears once it is paid for or your email address is verified.</p>
+        {{else}}
+        <p class="error">This ticket is not valid: the booking is {{.Booking.Status}}.</p>
+        {{end}}
+        <p class="code">{{.Ticket.ID}}</p>
+        {{with .Ticket.CheckedInAt}}<p>Checked in {{.Local.Format "Jan 2, 2006 15:04"}}{{if $.Ticket.Gate}}, gate {{$.Ticket.Gate}}{{end}}</p>{{end}}
+    </div>
+    <p class="no-print"><a href="/my-bookings">My Bookings</a> | <a href="#" onclick="window.print(); return false;">Print</a></p>
+</body>
+</html>`
+
+	t, _ := template.New("ticket").Parse(tmpl)
+	data := struct {
+		Ticket      Ticket
+		Booking     EventBooking
+		Event       Event
+		Description string
+	}{
+		Ticket:      ticket,
+		Booking:     booking,
+		Event:       event,
+		Description: ticketDescription(booking, ticket),
+	}
+	t.Execute(w, data)
+}
+
+// ticketQRHandler serves the QR code of a confirmed booking's ticket as a PNG image.
+func ticketQRHandler(w http.ResponseWriter, r *http.Request) {
+	ticket, booking, event, ok := viewableTicket(w, r)
+	if !ok {
+		return
+	}
+	if booking.Status != "confirmed" {
+		http.Error(w, "Ticket is not valid", http.StatusNotFound)
+		return
+	}
+	png, err := ticketQRCode(event.ID, ticket)
+	if err != nil {
+		http.Error(w, "Failed to draw QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(png)
+}
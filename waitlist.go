@@ -0,0 +1,581 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WaitlistEntry is a customer waiting for tickets to a sold-out event. When tickets come back, the first
+// waiting customer whose order fits is offered them: the tickets are held as a pending booking until
+// the offer expires, and then pass to the next customer in line.
+type WaitlistEntry struct {
+	ID           int        `json:"id"`
+	EventID      int        `json:"event_id"`
+	UserID       int        `json:"user_id"`
+	FirstName    string     `json:"first_name"`
+	LastName     string     `json:"last_name"`
+	Email        string     `json:"email"`
+	TicketTypeID int        `json:"ticket_type_id,omitempty"` // 0: standard admission, or the only tier on sale
+	Tickets      int        `json:"tickets"`
+	Status       string     `json:"status"`                  // waiting, offered, claimed, expired or left
+	BookingID    int        `json:"booking_id,omitempty"`    // the held booking of the offer
+	OfferExpires *time.Time `json:"offer_expires,omitempty"` // when the offer passes to the next customer
+	Created      time.Time  `json:"created"`
+}
+
+// Open reports whether the customer is still waiting or holding an offer.
+func (e WaitlistEntry) Open() bool {
+	return e.Status == "waiting" || e.Status == "offered"
+}
+
+// errAlreadyWaitlisted is returned when joining a waitlist the customer is already on.
+var errAlreadyWaitlisted = errors.New("you are already on the waitlist for this event")
+
+// errOfferExpired is returned when claiming an offer that has passed to the next customer.
+var errOfferExpired = errors.New("this waitlist offer has expired")
+
+// defaultWaitlistOfferTTL is how long a waitlist offer holds tickets when WAITLIST_OFFER_TTL is not set.
+const defaultWaitlistOfferTTL = 24 * time.Hour
+
+// getWaitlistOfferTTL retrieves the offer duration from the WAITLIST_OFFER_TTL environment variable (e.g. "2h").
+func getWaitlistOfferTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("WAITLIST_OFFER_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultWaitlistOfferTTL
+	}
+	return ttl
+}
+
+// waitlistMu keeps offers from being made, expired and claimed at the same time.
+var waitlistMu sync.Mutex
+
+// joinWaitlist puts userID in line for order's tickets to an event. If the tickets are free already,
+// the offer is made straight away.
+func joinWaitlist(eventID, userID int, firstName, lastName, email string, order TicketOrder) (WaitlistEntry, error) {
+	event, err := store.GetEvent(eventID)
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	if !event.Active {
+		return WaitlistEntry{}, errEventInactive
+	}
+	isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(firstName, lastName, email, uint(max(order.Quantity, 0)), maxTicketsPerOrder)
+	switch {
+	case !isValidName:
+		return WaitlistEntry{}, validationError("first and last name must be at least 2 characters")
+	case !isValidEmail:
+		return WaitlistEntry{}, validationError("email is invalid")
+	case !isValidTicketNumber:
+		return WaitlistEntry{}, validationError(fmt.Sprintf("tickets must be between 1 and %d", maxTicketsPerOrder))
+	}
+	if _, _, _, err := priceOrder(event, []TicketOrder{order}); err != nil {
+		return WaitlistEntry{}, err
+	}
+
+	entry, err := store.CreateWaitlistEntry(WaitlistEntry{
+		EventID:      eventID,
+		UserID:       userID,
+		FirstName:    firstName,
+		LastName:     lastName,
+		Email:        email,
+		TicketTypeID: order.TicketTypeID,
+		Tickets:      order.Quantity,
+		Status:       "waiting",
+		Created:      time.Now(),
+	})
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	offerFreedTickets(eventID)
+	return store.GetWaitlistEntry(entry.ID)
+}
+
+// offerFreedTickets offers an event's remaining tickets to its waitlist. Call it whenever tickets
+// return to the event; failures are logged, since the hold sweeper retries every minute.
+func offerFreedTickets(eventID int) {
+	if err := processWaitlist(eventID); err != nil {
+		fmt.Printf("Error offering tickets to the waitlist for event %d: %v\n", eventID, err)
+	}
+}
+
+// processWaitlists settles lapsed offers and makes new ones on every event with customers waiting.
+func processWaitlists() error {
+	entries, err := store.ListWaitlist(0)
+	if err != nil {
+		return err
+	}
+	seen := map[int]bool{}
+	for _, entry := range entries {
+		if !entry.Open() || seen[entry.EventID] {
+			continue
+		}
+		seen[entry.EventID] = true
+		if err := processWaitlist(entry.EventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processWaitlist expires an event's lapsed offers, then offers its remaining tickets to waiting
+// customers in the order they joined, skipping those who want more tickets than are free.
+func processWaitlist(eventID int) error {
+	waitlistMu.Lock()
+	defer waitlistMu.Unlock()
+
+	entries, err := store.ListWaitlist(eventID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Status == "offered" {
+			if err := settleOffer(entry, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	event, err := store.GetEvent(eventID)
+	if err != nil {
+		return err
+	}
+	if !event.Active {
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.Status != "waiting" {
+			continue
+		}
+		if event.RemainingTickets < entry.Tickets {
+			continue
+		}
+		err := makeOffer(event, entry, now)
+		var invalid validationError
+		if errors.Is(err, errSoldOut) || errors.As(err, &invalid) {
+			// Their tier is sold out or no longer on sale; someone further down may still fit.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if event, err = store.GetEvent(eventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleOffer closes an offer whose booking was confirmed or released, or whose time is up.
+// The caller holds waitlistMu.
+func settleOffer(entry WaitlistEntry, now time.Time) error {
+	booking, err := store.GetBooking(entry.BookingID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case booking.Status == "confirmed":
+		entry.Status = "claimed"
+	case booking.Status != "pending":
+		entry.Status = "expired"
+	case entry.OfferExpires != nil && !now.Before(*entry.OfferExpires):
+		if err := store.ReleaseHold(booking.ID, "expired"); err != nil && !errors.Is(err, errNotPending) {
+			return err
+		}
+		entry.Status = "expired"
+	default:
+		return nil
+	}
+	return store.UpdateWaitlistEntry(entry)
+}
+
+// makeOffer holds the entry's tickets for the customer and emails them the offer. The caller holds waitlistMu.
+func makeOffer(event Event, entry WaitlistEntry, now time.Time) error {
+	booking, err := priceBooking(event, []TicketOrder{{TicketTypeID: entry.TicketTypeID, Quantity: entry.Tickets}}, "", entry.UserID)
+	if err != nil {
+		return err
+	}
+	expires := now.UTC().Add(getWaitlistOfferTTL())
+	booking.FirstName = entry.FirstName
+	booking.LastName = entry.LastName
+	booking.Email = entry.Email
+	booking.BookingDate = now
+	booking.Status = "pending"
+	booking.HoldExpires = &expires
+	booking, err = store.ReserveTickets(booking)
+	if err != nil {
+		return err
+	}
+
+	entry.Status = "offered"
+	entry.BookingID = booking.ID
+	entry.OfferExpires = &expires
+	if err := store.UpdateWaitlistEntry(entry); err != nil {
+		store.ReleaseHold(booking.ID, "cancelled")
+		return err
+	}
+	sendInBackground(func() { sendWaitlistOfferEmail(entry, event, booking) })
+	return nil
+}
+
+// claimWaitlistOffer takes up one of the user's offers. A free booking is confirmed at once; a paid
+// one is returned still held, to be confirmed by paying for it before the offer runs out.
+func claimWaitlistOffer(entryID, userID int) (EventBooking, error) {
+	waitlistMu.Lock()
+	defer waitlistMu.Unlock()
+
+	entry, err := store.GetWaitlistEntry(entryID)
+	if err != nil || entry.UserID != userID {
+		return EventBooking{}, errNotFound
+	}
+	if entry.Status == "expired" || (entry.Status == "offered" && entry.OfferExpires != nil && !time.Now().Before(*entry.OfferExpires)) {
+		return EventBooking{}, errOfferExpired
+	}
+	if entry.Status != "offered" {
+		return EventBooking{}, validationError("there is no offer to claim")
+	}
+	if awaitingVerification(userID) {
+		return EventBooking{}, validationError("verify your email address to claim your tickets")
+	}
+
+	booking, err := store.GetBooking(entry.BookingID)
+	if err != nil {
+		return EventBooking{}, err
+	}
+	if booking.Status != "pending" {
+		return EventBooking{}, errOfferExpired
+	}
+	if booking.AwaitsPayment() {
+		// The payment confirms the booking, and the hold sweeper then marks the offer claimed.
+		return booking, nil
+	}
+
+	if err := store.ConfirmBooking(entry.BookingID); errors.Is(err, errNotPending) {
+		return EventBooking{}, errOfferExpired
+	} else if err != nil {
+		return EventBooking{}, err
+	}
+	entry.Status = "claimed"
+	if err := store.UpdateWaitlistEntry(entry); err != nil {
+		return EventBooking{}, err
+	}
+	reloadDefaultEvent(entry.EventID)
+	booking, err = store.GetBooking(entry.BookingID)
+	if err == nil {
+		sendInBackground(func() { sendTicketConfirmation(bookingConfirmation(booking)) })
+	}
+	return booking, err
+}
+
+// leaveWaitlist takes the user off a waitlist, declining their offer if they hold one so it passes
+// to the next customer.
+func leaveWaitlist(entryID, userID int) error {
+	entry, err := closeWaitlistEntry(entryID, userID)
+	if err != nil {
+		return err
+	}
+	offerFreedTickets(entry.EventID)
+	return nil
+}
+
+// closeWaitlistEntry marks one of the user's entries as left, releasing any tickets held for it.
+func closeWaitlistEntry(entryID, userID int) (WaitlistEntry, error) {
+	waitlistMu.Lock()
+	defer waitlistMu.Unlock()
+
+	entry, err := store.GetWaitlistEntry(entryID)
+	if err != nil || entry.UserID != userID {
+		return WaitlistEntry{}, errNotFound
+	}
+	if !entry.Open() {
+		return WaitlistEntry{}, validationError("you are no longer on this waitlist")
+	}
+	if entry.Status == "offered" {
+		if err := store.ReleaseHold(entry.BookingID, "cancelled"); err != nil && !errors.Is(err, errNotPending) {
+			return WaitlistEntry{}, err
+		}
+	}
+	entry.Status = "left"
+	return entry, store.UpdateWaitlistEntry(entry)
+}
+
+// waitlistPosition is how many customers are waiting ahead of entry, counting from 1.
+func waitlistPosition(entry WaitlistEntry) (int, error) {
+	entries, err := store.ListWaitlist(entry.EventID)
+	if err != nil {
+		return 0, err
+	}
+	position := 1
+	for _, other := range entries {
+		if other.ID < entry.ID && other.Status == "waiting" {
+			position++
+		}
+	}
+	return position, nil
+}
+
+// waitlistOfferEmailTemplate is the email template for waitlist offers.
+const waitlistOfferEmailTemplate = `Dear %s %s,
+
+Good news: tickets have come up for %s.
+
+We are holding %d ticket(s) for you, %.2f %s in total, until %s.
+Claim them here before then, or they will be offered to the next person on the waitlist:
+
+%s
+
+Best regards,
+Booking Team
+`
+
+// sendWaitlistOfferEmail tells a waiting customer tickets are held for them, or simulates sending if it fails.
+func sendWaitlistOfferEmail(entry WaitlistEntry, event Event, booking EventBooking) {
+	link := getAppBaseURL() + "/waitlist"
+	subject := "Tickets available - " + event.Name
+	body := fmt.Sprintf(waitlistOfferEmailTemplate, entry.FirstName, entry.LastName, event.Name, booking.NumberOfTickets,
+		booking.TotalAmount, event.Currency, entry.OfferExpires.Local().Format("Jan 2, 2006 15:04"), link)
+
+	sendOrSimulate(entry.Email, subject, body,
+		fmt.Sprintf("Sending waitlist offer of %d tickets for %s to %s: %s", booking.NumberOfTickets, event.Name, entry.Email, link))
+}
+
+// waitlistHandler shows the user's waitlists, with their place in line and any offers to claim.
+func waitlistHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Your Waitlists</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
+        th { background-color: #f2f2f2; }
+        form.inline { display: inline; }
+        .error { color: red; }
+        .success { color: green; }
+    </style>
+</head>
+<body>
+    <h1>Your Waitlists</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
+
+    <table>
+        <tr>
+            <th>Event</th>
+            <th>Tickets</th>
+            <th>Joined</th>
+            <th>Status</th>
+            <th></th>
+        </tr>
+        {{range .Entries}}
+        <tr>
+            <td>{{.Event}}</td>
+            <td>{{.Tickets}}{{if .Tier}} {{.Tier}}{{end}}</td>
+            <td>{{.Created.Local.Format "Jan 2, 2006 15:04"}}</td>
+            <td>
+                {{if eq .Status "waiting"}}number {{.Position}} in line
+                {{else if eq .Status "offered"}}held for you until {{.OfferExpires.Local.Format "Jan 2, 2006 15:04"}}
+                {{else}}{{.Status}}{{end}}
+            </td>
+            <td>
+                {{if eq .Status "offered"}}
+                <form class="inline" method="POST" action="/waitlist/{{.ID}}/claim">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Claim tickets</button>
+                </form>
+                {{end}}
+                {{if .Open}}
+                <form class="inline" method="POST" action="/waitlist/{{.ID}}/leave">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">{{if eq .Status "offered"}}Decline{{else}}Leave waitlist{{end}}</button>
+                </form>
+                {{end}}
+            </td>
+        </tr>
+        {{else}}
+        <tr><td colspan="5">You are not on any waitlists.</td></tr>
+        {{end}}
+    </table>
+    <p><a href="/events">Back to Events</a> | <a href="/my-bookings">My Bookings</a></p>
+</body>
+</html>`
+
+	user, _ := currentUser(r)
+	entries, err := store.ListUserWaitlist(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load waitlists", http.StatusInternalServerError)
+		return
+	}
+
+	// waitlistRow is an entry with the names and place in line the page shows.
+	type waitlistRow struct {
+		WaitlistEntry
+		Event    string
+		Tier     string
+		Position int
+	}
+	rows := make([]waitlistRow, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		row := waitlistRow{WaitlistEntry: entries[i]}
+		if event, err := store.GetEvent(row.EventID); err == nil {
+			row.Event = event.Name
+		}
+		if row.TicketTypeID != 0 {
+			if tier, err := store.GetTicketType(row.TicketTypeID); err == nil {
+				row.Tier = tier.Name
+			}
+		}
+		if row.Status == "waiting" {
+			row.Position, _ = waitlistPosition(row.WaitlistEntry)
+		}
+		rows = append(rows, row)
+	}
+
+	t, _ := template.New("waitlist").Parse(tmpl)
+	data := struct {
+		Entries   []waitlistRow
+		Message   string
+		Error     string
+		CSRFToken string
+	}{
+		Entries:   rows,
+		Message:   r.URL.Query().Get("message"),
+		Error:     r.URL.Query().Get("error"),
+		CSRFToken: csrfToken(w, r),
+	}
+	t.Execute(w, data)
+}
+
+// joinWaitlistHandler shows the form to join an event's waitlist and adds the user to it.
+func joinWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	event, err := store.GetEvent(id)
+	if err != nil || !event.Active {
+		http.NotFound(w, r)
+		return
+	}
+	tiers, err := onSaleTicketTypes(event.ID)
+	if err != nil {
+		http.Error(w, "Failed to load ticket types", http.StatusInternalServerError)
+		return
+	}
+
+	user, _ := currentUser(r)
+	errMessage := r.URL.Query().Get("error")
+	if r.Method == "POST" {
+		tickets, _ := strconv.Atoi(r.FormValue("tickets"))
+		tierID, _ := strconv.Atoi(r.FormValue("ticket_type_id"))
+		_, err := joinWaitlist(event.ID, user.ID, r.FormValue("firstName"), r.FormValue("lastName"), r.FormValue("email"),
+			TicketOrder{TicketTypeID: tierID, Quantity: tickets})
+		if err == nil {
+			http.Redirect(w, r, "/waitlist?message="+url.QueryEscape("You are on the waitlist for "+event.Name), http.StatusSeeOther)
+			return
+		}
+		errMessage = err.Error()
+	}
+
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Waitlist - {{.Event.Name}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px; }
+        input, select { width: 100%; padding: 8px; margin-bottom: 10px; }
+        button { background-color: #4CAF50; color: white; padding: 10px 20px; border: none; cursor: pointer; }
+        .error { color: red; }
+    </style>
+</head>
+<body>
+    <h1>Join the Waitlist for {{.Event.Name}}</h1>
+
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    <p>{{.Event.RemainingTickets}} of {{.Event.TotalTickets}} tickets left. If tickets come back, we will hold them for
+       the first person in line whose order fits and email you when it is your turn.</p>
+
+    <form method="POST" action="/events/{{.Event.ID}}/waitlist">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="text" name="firstName" placeholder="First name" value="{{.FirstName}}" required>
+        <input type="text" name="lastName" placeholder="Last name" value="{{.LastName}}" required>
+        <input type="email" name="email" placeholder="Email" value="{{.Email}}" required>
+        {{if .Tiers}}
+        <select name="ticket_type_id">
+            {{range .Tiers}}<option value="{{.ID}}">{{.Name}} ({{printf "%.2f" .Price}} {{$.Event.Currency}})</option>{{end}}
+        </select>
+        {{end}}
+        <input type="number" name="tickets" min="1" value="{{.Tickets}}" required>
+        <button type="submit">Join waitlist</button>
+    </form>
+    <p><a href="/events">Back to Events</a></p>
+</body>
+</html>`
+
+	tickets := r.FormValue("tickets")
+	if tickets == "" {
+		tickets = "1"
+	}
+	t, _ := template.New("join-waitlist").Parse(tmpl)
+	data := struct {
+		Event     Event
+		Tiers     []TicketType
+		FirstName string
+		LastName  string
+		Email     string
+		Tickets   string
+		Error     string
+		CSRFToken string
+	}{
+		Event:     event,
+		Tiers:     tiers,
+		FirstName: r.FormValue("firstName"),
+		LastName:  r.FormValue("lastName"),
+		Email:     r.FormValue("email"),
+		Tickets:   tickets,
+		Error:     errMessage,
+		CSRFToken: csrfToken(w, r),
+	}
+	if data.Email == "" {
+		data.Email = user.Email
+	}
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	t.Execute(w, data)
+}
+
+// claimWaitlistHandler books the tickets held by one of the user's offers.
+func claimWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	user, _ := currentUser(r)
+	booking, err := claimWaitlistOffer(id, user.ID)
+	if err != nil {
+		http.Redirect(w, r, "/waitlist?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	if booking.AwaitsPayment() {
+		http.Redirect(w, r, checkoutURL(booking), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/my-bookings?message="+url.QueryEscape("Booking successful!"), http.StatusSeeOther)
+}
+
+// leaveWaitlistHandler takes the user off a waitlist or declines their offer.
+func leaveWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	user, _ := currentUser(r)
+	if err := leaveWaitlist(id, user.ID); err != nil {
+		http.Redirect(w, r, "/waitlist?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/waitlist?message="+url.QueryEscape("You have left the waitlist"), http.StatusSeeOther)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -14,6 +15,7 @@ type PageData struct {
 	Bookings         []UserData
 	Message          string
 	Error            string
+	CSRFToken        string
 }
 
 func startWebServer() {
@@ -49,6 +51,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
     {{if .Message}}<p class="success">{{.Message}}</p>{{end}}
     
     <form method="POST" action="/book">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
         <div class="form-group">
             <label>First Name:</label>
             <input type="text" name="firstName" required>
@@ -76,7 +79,8 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	data := PageData{
 		EventName:        eventName,
 		TotalTickets:     eventTickets,
-		RemainingTickets: remainingTickets,
+		RemainingTickets: ticketsLeft(),
+		CSRFToken:        csrfToken(w, r),
 	}
 	t.Execute(w, data)
 }
@@ -95,14 +99,31 @@ func bookHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		
 		userTickets := uint(tickets)
-		isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(firstName, lastName, email, userTickets, remainingTickets)
+		isValidName, isValidEmail, isValidTicketNumber := ValidateUserInput(firstName, lastName, email, userTickets, ticketsLeft())
 		
 		if !isValidName || !isValidEmail || !isValidTicketNumber {
 			http.Redirect(w, r, "/?error=Invalid input data", http.StatusSeeOther)
 			return
 		}
 		
-		bookTicket(userTickets, firstName, lastName, email)
+		user, _ := currentUser(r)
+		booking, err := bookEventTicket(defaultEventID, user.ID, firstName, lastName, email, ticketOrders(int(userTickets)), nil, "")
+		if err != nil {
+			http.Redirect(w, r, "/?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+		
+		reloadDefaultEvent(defaultEventID)
+		if booking.AwaitsPayment() {
+			http.Redirect(w, r, checkoutURL(*booking), http.StatusSeeOther)
+			return
+		}
+		if booking.Status == "pending" {
+			// The ticket goes out once the email address is verified.
+			http.Redirect(w, r, "/?message="+url.QueryEscape(verifyToConfirmMessage), http.StatusSeeOther)
+			return
+		}
+		wg.Add(1)
 		go sendTicket(userTickets, firstName, lastName, email)
 		
 		http.Redirect(w, r, "/?message=Booking successful!", http.StatusSeeOther)
@@ -112,12 +133,14 @@ func bookHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// bookingsHandler lists the default event's bookings. Customers see only their own; staff with
+// permViewAllBookings see everyone's.
 func bookingsHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := `
 <!DOCTYPE html>
 <html>
 <head>
-    <title>All Bookings</title>
+    <title>{{if .All}}All Bookings{{else}}Your Bookings{{end}}</title>
     <style>
         body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
         table { width: 100%; border-collapse: collapse; }
@@ -126,20 +149,28 @@ func bookingsHandler(w http.ResponseWriter, r *http.Request) {
     </style>
 </head>
 <body>
-    <h1>All Bookings</h1>
+    <h1>{{if .All}}All Bookings{{else}}Your Bookings{{end}}</h1>
     <table>
         <tr>
             <th>First Name</th>
             <th>Last Name</th>
             <th>Email</th>
             <th>Tickets</th>
+            <th>Price</th>
+            <th>Discount</th>
+            <th>Paid</th>
+            <th></th>
         </tr>
         {{range .Bookings}}
         <tr>
-            <td>{{.firstName}}</td>
-            <td>{{.lastName}}</td>
-            <td>{{.email}}</td>
-            <td>{{.numberOfTickets}}</td>
+            <td>{{.FirstName}}</td>
+            <td>{{.LastName}}</td>
+            <td>{{.Email}}</td>
+            <td>{{.NumberOfTickets}}</td>
+            <td>{{printf "%.2f" .OriginalAmount}}</td>
+            <td>{{if .DiscountCode}}{{printf "%.2f" .DiscountAmount}} ({{.DiscountCode}}){{end}}</td>
+            <td>{{printf "%.2f" .TotalAmount}}</td>
+            <td>{{if eq .Status "confirmed"}}<a href="/bookings/{{.ID}}/pdf">PDF</a>{{end}}</td>
         </tr>
         {{end}}
     </table>
@@ -147,9 +178,32 @@ func bookingsHandler(w http.ResponseWriter, r *http.Request) {
 </body>
 </html>`
 
+	all, err := store.ListBookings()
+	if err != nil {
+		http.Error(w, "Failed to load bookings", http.StatusInternalServerError)
+		return
+	}
+
+	user, _ := currentUser(r)
+	seeAll := user.Can(permViewAllBookings)
+	list := make([]EventBooking, 0)
+	for _, booking := range all {
+		if booking.EventID != defaultEventID || !booking.HoldsTickets() {
+			continue
+		}
+		if !seeAll && booking.UserID != user.ID {
+			continue
+		}
+		list = append(list, booking)
+	}
+
 	t, _ := template.New("bookings").Parse(tmpl)
-	data := PageData{
-		Bookings: bookings,
+	data := struct {
+		Bookings []EventBooking
+		All      bool
+	}{
+		Bookings: list,
+		All:      seeAll,
 	}
 	t.Execute(w, data)
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// maxWebhookBodyBytes caps the size of a webhook payload we are willing to read.
+const maxWebhookBodyBytes = 65536
+
+// getWebhookSecret retrieves the endpoint signing secret (whsec_...) from the environment.
+func getWebhookSecret() string {
+	return os.Getenv("STRIPE_WEBHOOK_SECRET")
+}
+
+// errWebhookNotConfigured is returned when webhooks arrive before a signing secret is set.
+var errWebhookNotConfigured = errors.New("webhook secret not configured")
+
+// stripeWebhookHandler receives payment provider events, verifies their signature and
+// applies each event to its booking exactly once.
+func stripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	event, err := paymentProvider.ParseWebhook(payload, r.Header.Get("Stripe-Signature"))
+	if errors.Is(err, errWebhookNotConfigured) {
+		http.Error(w, "Webhook secret not configured", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	isNew, err := store.RecordWebhookEvent(event.ID, event.Type)
+	if err != nil {
+		http.Error(w, "Could not record event", http.StatusInternalServerError)
+		return
+	}
+	if !isNew {
+		// Already handled; acknowledge so the provider stops redelivering.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := handlePaymentEvent(event); err != nil {
+		// Let the provider retry the delivery.
+		store.ForgetWebhookEvent(event.ID)
+		fmt.Printf("Error handling payment event %s (%s): %v\n", event.ID, event.Type, err)
+		http.Error(w, "Could not process event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePaymentEvent updates the booking matching a verified payment event.
+func handlePaymentEvent(event PaymentEvent) error {
+	switch event.Type {
+	case "payment_intent.succeeded":
+		booking, err := bookingForPaymentIntent(event.PaymentIntentID, event.Metadata)
+		if err != nil {
+			return err
+		}
+		// A payment is applied once, whether the webhook, /confirm-payment or the customer's return
+		// reports it first, so a booking cancelled since is never refunded a second time.
+		first, err := store.RecordWebhookEvent(event.PaymentIntentID, "payment_intent.applied")
+		if err != nil || !first {
+			return err
+		}
+		err = confirmHold(booking.ID)
+		if errors.Is(err, errSoldOut) || errors.Is(err, errNotPending) ||
+			errors.Is(err, errDiscountUsedUp) || errors.Is(err, errDiscountUserLimit) {
+			// Paid after the hold lapsed and the seats or the discount code's last use went to someone
+			// else, or after it was cancelled.
+			fmt.Printf("Booking %d was paid by %s but could not be confirmed: %v\n", booking.ID, event.PaymentIntentID, err)
+			err = refundUnconfirmedPayment(booking, event.PaymentIntentID)
+		}
+		if err != nil {
+			store.ForgetWebhookEvent(event.PaymentIntentID)
+		}
+		return err
+
+	case "payment_intent.payment_failed":
+		booking, err := bookingForPaymentIntent(event.PaymentIntentID, event.Metadata)
+		if err != nil {
+			return err
+		}
+		if booking.Status != "pending" {
+			return nil
+		}
+		err = store.ReleaseHold(booking.ID, "failed")
+		if errors.Is(err, errNotPending) {
+			return nil
+		}
+		if err == nil {
+			offerFreedTickets(booking.EventID)
+		}
+		return err
+
+	case "charge.refunded":
+		if event.PaymentIntentID == "" || !event.FullyRefunded {
+			// Partial refunds leave the booking in place.
+			return nil
+		}
+		booking, err := bookingForPaymentIntent(event.PaymentIntentID, event.Metadata)
+		if err != nil {
+			return err
+		}
+		if booking.Status == "refunded" || booking.Status == "cancelled" {
+			return nil
+		}
+		if _, err := store.ReleaseBooking(booking.ID, "refunded"); errors.Is(err, errAlreadyCancelled) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		offerFreedTickets(booking.EventID)
+		return nil
+	}
+
+	// Other event types are acknowledged and ignored.
+	return nil
+}
+
+// bookingForPaymentIntent finds the booking a payment intent pays for, by stored ID or by metadata.
+func bookingForPaymentIntent(paymentIntentID string, metadata map[string]string) (EventBooking, error) {
+	booking, err := store.GetBookingByPaymentIntent(paymentIntentID)
+	if !errors.Is(err, errNotFound) {
+		return booking, err
+	}
+
+	bookingID, convErr := strconv.Atoi(metadata["booking_id"])
+	if convErr != nil {
+		return EventBooking{}, fmt.Errorf("no booking for payment intent %s", paymentIntentID)
+	}
+	return store.GetBooking(bookingID)
+}
+
+// sendWebhookFixture signs a fixture payload the way the active provider expects and posts it to url,
+// for exercising the webhook handler without Stripe.
+func sendWebhookFixture(url, path string) error {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	secret := getWebhookSecret()
+	if paymentProvider.Name() == "fake" {
+		secret = os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	}
+	req.Header.Set("Stripe-Signature", signWebhookPayload(payload, secret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("%s -> %s\n", path, resp.Status)
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fixtureIntentID is the payment intent every testdata/stripe fixture refers to.
+const fixtureIntentID = "pi_fixture_1"
+
+// readFixture reads a webhook fixture from testdata/stripe.
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", "stripe", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// forEachWebhookProvider runs test with each provider taking webhooks signed with testWebhookSecret.
+// Both read the same Stripe-format events, so one set of fixtures covers them.
+func forEachWebhookProvider(t *testing.T, test func(t *testing.T)) {
+	t.Run("fake", func(t *testing.T) {
+		useFakePayments(t)
+		test(t)
+	})
+	t.Run("stripe", func(t *testing.T) {
+		t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+		previous := paymentProvider
+		paymentProvider = newStripePaymentProvider()
+		t.Cleanup(func() { paymentProvider = previous })
+		test(t)
+	})
+}
+
+// bookFixtureBooking records booking 1, two tickets with the given status to a fresh event, paid for
+// by the fixtures' payment intent.
+func bookFixtureBooking(t *testing.T, status string) (Event, EventBooking) {
+	t.Helper()
+	useStore(t, newTestSQLiteStore(t))
+	event := createTestEvent(t, 10, 50)
+	booking, err := store.ReserveTickets(draftTestBooking(t, event.ID, 2, status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booking.ID != 1 {
+		t.Fatalf("booking %d, want 1 to match the fixtures", booking.ID)
+	}
+	if err := store.SetPaymentIntent(booking.ID, fixtureIntentID); err != nil {
+		t.Fatal(err)
+	}
+	return event, booking
+}
+
+// TestWebhookFixtures delivers each signed fixture and checks the booking moves to the status it reports.
+func TestWebhookFixtures(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		from, to    string
+		ticketsLeft int
+	}{
+		{"payment_intent_succeeded.json", "pending", "confirmed", 8},
+		{"payment_intent_payment_failed.json", "pending", "failed", 10},
+		{"charge_refunded.json", "confirmed", "refunded", 10},
+	}
+	forEachWebhookProvider(t, func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.fixture, func(t *testing.T) {
+				event, booking := bookFixtureBooking(t, tt.from)
+
+				deliverSignedWebhook(t, readFixture(t, tt.fixture))
+				if status := bookingStatus(t, booking.ID); status != tt.to {
+					t.Fatalf("booking is %s, want %s", status, tt.to)
+				}
+				if left := eventTicketsLeft(t, event.ID); left != tt.ticketsLeft {
+					t.Fatalf("%d tickets left, want %d", left, tt.ticketsLeft)
+				}
+			})
+		}
+	})
+}
+
+// TestWebhookRejectsBadSignatures turns away unsigned, wrongly signed and altered events without
+// touching the booking.
+func TestWebhookRejectsBadSignatures(t *testing.T) {
+	forEachWebhookProvider(t, func(t *testing.T) {
+		_, booking := bookFixtureBooking(t, "pending")
+		payload := readFixture(t, "payment_intent_succeeded.json")
+		altered := readFixture(t, "payment_intent_payment_failed.json")
+
+		deliveries := []struct {
+			name      string
+			payload   []byte
+			signature string
+		}{
+			{"unsigned", payload, ""},
+			{"wrong secret", payload, signWebhookPayload(payload, "whsec_other")},
+			{"altered payload", altered, signWebhookPayload(payload, testWebhookSecret)},
+			{"malformed header", payload, "not-a-signature"},
+		}
+		for _, d := range deliveries {
+			if rec := deliverWebhook(d.payload, d.signature); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: %d, want 400", d.name, rec.Code)
+			}
+		}
+		if status := bookingStatus(t, booking.ID); status != "pending" {
+			t.Fatalf("booking is %s, want pending", status)
+		}
+	})
+}
+
+// TestWebhookRedelivery applies an event once however often it is delivered, but processes it again
+// when an earlier delivery failed.
+func TestWebhookRedelivery(t *testing.T) {
+	forEachWebhookProvider(t, func(t *testing.T) {
+		useStore(t, newTestSQLiteStore(t))
+		payload := readFixture(t, "payment_intent_succeeded.json")
+
+		// No booking yet: the delivery fails and is left for the provider to retry.
+		if rec := deliverWebhook(payload, signWebhookPayload(payload, testWebhookSecret)); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("delivery before the booking exists: %d, want 500", rec.Code)
+		}
+
+		event := createTestEvent(t, 10, 50)
+		booking, err := store.ReserveTickets(draftTestBooking(t, event.ID, 2, "pending"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetPaymentIntent(booking.ID, fixtureIntentID); err != nil {
+			t.Fatal(err)
+		}
+		deliverSignedWebhook(t, payload)
+		if status := bookingStatus(t, booking.ID); status != "confirmed" {
+			t.Fatalf("booking is %s after the retry, want confirmed", status)
+		}
+
+		// The customer cancels; a late redelivery of the same event must not confirm the booking again.
+		if _, err := store.ReleaseBooking(booking.ID, "cancelled"); err != nil {
+			t.Fatal(err)
+		}
+		deliverSignedWebhook(t, payload)
+		if status := bookingStatus(t, booking.ID); status != "cancelled" {
+			t.Fatalf("booking is %s after the redelivery, want cancelled", status)
+		}
+		if left := eventTicketsLeft(t, event.ID); left != 10 {
+			t.Fatalf("%d tickets left, want 10", left)
+		}
+		if isNew, err := store.RecordWebhookEvent("evt_fixture_pi_succeeded", "payment_intent.succeeded"); err != nil || isNew {
+			t.Fatalf("event recorded as new (%v), want it remembered", err)
+		}
+	})
+}